@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// RequestIDHeader carries the request ID, generated if the client sends none
+const RequestIDHeader = "X-Request-ID"
+
+// Change is the before and after value of a single field
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// Entry is an append-only record of a mutation
+type Entry struct {
+	ID        uint64    `json:"id" storm:"id,increment"`
+	Resource  string    `json:"resource" storm:"index"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	Time      time.Time `json:"time"`
+	Changes   []Change  `json:"changes"`
+}
+
+// Source identifies who made a request
+type Source struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+// DBPath is the database holding the entries. Entries are written in the
+// transaction of the change they describe, so it is the database of the
+// audited resources.
+var DBPath = "users.db"
+
+type sourceKey struct{}
+
+// WithSource returns a context carrying the source of a request
+func WithSource(ctx context.Context, s Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, s)
+}
+
+// SourceFrom returns the source carried by ctx. Changes made without a
+// request are attributed to the system actor.
+func SourceFrom(ctx context.Context) Source {
+	if s, ok := ctx.Value(sourceKey{}).(Source); ok {
+		return s
+	}
+	return Source{Actor: "system"}
+}
+
+// FromRequest identifies the actor, request ID and IP address of a request.
+// The actor is the verified principal of the request, since credentials that
+// cannot be verified could name anyone.
+func FromRequest(r *http.Request) Source {
+	s := Source{Actor: "anonymous", RequestID: r.Header.Get(RequestIDHeader)}
+	if p, err := auth.Authenticate(r); err == nil && p != nil {
+		s.Actor = p.Key()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	s.IP = host
+	return s
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware makes the source of every request available to the handlers
+// through the request context, assigning a request ID when needed
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := FromRequest(r)
+		if s.RequestID == "" {
+			s.RequestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, s.RequestID)
+		next.ServeHTTP(w, r.WithContext(WithSource(r.Context(), s)))
+	})
+}
+
+// fields flattens the JSON representation of v into its top-level fields
+func fields(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return m
+	}
+	bd, err := json.Marshal(v)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(bd, &m)
+	return m
+}
+
+// Diff lists the fields that differ between the JSON representations of
+// before and after, sorted by name. Either side may be nil.
+func Diff(before, after interface{}) []Change {
+	b, a := fields(before), fields(after)
+	names := []string{}
+	for k := range b {
+		names = append(names, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	changes := []Change{}
+	for _, k := range names {
+		if !reflect.DeepEqual(b[k], a[k]) {
+			changes = append(changes, Change{Field: k, From: b[k], To: a[k]})
+		}
+	}
+	return changes
+}
+
+// Write appends an entry within the transaction of the change it describes,
+// filling in the source carried by ctx and the time
+func Write(ctx context.Context, tx storm.Node, e *Entry) error {
+	s := SourceFrom(ctx)
+	e.ID = 0
+	e.Actor = s.Actor
+	e.RequestID = s.RequestID
+	e.SourceIP = s.IP
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	return tx.Save(e)
+}
+
+// Find returns up to limit entries following the entry with ID after,
+// oldest first, optionally only those of a resource
+func Find(resource string, after uint64, limit int) ([]Entry, error) {
+	return FindContext(context.Background(), resource, after, limit)
+}
+
+// FindContext is Find in the database of the tenant carried by ctx
+func FindContext(ctx context.Context, resource string, after uint64, limit int) ([]Entry, error) {
+	db, err := storm.Open(tenant.FromContext(ctx).Path(DBPath))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	matchers := []q.Matcher{q.Gt("ID", after)}
+	if resource != "" {
+		matchers = append(matchers, q.Eq("Resource", resource))
+	}
+	entries := []Entry{}
+	err = db.Select(matchers...).OrderBy("ID").Limit(limit).Find(&entries)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/auth"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Remove(DBPath)
+	code := m.Run()
+	os.Remove(DBPath)
+	os.Exit(code)
+}
+
+type record struct {
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"`
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		txt    string
+		before interface{}
+		after  interface{}
+		expect []Change
+	}{
+		{"created", nil, &record{Name: "Ann"}, []Change{{Field: "name", To: "Ann"}}},
+		{"nil pointer", (*record)(nil), &record{Name: "Ann"}, []Change{{Field: "name", To: "Ann"}}},
+		{"updated", &record{Name: "Ann", Role: "Tester"}, &record{Name: "Ann", Role: "Admin"}, []Change{{Field: "role", From: "Tester", To: "Admin"}}},
+		{"field removed", &record{Name: "Ann", Role: "Tester"}, &record{Name: "Bob"}, []Change{{Field: "name", From: "Ann", To: "Bob"}, {Field: "role", From: "Tester"}}},
+		{"unchanged", &record{Name: "Ann"}, &record{Name: "Ann"}, []Change{}},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		if got := Diff(tc.before, tc.after); !reflect.DeepEqual(tc.expect, got) {
+			t.Errorf("Expected %v, got %v", tc.expect, got)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	auth.Username, auth.Password = "Peter", "password"
+	defer func() { auth.Username, auth.Password = "", "" }()
+	var got Source
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = SourceFrom(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.SetBasicAuth("Peter", "password")
+	r.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	expect := Source{Actor: "user:Peter", RequestID: "req-1", IP: "10.0.0.1"}
+	if got != expect {
+		t.Errorf("Expected source %+v, got %+v", expect, got)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.SetBasicAuth("Peter", "guess")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got.Actor != "anonymous" {
+		t.Errorf("Expected unverified credentials to be anonymous, got %+v", got)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/users", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got.Actor != "anonymous" || got.RequestID == "" {
+		t.Errorf("Expected an anonymous source with a request ID, got %+v", got)
+	}
+	if id := w.Header().Get(RequestIDHeader); id != got.RequestID {
+		t.Errorf("Expected request ID header %s, got %s", got.RequestID, id)
+	}
+
+	if s := SourceFrom(context.Background()); s.Actor != "system" {
+		t.Errorf("Expected the system actor without a request, got %+v", s)
+	}
+}
+
+func TestWriteFind(t *testing.T) {
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	ctx := WithSource(context.Background(), Source{Actor: "Peter", RequestID: "req-1", IP: "10.0.0.1"})
+	for _, res := range []string{"users/a", "users/b", "users/a", "users/a"} {
+		if err := Write(ctx, db, &Entry{Resource: res, Action: "updated"}); err != nil {
+			t.Fatalf("Error writing an entry: %s", err)
+		}
+	}
+	db.Close()
+
+	entries, err := Find("users/a", 0, 2)
+	if err != nil {
+		t.Fatalf("Error finding entries: %s", err)
+	}
+	if len(entries) != 2 || entries[0].ID != 1 || entries[1].ID != 3 {
+		t.Fatalf("Expected entries 1 and 3, got %+v", entries)
+	}
+	if entries[0].Actor != "Peter" || entries[0].SourceIP != "10.0.0.1" || entries[0].Time.IsZero() {
+		t.Errorf("Expected the source and time to be recorded, got %+v", entries[0])
+	}
+	entries, err = Find("users/a", entries[1].ID, 2)
+	if err != nil {
+		t.Fatalf("Error finding entries: %s", err)
+	}
+	if len(entries) != 1 || entries[0].ID != 4 {
+		t.Errorf("Expected entry 4, got %+v", entries)
+	}
+}
@@ -0,0 +1,148 @@
+// Package auth verifies the credentials of requests. Everything keyed or
+// attributed by client, such as rate limits, idempotency keys and audit
+// entries, uses the principal returned by Authenticate rather than the
+// credentials the client claims.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the header clients use to identify themselves with an API key
+const APIKeyHeader = "X-API-Key"
+
+// RolesClaim is the claim of bearer tokens listing the roles they grant, on
+// top of those of the user named by their sub claim
+const RolesClaim = "roles"
+
+// Kinds of principals
+const (
+	// KindOperator is the operator of the service, authenticated with basic
+	// auth. It is confined to the default tenant.
+	KindOperator = "user"
+	// KindToken is the holder of a bearer token signed with tenant.TokenSecret
+	KindToken = "token"
+	// KindAPIKey is a client identified by one of APIKeys
+	KindAPIKey = "key"
+)
+
+// Username and Password are the basic auth credentials of the operator.
+// Basic auth is refused when either is empty.
+var (
+	Username = ""
+	Password = ""
+)
+
+// APIKeys maps the API keys clients may send to their names
+var APIKeys = map[string]string{}
+
+// ErrUnauthenticated is returned for credentials that cannot be verified
+var ErrUnauthenticated = errors.New("invalid credentials")
+
+// Principal is the verified identity of a request
+type Principal struct {
+	Kind string
+	// Name is the operator username, the sub claim of a token or the name
+	// of an API key
+	Name string
+	// Tenant is the tenant claim of a token
+	Tenant string
+	// Roles are the roles claim of a token
+	Roles []string
+}
+
+// Key identifies the principal across requests
+func (p *Principal) Key() string {
+	if p.Kind == KindToken {
+		return p.Kind + ":" + p.Tenant + "/" + p.Name
+	}
+	return p.Kind + ":" + p.Name
+}
+
+// Operator reports whether the principal is the operator
+func (p *Principal) Operator() bool {
+	return p != nil && p.Kind == KindOperator
+}
+
+// equal compares secrets in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// CheckOperator reports whether username and password are the credentials of
+// the operator
+func CheckOperator(username, password string) bool {
+	return Username != "" && Password != "" && equal(username, Username) && equal(password, Password)
+}
+
+// ParseAPIKeys reads API keys given as comma separated name:key pairs
+func ParseAPIKeys(s string) map[string]string {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && name != "" && key != "" {
+			keys[key] = name
+		}
+	}
+	return keys
+}
+
+// Authenticate returns the principal of the request from its basic auth
+// credentials, its bearer token or its API key, in that order, or nil if it
+// has none. Credentials that cannot be verified are an error, so that they
+// are never mistaken for an identity.
+func Authenticate(r *http.Request) (*Principal, error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		if !CheckOperator(username, password) {
+			return nil, ErrUnauthenticated
+		}
+		return &Principal{Kind: KindOperator, Name: username}, nil
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		claims, err := tenant.Claims(r)
+		if err != nil || claims == nil {
+			return nil, ErrUnauthenticated
+		}
+		p := &Principal{Kind: KindToken}
+		p.Name, _ = claims["sub"].(string)
+		p.Tenant, _ = claims[tenant.Claim].(string)
+		roles, _ := claims[RolesClaim].([]interface{})
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				p.Roles = append(p.Roles, s)
+			}
+		}
+		return p, nil
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		for k, name := range APIKeys {
+			if equal(key, k) {
+				return &Principal{Kind: KindAPIKey, Name: name}, nil
+			}
+		}
+		return nil, ErrUnauthenticated
+	}
+	return nil, nil
+}
+
+// RequireOperator lets only requests of the operator through to next, and
+// asks the others for basic auth. OPTIONS requests only list the methods of
+// the route, so they need no credentials.
+func RequireOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p, err := Authenticate(r); err != nil || !p.Operator() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package authz
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+)
+
+// Admin is the role allowed to manage users, groups and their members
+const Admin = "admin"
+
+// Errors of authorization
+var (
+	// Returns ErrUnauthenticated when the request has no valid credentials
+	// of the operator or bearer token naming a user
+	ErrUnauthenticated = errors.New("a bearer token naming a user is required")
+	// Returns ErrForbidden when the user does not hold the required role
+	ErrForbidden = errors.New("role required")
+)
+
+// Subject returns the id of the user named by the sub claim of the bearer
+// token of the request, signed with tenant.TokenSecret
+func Subject(r *http.Request) (bson.ObjectId, error) {
+	claims, err := tenant.Claims(r)
+	if err != nil || claims == nil {
+		return "", ErrUnauthenticated
+	}
+	sub, _ := claims["sub"].(string)
+	if !bson.IsObjectIdHex(sub) {
+		return "", ErrUnauthenticated
+	}
+	return bson.ObjectIdHex(sub), nil
+}
+
+// granted reports whether the roles claim of the bearer token of the
+// request grants role. The claim is signed along with the tenant one, so
+// the issuer of tokens can name the admins of a tenant that has no users
+// yet.
+func granted(r *http.Request, role string) bool {
+	claims, _ := tenant.Claims(r)
+	roles, _ := claims[auth.RolesClaim].([]interface{})
+	for _, held := range roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks that the bearer token of the request grants role, or
+// that the user it names holds role, on its own or through one of its
+// groups, in the tenant of the request
+func Authorize(r *http.Request, role string) error {
+	if granted(r, role) {
+		return nil
+	}
+	id, err := Subject(r)
+	if err != nil {
+		return err
+	}
+	roles, err := user.RolesContext(r.Context(), id)
+	if err == storm.ErrNotFound {
+		return ErrForbidden
+	}
+	if err != nil {
+		return err
+	}
+	for _, held := range roles {
+		if held == role {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+// safe reports whether the method only reads
+func safe(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Permit checks that the request is made by the operator, who only reaches
+// the default tenant, or authorized for role by Authorize
+func Permit(r *http.Request, role string) error {
+	p, err := auth.Authenticate(r)
+	if err != nil {
+		return ErrUnauthenticated
+	}
+	if p.Operator() {
+		return nil
+	}
+	return Authorize(r, role)
+}
+
+// Require lets only the operator and users holding role make requests that
+// change data. Reads are left to next. Requests without valid credentials
+// get 401 Unauthorized and users lacking the role 403 Forbidden. Without
+// tenant.TokenSecret no token can be verified, so only the operator can
+// write.
+func Require(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if safe(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch err := Permit(r, role); err {
+		case nil:
+			next.ServeHTTP(w, r)
+		case ErrUnauthenticated:
+			w.Header().Add("WWW-Authenticate", `Basic realm="Restricted"`)
+			w.Header().Add("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case ErrForbidden:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package authz
+
+import (
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func token(t *testing.T, sub string) string {
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub}).SignedString(tenant.TokenSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + s
+}
+
+// grant returns a token whose roles claim grants role to a subject that is
+// not a user
+func grant(t *testing.T, role string) string {
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "issuer", auth.RolesClaim: []string{role}}).SignedString(tenant.TokenSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + s
+}
+
+func TestRequire(t *testing.T) {
+	os.Remove(user.DBPath)
+	defer os.Remove(user.DBPath)
+	admin := &user.User{ID: bson.NewObjectId(), Name: "Ann"}
+	member := &user.User{ID: bson.NewObjectId(), Name: "Bob"}
+	other := &user.User{ID: bson.NewObjectId(), Name: "Eve", Role: "editor"}
+	suspended := &user.User{ID: bson.NewObjectId(), Name: "Sam", Status: user.StatusSuspended}
+	for _, u := range []*user.User{admin, member, other, suspended} {
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+	for _, u := range []*user.User{admin, suspended} {
+		u.Role = Admin
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+	g := &user.Group{ID: bson.NewObjectId(), Name: "Admins", Role: Admin}
+	if err := g.Save(); err != nil {
+		t.Fatalf("Error saving a group: %s", err)
+	}
+	if err := user.AddMember(g.ID, member.ID); err != nil {
+		t.Fatalf("Error adding a member: %s", err)
+	}
+
+	h := Require(Admin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, auth string) int {
+		r := httptest.NewRequest(method, "/groups", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	auth.Username, auth.Password = "Peter", "password"
+	defer func() { auth.Username, auth.Password = "", "" }()
+	operator := "Basic UGV0ZXI6cGFzc3dvcmQ="
+
+	t.Log("Writes are refused without a token secret")
+	if got := serve(http.MethodPost, ""); got != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, got)
+	}
+	t.Log("The operator writes without a token secret")
+	if got := serve(http.MethodPost, operator); got != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, got)
+	}
+
+	tenant.TokenSecret = []byte("secret")
+	defer func() { tenant.TokenSecret = nil }()
+	testCases := []struct {
+		txt    string
+		method string
+		auth   string
+		status int
+	}{
+		{"Reads need no token", http.MethodGet, "", http.StatusOK},
+		{"Writes need a token", http.MethodPost, "", http.StatusUnauthorized},
+		{"Tokens must be signed", http.MethodPost, "Bearer nonsense", http.StatusUnauthorized},
+		{"The operator is allowed", http.MethodDelete, operator, http.StatusOK},
+		{"Wrong passwords are unauthorized", http.MethodDelete, "Basic UGV0ZXI6d3Jvbmc=", http.StatusUnauthorized},
+		{"Tokens must name a user", http.MethodPost, token(t, "ann"), http.StatusUnauthorized},
+		{"Unknown users are forbidden", http.MethodPost, token(t, bson.NewObjectId().Hex()), http.StatusForbidden},
+		{"Users lacking the role are forbidden", http.MethodDelete, token(t, other.ID.Hex()), http.StatusForbidden},
+		{"Users holding the role are allowed", http.MethodPut, token(t, admin.ID.Hex()), http.StatusOK},
+		{"Suspended users holding the role are forbidden", http.MethodPut, token(t, suspended.ID.Hex()), http.StatusForbidden},
+		{"Members of a group holding the role are allowed", http.MethodPost, token(t, member.ID.Hex()), http.StatusOK},
+		{"Tokens granting the role are allowed", http.MethodPost, grant(t, Admin), http.StatusOK},
+		{"Tokens granting another role are not", http.MethodPost, grant(t, "editor"), http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if got := serve(tc.method, tc.auth); got != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, got)
+		}
+	}
+
+	t.Log("Leaving the group revokes the role")
+	if err := user.RemoveMember(g.ID, member.ID); err != nil {
+		t.Fatalf("Error removing a member: %s", err)
+	}
+	if got := serve(http.MethodPost, token(t, member.ID.Hex())); got != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, got)
+	}
+}
@@ -0,0 +1,307 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/migrate"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChecksumTrailer carries the SHA-256 of a streamed snapshot
+const ChecksumTrailer = "X-Backup-Sha256"
+
+// Errors used by backups
+var (
+	// Returns ErrChecksum when a snapshot does not match its checksum file
+	ErrChecksum = errors.New("snapshot checksum mismatch")
+	// Returns ErrCorrupt when a snapshot is not a consistent database
+	ErrCorrupt = errors.New("snapshot is corrupt")
+)
+
+// dump writes a consistent snapshot of the database at path to w from a
+// single read transaction and returns its hex encoded SHA-256. The database
+// stays open until w has the whole snapshot.
+func dump(path string, w io.Writer) (string, error) {
+	db, err := storm.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer db.Close()
+
+	h := sha256.New()
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(io.MultiWriter(w, h))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// temp dumps the database at path to a temporary file, open at its start.
+// The caller closes and removes it.
+func temp(path string) (*os.File, string, error) {
+	f, err := os.CreateTemp("", "backup-*.db")
+	if err != nil {
+		return nil, "", err
+	}
+	sum, err := dump(path, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, sum, nil
+}
+
+// Handler serves a snapshot of the database at path as a download, with its
+// checksum sent as a trailer. The snapshot is taken before the response
+// starts, so a failure to take it is reported with its status.
+func Handler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		f, sum, err := temp(path)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		name := strings.TrimSuffix(filepath.Base(path), ".db") + "-" + stamp(time.Now()) + ".db"
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Header().Set("Trailer", ChecksumTrailer)
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, f); err != nil {
+			// the status is already sent; a missing trailer marks the failure
+			return
+		}
+		w.Header().Set(ChecksumTrailer, sum)
+	}
+}
+
+func stamp(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000000000Z")
+}
+
+// checksumFile is the sha256sum compatible file holding the checksum of a
+// snapshot
+func checksumFile(snapshot string) string {
+	return snapshot + ".sha256"
+}
+
+// Snapshot writes a snapshot of the database at path to dir, along with its
+// checksum file, and returns its path
+func Snapshot(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), ".db") + "-" + stamp(time.Now()) + ".db"
+	file := filepath.Join(dir, name)
+	return file, WriteFile(path, file)
+}
+
+// WriteFile writes a snapshot of the database at path to file, along with
+// its checksum file, and verifies it. The database is only held while the
+// snapshot is written; the checks run once it is released.
+func WriteFile(path, file string) error {
+	f, err := os.OpenFile(file+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	sum, err := dump(path, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err == nil {
+		err = os.WriteFile(checksumFile(file), []byte(sum+"  "+filepath.Base(file)+"\n"), 0o600)
+	}
+	if err == nil {
+		err = Verify(file)
+	}
+	if err != nil {
+		os.Remove(file + ".tmp")
+		os.Remove(file)
+		os.Remove(checksumFile(file))
+	}
+	return err
+}
+
+// Sum returns the hex encoded SHA-256 of a file
+func Sum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify checks a snapshot against its checksum file, when there is one,
+// and checks the consistency of the database it holds
+func Verify(file string) error {
+	bd, err := os.ReadFile(checksumFile(file))
+	switch {
+	case err == nil:
+		want := strings.Fields(string(bd))
+		got, err := Sum(file)
+		if err != nil {
+			return err
+		}
+		if len(want) == 0 || want[0] != got {
+			return ErrChecksum
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	db, err := bolt.Open(file, 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return fmt.Errorf("%w: %s", ErrCorrupt, err)
+		}
+		return nil
+	})
+}
+
+// Snapshots lists the snapshots of dir, oldest first
+func Snapshots(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Prune removes all but the keep most recent snapshots of dir
+func Prune(dir string, keep int) error {
+	files, err := Snapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > keep {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		os.Remove(checksumFile(files[0]))
+		files = files[1:]
+	}
+	return nil
+}
+
+// Target is a database to snapshot and the directory keeping its snapshots
+type Target struct {
+	Path string
+	Dir  string
+}
+
+// Scheduler snapshots the databases listed by targets every interval,
+// keeping the keep most recent snapshots of each, until ctx is done. The
+// targets are listed at every tick, so that databases added meanwhile are
+// backed up too.
+func Scheduler(ctx context.Context, targets func() ([]Target, error), interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ts, err := targets()
+		if err != nil {
+			log.Printf("backup: %s", err)
+			continue
+		}
+		for _, t := range ts {
+			if _, err := Snapshot(t.Path, t.Dir); err != nil {
+				log.Printf("backup: %s", err)
+				continue
+			}
+			if err := Prune(t.Dir, keep); err != nil {
+				log.Printf("backup: %s", err)
+			}
+		}
+	}
+}
+
+// Restore replaces the database at path with a snapshot. The snapshot is
+// verified and migrated to the current schema on a copy, and the current
+// database is kept as path.<time>.bak, before the copy is swapped in.
+func Restore(path, snapshot string) (string, error) {
+	if err := Verify(snapshot); err != nil {
+		return "", err
+	}
+	tmp := path + ".restore"
+	if err := copyFile(snapshot, tmp); err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	_, bak, err := migrate.Up(tmp)
+	if bak != "" {
+		// the copy is disposable, so is its backup
+		os.Remove(bak)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	previous := ""
+	if _, err := os.Stat(path); err == nil {
+		previous = path + "." + stamp(time.Now()) + ".bak"
+		if err := copyFile(path, previous); err != nil {
+			return "", err
+		}
+	}
+	return previous, os.Rename(tmp, path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const dbPath = "users.db"
+
+func TestMain(m *testing.M) {
+	os.Remove(dbPath)
+	migrate.Register(migrate.Migration{
+		Version: 1,
+		Name:    "backfill status",
+		Up:      func(tx *migrate.Tx) error { return tx.Backfill("Person", "Status", "active") },
+	})
+	code := m.Run()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+type Person struct {
+	ID     int `storm:"id"`
+	Name   string
+	Status string
+}
+
+func save(t *testing.T, people ...Person) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	defer db.Close()
+	for _, p := range people {
+		if err := db.Save(&p); err != nil {
+			t.Fatalf("Error saving a person: %s", err)
+		}
+	}
+}
+
+func people(t *testing.T) []Person {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	defer db.Close()
+	var ps []Person
+	if err := db.All(&ps); err != nil {
+		t.Fatalf("Error reading people: %s", err)
+	}
+	return ps
+}
+
+func TestHandler(t *testing.T) {
+	os.Remove(dbPath)
+	save(t, Person{ID: 1, Name: "Ann"})
+
+	testCases := []struct {
+		txt    string
+		method string
+		status int
+	}{
+		{"GET streams a snapshot", http.MethodGet, http.StatusOK},
+		{"POST is not allowed", http.MethodPost, http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := httptest.NewRecorder()
+		Handler(dbPath)(w, httptest.NewRequest(tc.method, "/admin/backup", nil))
+		res := w.Result()
+		if res.StatusCode != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, res.StatusCode)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		sum := sha256.Sum256(w.Body.Bytes())
+		if got := res.Trailer.Get(ChecksumTrailer); got != hex.EncodeToString(sum[:]) {
+			t.Errorf("Expected checksum %x, got %q", sum, got)
+		}
+		file := filepath.Join(t.TempDir(), "snapshot.db")
+		if err := os.WriteFile(file, w.Body.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(file); err != nil {
+			t.Errorf("Expected a valid snapshot, got %s", err)
+		}
+	}
+}
+
+// opener opens the database on every write, which times out while the
+// snapshot holds it
+type opener struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (o *opener) Write(b []byte) (int, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		o.err = err
+		return len(b), nil
+	}
+	db.Close()
+	return len(b), nil
+}
+
+func TestHandlerReleases(t *testing.T) {
+	os.Remove(dbPath)
+	save(t, Person{ID: 1, Name: "Ann"})
+	o := &opener{ResponseRecorder: httptest.NewRecorder()}
+	Handler(dbPath)(o, httptest.NewRequest(http.MethodGet, "/backup", nil))
+	if o.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, o.Code)
+	}
+	if o.err != nil {
+		t.Errorf("Expected the database to be released while streaming, got %s", o.err)
+	}
+}
+
+func TestSnapshotPrune(t *testing.T) {
+	os.Remove(dbPath)
+	save(t, Person{ID: 1, Name: "Ann"})
+	dir := t.TempDir()
+
+	var files []string
+	for i := 0; i < 3; i++ {
+		file, err := Snapshot(dbPath, dir)
+		if err != nil {
+			t.Fatalf("Error taking a snapshot: %s", err)
+		}
+		files = append(files, file)
+	}
+	if err := Prune(dir, 2); err != nil {
+		t.Fatalf("Error pruning snapshots: %s", err)
+	}
+	kept, _ := Snapshots(dir)
+	if len(kept) != 2 || kept[0] != files[1] || kept[1] != files[2] {
+		t.Errorf("Expected the 2 most recent snapshots %v, got %v", files[1:], kept)
+	}
+	if _, err := os.Stat(checksumFile(files[0])); !os.IsNotExist(err) {
+		t.Errorf("Expected the checksum of a pruned snapshot to be removed, got %v", err)
+	}
+
+	f, err := os.OpenFile(files[2], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("tampered"))
+	f.Close()
+	if err := Verify(files[2]); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Expected error %v, got %v", ErrChecksum, err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	os.Remove(dbPath)
+	migrate.BackupDir = t.TempDir()
+	save(t, Person{ID: 1, Name: "Ann"})
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := WriteFile(dbPath, snapshot); err != nil {
+		t.Fatalf("Error writing a snapshot: %s", err)
+	}
+	save(t, Person{ID: 2, Name: "Bob"})
+
+	t.Log("A corrupt snapshot is rejected")
+	corrupt := filepath.Join(t.TempDir(), "corrupt.db")
+	os.WriteFile(corrupt, []byte("not a database"), 0o600)
+	if _, err := Restore(dbPath, corrupt); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected error %v, got %v", ErrCorrupt, err)
+	}
+	if ps := people(t); len(ps) != 2 {
+		t.Errorf("Expected the database to be untouched, got %v", ps)
+	}
+
+	t.Log("A valid snapshot is migrated and swapped in")
+	previous, err := Restore(dbPath, snapshot)
+	if err != nil {
+		t.Fatalf("Error restoring: %s", err)
+	}
+	defer os.Remove(previous)
+	ps := people(t)
+	if len(ps) != 1 || ps[0].Name != "Ann" {
+		t.Errorf("Expected only Ann to be restored, got %v", ps)
+	}
+	if len(ps) == 1 && ps[0].Status != "active" {
+		t.Errorf("Expected the snapshot to be migrated, got status %q", ps[0].Status)
+	}
+	if _, err := os.Stat(previous); err != nil {
+		t.Errorf("Expected the previous database to be kept, got %s", err)
+	}
+	if bak, _ := filepath.Glob(filepath.Join(migrate.BackupDir, "*.bak")); len(bak) != 0 {
+		t.Errorf("Expected no backups of the restored copy, got %v", bak)
+	}
+}
@@ -1,6 +1,10 @@
 package cache
 
 import (
+	"context"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
 	"net/http"
 	"strings"
 	"sync"
@@ -50,12 +54,42 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
-// MakeResource returns a string representation of the request URI
+// variantSep separates a resource from its representation in cache keys
+const variantSep = "\x00"
+
+// variantKey returns the cache key of the representation of a resource
+// negotiated from the Accept header of the request, so that the many ways of
+// asking for the same media type share an entry. It is empty when no
+// representation is acceptable, and such responses are not cached.
+func variantKey(resource string, r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return resource
+	}
+	mt, _, ok := codec.Default.Negotiate(accept)
+	if !ok {
+		return ""
+	}
+	return resource + variantSep + mt
+}
+
+// MakeResource returns a string representation of the request URI, scoped
+// to the tenant of the request
 func MakeResource(r *http.Request) string {
 	if r == nil {
 		return ""
 	}
-	return strings.TrimSuffix(r.URL.RequestURI(), "/")
+	return Resource(r.Context(), strings.TrimSuffix(r.URL.RequestURI(), "/"))
+}
+
+// Resource returns the cache entry of the path in the tenant carried by
+// ctx. Entries of the default tenant are the paths themselves.
+func Resource(ctx context.Context, path string) string {
+	t := tenant.FromContext(ctx)
+	if t.ID == "" {
+		return path
+	}
+	return "/tenants/" + t.ID + path
 }
 
 // Clean removes all entries from the cache
@@ -65,9 +99,47 @@ func Clean() {
 	cache.lock.Unlock()
 }
 
-// Drop removes a specific entry from the cache
-func Drop(res string) {
-	set(res, nil)
+// Drop removes the given entries, with all their representations, from the
+// cache at once
+func Drop(res ...string) {
+	cache.lock.Lock()
+	for _, r := range res {
+		delete(cache.data, r)
+	}
+	for k := range cache.data {
+		i := strings.Index(k, variantSep)
+		if i < 0 {
+			continue
+		}
+		for _, r := range res {
+			if k[:i] == r {
+				delete(cache.data, k)
+			}
+		}
+	}
+	cache.lock.Unlock()
+}
+
+// DropUsers removes the entries of the user list and of the users with the
+// given ids in the tenant carried by ctx, which writes to users change
+func DropUsers(ctx context.Context, ids ...bson.ObjectId) {
+	res := []string{Resource(ctx, "/users")}
+	for _, id := range ids {
+		res = append(res, Resource(ctx, "/users/"+id.Hex()))
+	}
+	Drop(res...)
+}
+
+// DropTenant removes every entry of the tenant carried by ctx
+func DropTenant(ctx context.Context) {
+	prefix := Resource(ctx, "/")
+	cache.lock.Lock()
+	for k := range cache.data {
+		if strings.HasPrefix(k, prefix) {
+			delete(cache.data, k)
+		}
+	}
+	cache.lock.Unlock()
 }
 
 // Serve checks the cache for a response to the request and serves it if found
@@ -78,7 +150,7 @@ func Serve(w http.ResponseWriter, r *http.Request) bool {
 	if r.Header.Get("Cache-Control") == "no-cache" {
 		return false
 	}
-	resp := get(MakeResource(r))
+	resp := get(variantKey(MakeResource(r), r))
 	if resp == nil {
 		return false
 	}
@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"context"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
 	"net/http"
 	"reflect"
 	"testing"
@@ -90,6 +93,64 @@ func TestDrop(t *testing.T) {
 	}
 }
 
+func TestDropVariants(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com/resource", nil)
+	req.Header.Set("Accept", "application/xml")
+	set("/resource", &response{})
+	set(variantKey("/resource", req), &response{})
+	set("/resource/other", &response{})
+
+	Drop("/resource")
+
+	if get("/resource") != nil || get(variantKey("/resource", req)) != nil {
+		t.Error("Expected all representations of the resource to be dropped")
+	}
+	if get("/resource/other") == nil {
+		t.Error("Expected other resources to be kept")
+	}
+	Clean()
+}
+
+func TestDropUsers(t *testing.T) {
+	ctx := tenant.NewContext(context.Background(), tenant.Tenant{ID: "acme"})
+	id, other := bson.NewObjectId(), bson.NewObjectId()
+	for _, res := range []string{"/users", "/users/" + id.Hex(), "/users/" + other.Hex()} {
+		set(res, &response{})
+		set(Resource(ctx, res), &response{})
+	}
+
+	DropUsers(ctx, id)
+
+	if get(Resource(ctx, "/users")) != nil || get(Resource(ctx, "/users/"+id.Hex())) != nil {
+		t.Error("Expected the list and the user to be dropped")
+	}
+	if get(Resource(ctx, "/users/"+other.Hex())) == nil || get("/users") == nil || get("/users/"+id.Hex()) == nil {
+		t.Error("Expected other users and tenants to be kept")
+	}
+	Clean()
+}
+
+func TestVariantKey(t *testing.T) {
+	testCases := []struct {
+		txt    string
+		accept string
+		exp    string
+	}{
+		{"No Accept header", "", "/resource"},
+		{"A media type", "application/xml", "/resource" + variantSep + "application/xml"},
+		{"The same media type with parameters", "text/html;q=0.9, application/xml;q=0.8", "/resource" + variantSep + "application/xml"},
+		{"Nothing acceptable", "image/png", ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		req, _ := http.NewRequest("GET", "https://example.com/resource", nil)
+		req.Header.Set("Accept", tc.accept)
+		if got := variantKey("/resource", req); got != tc.exp {
+			t.Errorf("Expected key %q, got %q", tc.exp, got)
+		}
+	}
+}
+
 func TestServe(t *testing.T) {
 	req, _ := http.NewRequest("GET", "https://example.com/resource", nil)
 	w := &dummyResponseWriter{}
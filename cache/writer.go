@@ -17,7 +17,7 @@ var (
 func NewWriter(w http.ResponseWriter, r *http.Request) *Writer {
 	return &Writer{
 		writer:   w,
-		resource: MakeResource(r),
+		resource: variantKey(MakeResource(r), r),
 		response: response{
 			header: http.Header{},
 		},
@@ -43,6 +43,8 @@ func (w *Writer) Write(b []byte) (int, error) {
 		w.response.body[k] = v
 	}
 	copyHeader(w.Header(), w.writer.Header())
-	set(w.resource, &w.response)
+	if w.resource != "" {
+		set(w.resource, &w.response)
+	}
 	return w.writer.Write(b)
 }
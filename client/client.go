@@ -0,0 +1,251 @@
+// Package client is a typed client of the users API. It retries failed
+// requests with exponential backoff when that is safe, authenticates them and
+// reports error responses as *Error values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers the API reads
+const (
+	TenantHeader      = "X-Tenant-ID"
+	APIKeyHeader      = "X-API-Key"
+	IdempotencyHeader = "Idempotency-Key"
+)
+
+// Defaults of new clients
+const (
+	DefaultRetries    = 3
+	DefaultBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+// Auth adds credentials to a request
+type Auth func(r *http.Request)
+
+// BasicAuth authenticates requests with a username and password
+func BasicAuth(username, password string) Auth {
+	return func(r *http.Request) { r.SetBasicAuth(username, password) }
+}
+
+// BearerToken authenticates requests with a bearer token, such as a tenant
+// token
+func BearerToken(token string) Auth {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+// APIKey identifies requests with an API key, which gets its own rate limit
+func APIKey(key string) Auth {
+	return func(r *http.Request) { r.Header.Set(APIKeyHeader, key) }
+}
+
+// Client sends requests to the API at BaseURL
+type Client struct {
+	// BaseURL is the root of the API, such as http://localhost:8080
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// Auth adds credentials to every request, if set
+	Auth Auth
+	// Tenant names the tenant of every request, the default one if empty.
+	// The server only accepts it along with a bearer token claiming it.
+	Tenant string
+	// Retries is how many times a request that failed with a network
+	// error, 429 Too Many Requests or a 502, 503 or 504 status is retried.
+	// Only idempotent requests and requests carrying an idempotency key are.
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each next
+	// one and capped by MaxBackoff. A Retry-After header takes precedence.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// New returns a client of the API at baseURL with the default retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// request is a request to send, possibly more than once
+type request struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+	header      http.Header
+}
+
+// idempotent reports whether the request can be sent again safely
+func (r *request) idempotent() bool {
+	switch r.method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return r.header.Get(IdempotencyHeader) != ""
+}
+
+// retryable reports whether a response with the status code may succeed if
+// the request is sent again
+func retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// newKey returns a random idempotency key
+func newKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// delay is how long to wait before the retry following attempt, from the
+// Retry-After header of res if it has one
+func (c *Client) delay(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	d := c.Backoff << uint(attempt)
+	if d <= 0 || (c.MaxBackoff > 0 && d > c.MaxBackoff) {
+		d = c.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// jitter spreads the retries of clients that failed together
+	return d/2 + time.Duration(mathrand.Int63n(int64(d/2)+1))
+}
+
+// target is the URL of path, a path of the API or a link of one of its
+// responses. Links are resolved against BaseURL and may not leave its
+// origin, so that credentials are only sent to the API.
+func (c *Client) target(path string) (string, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	if ref.Scheme == "" && ref.Host == "" {
+		return c.BaseURL + path, nil
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	if ref.Host != base.Host || (ref.Scheme != "" && ref.Scheme != base.Scheme) {
+		return "", fmt.Errorf("%w: %s", ErrForeignLink, path)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// send sends req, retrying it as configured, and returns the response of
+// the last attempt. Error statuses are returned as *Error.
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	for attempt := 0; ; attempt++ {
+		target, err := c.target(req.path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range req.header {
+			r.Header[name] = values
+		}
+		r.Header.Set("Accept", "application/json")
+		if req.contentType != "" {
+			r.Header.Set("Content-Type", req.contentType)
+		}
+		if c.Tenant != "" {
+			r.Header.Set(TenantHeader, c.Tenant)
+		}
+		if c.Auth != nil {
+			c.Auth(r)
+		}
+
+		res, err := hc.Do(r)
+		if err == nil && !retryable(res.StatusCode) {
+			if res.StatusCode >= http.StatusBadRequest {
+				defer res.Body.Close()
+				return nil, parseError(res)
+			}
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= c.Retries || !req.idempotent() {
+			if err != nil {
+				return nil, err
+			}
+			defer res.Body.Close()
+			return nil, parseError(res)
+		}
+		wait := c.delay(attempt, res)
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// do sends req and decodes the JSON body of the response into out, unless
+// out is nil
+func (c *Client) do(ctx context.Context, req *request, out interface{}) (*http.Response, error) {
+	res, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, res.Body)
+		return res, nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return nil, errors.New("client: decoding the response: " + err.Error())
+	}
+	return res, nil
+}
+
+// jsonRequest returns a request with v as its JSON body of media type
+// contentType
+func jsonRequest(method, path, contentType string, v interface{}) (*request, error) {
+	bd, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &request{method: method, path: path, contentType: contentType, body: bd, header: http.Header{}}, nil
+}
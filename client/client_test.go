@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	failures := int32(2)
+	keys, retryAfter := map[string]bool{}, "0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(IdempotencyHeader); key != "" {
+			keys[key] = true
+		}
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", "/users/1")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Write([]byte(`{"user": {"id": "1", "name": "Ann"}}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+
+	testCases := []struct {
+		txt      string
+		retries  int
+		failures int32
+		call     func() error
+		calls    int32
+		keys     int
+		err      error
+	}{
+		{"Reads are retried", 3, 2, func() error { _, err := c.GetUser(context.Background(), "1"); return err }, 3, 0, nil},
+		{"Retries are limited", 1, 2, func() error { _, err := c.GetUser(context.Background(), "1"); return err }, 2, 0, &Error{StatusCode: http.StatusServiceUnavailable}},
+		{"Creations are retried with the same key", 3, 1, func() error { _, err := c.CreateUser(context.Background(), UserInput{Name: "Ann"}); return err }, 3, 1, nil},
+		{"Patches are not retried", 3, 1, func() error { _, err := c.PatchUser(context.Background(), "1", nil); return err }, 1, 0, &Error{StatusCode: http.StatusServiceUnavailable}},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		calls, failures, c.Retries = 0, tc.failures, tc.retries
+		keys = map[string]bool{}
+		err := tc.call()
+		if tc.err == nil && err != nil || tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+		}
+		if calls != tc.calls {
+			t.Errorf("Expected %d calls, got %d", tc.calls, calls)
+		}
+		if len(keys) != tc.keys {
+			t.Errorf("Expected %d idempotency keys, got %v", tc.keys, keys)
+		}
+	}
+
+	t.Log("Cancelling the context stops the retries")
+	calls, failures, retryAfter = 0, 10, ""
+	c.Retries, c.Backoff = 10, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetUser(ctx, "1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	testCases := []struct {
+		txt  string
+		code int
+		body string
+		exp  Error
+	}{
+		{"Status texts", http.StatusNotFound, "Not Found\n", Error{StatusCode: http.StatusNotFound, Message: "Not Found"}},
+		{"Messages", http.StatusUnsupportedMediaType, `{"message": "Unsupported Media Type"}`, Error{StatusCode: http.StatusUnsupportedMediaType, Message: "Unsupported Media Type"}},
+		{"Error strings", http.StatusBadRequest, `{"error": "unknown field: password"}`, Error{StatusCode: http.StatusBadRequest, Message: "unknown field: password"}},
+		{"Error objects", http.StatusUnprocessableEntity, `{"error": {"message": "unknown field", "field": "nickname", "line": 1, "column": 2}}`, Error{StatusCode: http.StatusUnprocessableEntity, Message: "unknown field", Field: "nickname", Line: 1, Column: 2}},
+		{"Empty bodies", http.StatusUnauthorized, "", Error{StatusCode: http.StatusUnauthorized, Message: "Unauthorized"}},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.code)
+			w.Write([]byte(tc.body))
+		}))
+		err := New(srv.URL).DeleteUser(context.Background(), "1")
+		srv.Close()
+		var e *Error
+		if !errors.As(err, &e) || *e != tc.exp {
+			t.Errorf("Expected %+v, got %+v", tc.exp, err)
+		}
+	}
+	if err := (&Error{StatusCode: http.StatusNotFound, Message: "gone"}); !errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		t.Errorf("Expected errors to match the sentinel of their status")
+	}
+}
+
+func TestPagination(t *testing.T) {
+	pages := map[string]string{
+		"limit=100": `{"users": [{"id": "1"}, {"id": "2"}], "_links": {"self": {"href": "/users"}, "next": {"href": "/users?after=2"}}}`,
+		"after=2":   `{"users": [], "_links": {"next": {"href": "/users?after=2b"}}}`,
+		"after=2b":  `{"users": [{"id": "3"}], "_links": {"self": {"href": "/users?after=2b"}}}`,
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(pages[r.URL.RawQuery]))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Auth = BearerToken("secret")
+
+	ids := []string{}
+	it := c.ListUsers(context.Background(), ListOptions{})
+	for it.Next() {
+		ids = append(ids, it.User().ID)
+	}
+	if it.Err() != nil || strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("Expected users 1,2,3, got %v and %v", ids, it.Err())
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Expected every page to be authenticated, got %q", auth)
+	}
+}
+
+func TestForeignLinks(t *testing.T) {
+	var requests int
+	var auth string
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"users": [{"id": "2"}]}`))
+	}))
+	defer foreign.Close()
+	var next string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"users": [{"id": "1"}], "_links": {"next": {"href": "` + next + `"}}}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+	c.Auth = BearerToken("secret")
+
+	testCases := []struct {
+		txt  string
+		next string
+		err  error
+	}{
+		{"Absolute link to the API", srv.URL + "/users?after=1", nil},
+		{"Absolute link to another host", foreign.URL + "/users?after=1", ErrForeignLink},
+		{"Scheme relative link to another host", strings.TrimPrefix(foreign.URL, "http:") + "/users?after=1", ErrForeignLink},
+		{"Link to another scheme", strings.Replace(srv.URL, "http:", "https:", 1) + "/users?after=1", ErrForeignLink},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		next, requests, auth = tc.next, 0, ""
+		it := c.ListUsers(context.Background(), ListOptions{})
+		it.Next()
+		it.Next()
+		if !errors.Is(it.Err(), tc.err) {
+			t.Errorf("Expected error %v, got %v", tc.err, it.Err())
+		}
+		if tc.err != nil && (requests != 1 || auth != "") {
+			t.Errorf("Expected the link not to be followed, got %d requests and credentials %q", requests, auth)
+		}
+	}
+}
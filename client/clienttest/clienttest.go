@@ -0,0 +1,213 @@
+// Package clienttest is the suite checking that a server behaves the way
+// the client expects
+package clienttest
+
+import (
+	"context"
+	"errors"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/authz"
+	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/client"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/golang-jwt/jwt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tenant creates a tenant in a temporary directory for the duration of the
+// test and returns its id, so that servers under test start empty and leave
+// the databases of the working directory alone
+func Tenant(t *testing.T) string {
+	t.Helper()
+	dir, dbPath := tenant.Dir, tenant.DBPath
+	tenant.Dir = t.TempDir()
+	tenant.DBPath = filepath.Join(tenant.Dir, "tenants.db")
+	t.Cleanup(func() {
+		tenant.Dir, tenant.DBPath = dir, dbPath
+		// creating users notifies the webhooks
+		os.Remove("webhooks.db")
+	})
+	acme := &tenant.Tenant{ID: "acme"}
+	// the responses cached for the tenant of a previous test are stale
+	t.Cleanup(func() { cache.DropTenant(tenant.NewContext(context.Background(), *acme)) })
+	if err := acme.Create(); err != nil {
+		t.Fatalf("Error creating a tenant: %s", err)
+	}
+	if _, _, err := migrate.Up(acme.Path(user.DBPath)); err != nil {
+		t.Fatalf("Error migrating the tenant: %s", err)
+	}
+	return acme.ID
+}
+
+// Token returns a bearer token claiming the tenant id and granting its admin
+// role, signed with a secret set for the duration of the test
+func Token(t *testing.T, id string) string {
+	t.Helper()
+	secret := tenant.TokenSecret
+	if len(secret) == 0 {
+		tenant.TokenSecret = []byte("clienttest")
+		t.Cleanup(func() { tenant.TokenSecret = secret })
+	}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":           "clienttest",
+		tenant.Claim:    id,
+		auth.RolesClaim: []string{authz.Admin},
+	}).SignedString(tenant.TokenSecret)
+	if err != nil {
+		t.Fatalf("Error signing a token: %s", err)
+	}
+	return s
+}
+
+// Run runs the suite against the clients returned by open, which must talk
+// to a server without users
+func Run(t *testing.T, open func(t *testing.T) *client.Client) {
+	tests := []struct {
+		txt string
+		fn  func(*testing.T, *client.Client)
+	}{
+		{"CreateUser stores a user", testCreate},
+		{"UpdateUser replaces a user", testUpdate},
+		{"PatchUser merges changes", testPatch},
+		{"DeleteUser hides a user", testDelete},
+		{"Invalid users are rejected", testInvalid},
+		{"Taken emails are rejected", testConflict},
+		{"ListUsers iterates over users", testList},
+	}
+	for _, tc := range tests {
+		t.Run(tc.txt, func(t *testing.T) {
+			tc.fn(t, open(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+func create(t *testing.T, c *client.Client, in client.UserInput) *client.User {
+	t.Helper()
+	u, err := c.CreateUser(ctx, in)
+	if err != nil {
+		t.Fatalf("Error creating a user: %s", err)
+	}
+	return u
+}
+
+func testCreate(t *testing.T, c *client.Client) {
+	u := create(t, c, client.UserInput{Name: "Ann", Email: " Ann@Example.com", Attributes: map[string]interface{}{"team": "core"}})
+	if u.ID == "" || u.Name != "Ann" || u.Email != "ann@example.com" || u.Status != user.StatusActive || u.Version != 1 {
+		t.Errorf("Expected the stored user, got %+v", u)
+	}
+	if u.Attributes["team"] != "core" {
+		t.Errorf("Expected the attributes, got %v", u.Attributes)
+	}
+	got, err := c.GetUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Error reading a user: %s", err)
+	}
+	if got.ID != u.ID || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Errorf("Expected %+v, got %+v", u, got)
+	}
+}
+
+func testUpdate(t *testing.T, c *client.Client) {
+	u := create(t, c, client.UserInput{Name: "Ann", Role: "editor"})
+	got, err := c.UpdateUser(ctx, u.ID, client.UserInput{Name: "Anna"})
+	if err != nil {
+		t.Fatalf("Error updating a user: %s", err)
+	}
+	if got.Name != "Anna" || got.Role != "" || got.Version != 2 {
+		t.Errorf("Expected the replaced user, got %+v", got)
+	}
+}
+
+func testPatch(t *testing.T, c *client.Client) {
+	u := create(t, c, client.UserInput{Name: "Ann", Role: "editor", Attributes: map[string]interface{}{"team": "core"}})
+	got, err := c.PatchUser(ctx, u.ID, map[string]interface{}{"role": "admin", "attributes": nil})
+	if err != nil {
+		t.Fatalf("Error patching a user: %s", err)
+	}
+	if got.Name != "Ann" || got.Role != "admin" || got.Attributes != nil {
+		t.Errorf("Expected the patched user, got %+v", got)
+	}
+	_, err = c.PatchUser(ctx, u.ID, map[string]interface{}{"id": "5f1a5b3e8f1b2c3d4e5f6a7b"})
+	var e *client.Error
+	if !errors.As(err, &e) || e.Field != "id" {
+		t.Errorf("Expected the id to be read-only, got %v", err)
+	}
+}
+
+func testDelete(t *testing.T, c *client.Client) {
+	u := create(t, c, client.UserInput{Name: "Ann"})
+	if err := c.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if _, err := c.GetUser(ctx, u.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected %v, got %v", client.ErrNotFound, err)
+	}
+	if err := c.DeleteUser(ctx, u.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected %v, got %v", client.ErrNotFound, err)
+	}
+}
+
+func testInvalid(t *testing.T, c *client.Client) {
+	_, err := c.CreateUser(ctx, client.UserInput{Email: "ann@example.com"})
+	var e *client.Error
+	if !errors.Is(err, client.ErrBadRequest) || !errors.As(err, &e) || e.Field != "name" {
+		t.Errorf("Expected the name to be required, got %v", err)
+	}
+}
+
+func testConflict(t *testing.T, c *client.Client) {
+	create(t, c, client.UserInput{Name: "Ann", Email: "ann@example.com"})
+	_, err := c.CreateUser(ctx, client.UserInput{Name: "Other Ann", Email: "ann@example.com"})
+	var e *client.Error
+	if !errors.Is(err, client.ErrConflict) || !errors.As(err, &e) || e.Field != "email" || e.Value != "ann@example.com" {
+		t.Errorf("Expected the email to conflict, got %v", err)
+	}
+}
+
+func list(t *testing.T, c *client.Client, opts client.ListOptions) []string {
+	t.Helper()
+	names := []string{}
+	it := c.ListUsers(ctx, opts)
+	for it.Next() {
+		names = append(names, it.User().Name)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Error listing users: %s", err)
+	}
+	return names
+}
+
+func testList(t *testing.T, c *client.Client) {
+	if names := list(t, c, client.ListOptions{}); len(names) != 0 {
+		t.Fatalf("Expected no users, got %v", names)
+	}
+	create(t, c, client.UserInput{Name: "Ann", Role: "admin"})
+	bob := create(t, c, client.UserInput{Name: "Bob"})
+	if err := c.DeleteUser(ctx, bob.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	create(t, c, client.UserInput{Name: "Cid"})
+
+	testCases := []struct {
+		txt  string
+		opts client.ListOptions
+		exp  int
+	}{
+		{"All users", client.ListOptions{}, 2},
+		{"All users in pages of one", client.ListOptions{PageSize: 1}, 2},
+		{"Deleted users too", client.ListOptions{IncludeDeleted: true}, 3},
+		{"Filtered users", client.ListOptions{Field: "role", Value: "admin"}, 1},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if names := list(t, c, tc.opts); len(names) != tc.exp {
+			t.Errorf("Expected %d users, got %v", tc.exp, names)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error is an error response of the API. Field names the invalid or
+// conflicting field when one is to blame, and Line and Column locate syntax
+// errors in request bodies.
+type Error struct {
+	StatusCode int         `json:"-"`
+	Message    string      `json:"message"`
+	Field      string      `json:"field,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	Line       int         `json:"line,omitempty"`
+	Column     int         `json:"column,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" && e.Message != http.StatusText(e.StatusCode) {
+		msg += ": " + e.Message
+	}
+	if e.Field != "" {
+		msg += " (" + e.Field + ")"
+	}
+	return msg
+}
+
+// Is reports whether target is the sentinel error of the status of e, so
+// that errors.Is(err, ErrNotFound) holds for any 404 response
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Message == "" && t.StatusCode == e.StatusCode
+}
+
+// Sentinel errors matching the error responses of each status
+var (
+	ErrBadRequest          = &Error{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized        = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden           = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound            = &Error{StatusCode: http.StatusNotFound}
+	ErrConflict            = &Error{StatusCode: http.StatusConflict}
+	ErrUnsupportedMedia    = &Error{StatusCode: http.StatusUnsupportedMediaType}
+	ErrUnprocessableEntity = &Error{StatusCode: http.StatusUnprocessableEntity}
+	ErrTooManyRequests     = &Error{StatusCode: http.StatusTooManyRequests}
+)
+
+// ErrForeignLink is returned when a response links to another origin than
+// the API, where the client does not follow it
+var ErrForeignLink = errors.New("client: link to another origin")
+
+// parseError reads the error of a response. Servers answer with the status
+// text, {"message": ...}, {"error": "..."} or an error object under "error".
+func parseError(res *http.Response) error {
+	e := &Error{StatusCode: res.StatusCode}
+	bd, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	var body struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	switch {
+	case json.Unmarshal(bd, &body) != nil:
+		e.Message = strings.TrimSpace(string(bd))
+	case len(body.Error) > 0 && body.Error[0] == '"':
+		json.Unmarshal(body.Error, &e.Message)
+	case len(body.Error) > 0:
+		json.Unmarshal(body.Error, e)
+	default:
+		e.Message = body.Message
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
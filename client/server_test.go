@@ -0,0 +1,30 @@
+package client_test
+
+import (
+	"github.com/christianotieno/go-rest-api/client"
+	"github.com/christianotieno/go-rest-api/client/clienttest"
+	"github.com/christianotieno/go-rest-api/handlers"
+	"github.com/christianotieno/go-rest-api/idempotency"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	clienttest.Run(t, func(t *testing.T) *client.Client {
+		id := clienttest.Tenant(t)
+		keys := idempotency.New(time.Hour)
+		users := tenant.Middleware(keys.Middleware(http.HandlerFunc(handlers.UsersRouter)))
+		mux := http.NewServeMux()
+		mux.Handle("/users", users)
+		mux.Handle("/users/", users)
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		c := client.New(srv.URL)
+		c.Tenant = id
+		c.Auth = client.BearerToken(clienttest.Token(t, id))
+		return c
+	})
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User is a user as the API represents it
+type User struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Role       string                 `json:"role"`
+	Email      string                 `json:"email,omitempty"`
+	Status     string                 `json:"status"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Version    int                    `json:"version"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	DeletedAt  *time.Time             `json:"deleted_at,omitempty"`
+}
+
+// UserInput holds the fields of a user clients can set
+type UserInput struct {
+	Name       string                 `json:"name"`
+	Role       string                 `json:"role,omitempty"`
+	Email      string                 `json:"email,omitempty"`
+	Status     string                 `json:"status,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// mergePatchType is the media type of JSON merge patches
+const mergePatchType = "application/merge-patch+json"
+
+// link is a HAL link
+type link struct {
+	Href string `json:"href"`
+}
+
+// userPath is the path of the user with the given id
+func userPath(id string) string {
+	return "/users/" + url.PathEscape(id)
+}
+
+// readUser sends req and returns the user of the response
+func (c *Client) readUser(ctx context.Context, req *request) (*User, error) {
+	var body struct {
+		User *User `json:"user"`
+	}
+	if _, err := c.do(ctx, req, &body); err != nil {
+		return nil, err
+	}
+	return body.User, nil
+}
+
+// GetUser returns the user with the given id
+func (c *Client) GetUser(ctx context.Context, id string) (*User, error) {
+	return c.readUser(ctx, &request{method: http.MethodGet, path: userPath(id), header: http.Header{}})
+}
+
+// CreateUser creates a user and returns it as stored. The request carries
+// an idempotency key so that it is retried without creating duplicates.
+func (c *Client) CreateUser(ctx context.Context, in UserInput) (*User, error) {
+	req, err := jsonRequest(http.MethodPost, "/users", "application/json", in)
+	if err != nil {
+		return nil, err
+	}
+	req.header.Set(IdempotencyHeader, newKey())
+	res, err := c.do(ctx, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	location := res.Header.Get("Location")
+	return c.GetUser(ctx, location[strings.LastIndex(location, "/")+1:])
+}
+
+// UpdateUser replaces the fields of the user with the given id
+func (c *Client) UpdateUser(ctx context.Context, id string, in UserInput) (*User, error) {
+	req, err := jsonRequest(http.MethodPut, userPath(id), "application/json", in)
+	if err != nil {
+		return nil, err
+	}
+	return c.readUser(ctx, req)
+}
+
+// PatchUser changes some fields of the user with the given id, following a
+// JSON merge patch: fields set to nil are removed and others are replaced
+func (c *Client) PatchUser(ctx context.Context, id string, changes map[string]interface{}) (*User, error) {
+	req, err := jsonRequest(http.MethodPatch, userPath(id), mergePatchType, changes)
+	if err != nil {
+		return nil, err
+	}
+	return c.readUser(ctx, req)
+}
+
+// DeleteUser moves the user with the given id to the trash
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, path: userPath(id), header: http.Header{}}, nil)
+	return err
+}
+
+// ListOptions select the users to list
+type ListOptions struct {
+	// Field and Value keep only the users whose indexed field, such as
+	// email or role, has the value
+	Field string
+	Value string
+	// IncludeDeleted lists the users in the trash too
+	IncludeDeleted bool
+	// PageSize is how many users each page holds, DefaultPageSize if zero.
+	// The API does not page filtered lists or lists with the trash.
+	PageSize int
+}
+
+// DefaultPageSize is the number of users of each page of lists
+const DefaultPageSize = 100
+
+// query is the query string of the options
+func (o ListOptions) query() string {
+	q := url.Values{}
+	if o.Field != "" {
+		q.Set(o.Field, o.Value)
+	}
+	if o.IncludeDeleted {
+		q.Set("include", "deleted")
+	}
+	size := o.PageSize
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	q.Set("limit", strconv.Itoa(size))
+	return "?" + q.Encode()
+}
+
+// ListUsers returns an iterator over the users, fetching the pages the API
+// links to as they are reached:
+//
+//	it := c.ListUsers(ctx, client.ListOptions{})
+//	for it.Next() {
+//		u := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) *UserIterator {
+	return &UserIterator{c: c, ctx: ctx, next: "/users" + opts.query()}
+}
+
+// UserIterator iterates over a list of users, page by page
+type UserIterator struct {
+	c    *Client
+	ctx  context.Context
+	next string
+	page []User
+	user *User
+	err  error
+}
+
+// Next advances to the next user, fetching the next page if needed. It
+// returns false at the end of the list or on error.
+func (it *UserIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.next == "" || it.err != nil {
+			it.user = nil
+			return false
+		}
+		var body struct {
+			Users []User          `json:"users"`
+			Links map[string]link `json:"_links"`
+		}
+		_, it.err = it.c.do(it.ctx, &request{method: http.MethodGet, path: it.next, header: http.Header{}}, &body)
+		it.page, it.next = body.Users, body.Links["next"].Href
+	}
+	it.user, it.page = &it.page[0], it.page[1:]
+	return true
+}
+
+// User returns the current user
+func (it *UserIterator) User() *User {
+	return it.user
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *UserIterator) Err() error {
+	return it.err
+}
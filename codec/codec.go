@@ -0,0 +1,186 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codec converts values to and from a representation
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Media types of the built-in codecs
+const (
+	JSON    = "application/json"
+	XML     = "application/xml"
+	MsgPack = "application/msgpack"
+	CBOR    = "application/cbor"
+	YAML    = "application/yaml"
+)
+
+// Registry holds the codecs by media type. The first registered codec is
+// used when the client accepts any representation.
+type Registry struct {
+	types  []string
+	codecs map[string]Codec
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{codecs: map[string]Codec{}}
+}
+
+// Default is the registry with all the built-in codecs, JSON first
+var Default = func() *Registry {
+	r := NewRegistry()
+	r.Register(JSON, jsonCodec{})
+	r.Register(XML, xmlCodec{})
+	r.Register(MsgPack, msgpackCodec{})
+	r.Register("application/x-msgpack", msgpackCodec{})
+	r.Register(CBOR, cborCodec{})
+	r.Register(YAML, yamlCodec{})
+	r.Register("application/x-yaml", yamlCodec{})
+	return r
+}()
+
+// Register adds or replaces the codec of a media type
+func (r *Registry) Register(mediaType string, c Codec) {
+	if _, ok := r.codecs[mediaType]; !ok {
+		r.types = append(r.types, mediaType)
+	}
+	r.codecs[mediaType] = c
+}
+
+// Types lists the registered media types in registration order
+func (r *Registry) Types() []string {
+	return append([]string{}, r.types...)
+}
+
+// ForContentType returns the codec for the media type of a Content-Type header
+func (r *Registry) ForContentType(contentType string) (string, Codec, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, false
+	}
+	c, ok := r.codecs[mt]
+	return mt, c, ok
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+// parseAccept lists the media ranges of an Accept header by preference
+func parseAccept(accept string) []acceptRange {
+	ranges := []acceptRange{}
+	for i, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mt, q: q, order: i})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+// Negotiate picks the representation for an Accept header. An empty header
+// accepts anything; ok is false if none of the accepted types is registered.
+func (r *Registry) Negotiate(accept string) (string, Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return r.types[0], r.codecs[r.types[0]], true
+	}
+	ranges := parseAccept(accept)
+	rejected := map[string]bool{}
+	for _, ar := range ranges {
+		if ar.q == 0 {
+			rejected[ar.mediaType] = true
+		}
+	}
+	for _, ar := range ranges {
+		if ar.q == 0 {
+			continue
+		}
+		for _, t := range r.types {
+			if !rejected[t] && matches(ar.mediaType, t) {
+				return t, r.codecs[t], true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func matches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// generic converts v into the maps, slices and scalars of its JSON form so
+// every representation shares the JSON field names
+func generic(v interface{}) (interface{}, error) {
+	bd, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var g interface{}
+	d := json.NewDecoder(bytes.NewReader(bd))
+	d.UseNumber()
+	err = d.Decode(&g)
+	return g, err
+}
+
+// fromGeneric fills v from a decoded generic value through its JSON form
+func fromGeneric(g interface{}, v interface{}) error {
+	bd, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bd, v)
+}
+
+// ToJSON converts data in the codec's representation into JSON
+func ToJSON(c Codec, data []byte) ([]byte, error) {
+	if _, ok := c.(jsonCodec); ok {
+		return data, nil
+	}
+	var g interface{}
+	if err := c.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	return json.Marshal(g)
+}
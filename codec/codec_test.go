@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type sample struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Count int      `json:"count,omitempty"`
+}
+
+func TestNegotiate(t *testing.T) {
+	ts := []struct {
+		accept string
+		exp    string
+		ok     bool
+	}{
+		{"", JSON, true},
+		{"*/*", JSON, true},
+		{"application/xml", XML, true},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", XML, true},
+		{"application/json;q=0.5, application/cbor", CBOR, true},
+		{"application/*;q=0.9, application/yaml", YAML, true},
+		{"application/json;q=0, */*", XML, true},
+		{"text/html", "", false},
+	}
+
+	for _, tc := range ts {
+		got, _, ok := Default.Negotiate(tc.accept)
+		if ok != tc.ok || got != tc.exp {
+			t.Errorf("Expected %q to negotiate %q (%v), got %q (%v)", tc.accept, tc.exp, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	mt, _, ok := Default.ForContentType("application/json; charset=utf-8")
+	if !ok || mt != JSON {
+		t.Errorf("Expected %s, got %s", JSON, mt)
+	}
+	_, _, ok = Default.ForContentType("text/plain")
+	if ok {
+		t.Error("Expected text/plain not to have a codec")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := &sample{ID: "1", Name: "John", Tags: []string{"a", "b"}}
+	for _, mt := range Default.Types() {
+		t.Log(mt)
+		_, c, _ := Default.ForContentType(mt)
+		bd, err := c.Marshal(in)
+		if err != nil {
+			t.Errorf("Error marshalling %s: %s", mt, err)
+			continue
+		}
+		out := &sample{}
+		if err := c.Unmarshal(bd, out); err != nil {
+			t.Errorf("Error unmarshalling %s: %s", mt, err)
+			continue
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("Expected %+v, got %+v", in, out)
+		}
+	}
+}
+
+func TestMarshalUsesJSONNames(t *testing.T) {
+	bd, err := xmlCodec{}.Marshal(map[string]interface{}{"user": &sample{ID: "1", Name: "John"}})
+	if err != nil {
+		t.Fatalf("Error marshalling XML: %s", err)
+	}
+	if !strings.Contains(string(bd), "<user><id>1</id><name>John</name><tags></tags></user>") {
+		t.Errorf("Unexpected XML %s", bd)
+	}
+
+	bd, err = yamlCodec{}.Marshal(&sample{Name: "John", Count: 2})
+	if err != nil {
+		t.Fatalf("Error marshalling YAML: %s", err)
+	}
+	if !strings.Contains(string(bd), "name: John") || !strings.Contains(string(bd), "count: 2") {
+		t.Errorf("Unexpected YAML %s", bd)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	bd, err := ToJSON(yamlCodec{}, []byte("name: John\ncount: 2\n"))
+	if err != nil {
+		t.Fatalf("Error converting YAML: %s", err)
+	}
+	if string(bd) != `{"count":2,"name":"John"}` {
+		t.Errorf("Unexpected JSON %s", bd)
+	}
+}
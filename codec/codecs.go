@@ -0,0 +1,106 @@
+package codec
+
+import (
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strconv"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// numbers replaces json.Number values with int64 or float64 for the binary codecs
+func numbers(g interface{}) interface{} {
+	switch v := g.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return g
+}
+
+// decodeGeneric unmarshals into a generic value and then into v, unless v is
+// itself a generic value
+func decodeGeneric(unmarshal func(interface{}) error, v interface{}) error {
+	if g, ok := v.(*interface{}); ok {
+		return unmarshal(g)
+	}
+	var g interface{}
+	if err := unmarshal(&g); err != nil {
+		return err
+	}
+	return fromGeneric(g, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(numbers(g))
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return decodeGeneric(func(g interface{}) error {
+		return msgpack.Unmarshal(data, g)
+	}, v)
+}
+
+var cborDecoder, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+type cborCodec struct{}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(numbers(g))
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return decodeGeneric(func(g interface{}) error {
+		return cborDecoder.Unmarshal(data, g)
+	}, v)
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(numbers(g))
+}
+
+func (yamlCodec) Unmarshal(data []byte, v interface{}) error {
+	return decodeGeneric(func(g interface{}) error {
+		return yaml.Unmarshal(data, g)
+	}, v)
+}
@@ -0,0 +1,142 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// xmlRoot is the name of the document element of XML representations
+const xmlRoot = "response"
+
+// xmlItem is the name of the elements holding array entries
+const xmlItem = "item"
+
+// xmlCodec maps the JSON form of a value onto XML elements: object members
+// become child elements, array entries become item elements
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(xml.Header)
+	e := xml.NewEncoder(buf)
+	if err := encodeXML(e, xmlRoot, g); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeXML(e *xml.Encoder, name string, g interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := g.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXML(e, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(e, xmlItem, item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := e.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+type xmlNode struct {
+	name     string
+	text     string
+	children []*xmlNode
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	stack := []*xmlNode{}
+	var root *xmlNode
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				break
+			}
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	g := xmlValue(root)
+	if p, ok := v.(*interface{}); ok {
+		*p = g
+		return nil
+	}
+	bd, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bd, v)
+}
+
+// xmlValue converts an element into the generic form: elements made only of
+// item children become arrays, other parents objects and leaves strings
+func xmlValue(n *xmlNode) interface{} {
+	if len(n.children) == 0 {
+		return strings.TrimSpace(n.text)
+	}
+	items := true
+	for _, c := range n.children {
+		if c.name != xmlItem {
+			items = false
+		}
+	}
+	if items {
+		list := make([]interface{}, len(n.children))
+		for i, c := range n.children {
+			list[i] = xmlValue(c)
+		}
+		return list
+	}
+	m := map[string]interface{}{}
+	for _, c := range n.children {
+		m[c.name] = xmlValue(c)
+	}
+	return m
+}
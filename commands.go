@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/christianotieno/go-rest-api/backup"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"io"
+	"os"
+	"strconv"
+)
+
+const usage = `usage:
+  go-rest-api                      serve the API
+  go-rest-api migrate status       list the migrations of every tenant
+  go-rest-api migrate up           apply the pending migrations of every tenant
+  go-rest-api migrate down [n]     roll back the last n migrations of every
+                                   tenant (default 1)
+  go-rest-api backup <file> [tenant]
+                                   write a snapshot of users.db to file
+  go-rest-api restore <file> [tenant]
+                                   replace users.db with a snapshot
+`
+
+// command runs the command line arguments and returns the exit code
+func command(args []string, out io.Writer) int {
+	if len(args) < 2 || len(args) > 3 || args[0] != "backup" && args[0] != "restore" {
+		return migrateCommand(args, out)
+	}
+	t := tenant.Default
+	if len(args) == 3 {
+		found, err := tenant.One(args[2])
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		t = *found
+	}
+	path := t.Path(user.DBPath)
+	if args[0] == "backup" {
+		if err := backup.WriteFile(path, args[1]); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		fmt.Fprintln(out, "backed up to", args[1])
+		return 0
+	}
+	previous, err := backup.Restore(path, args[1])
+	if previous != "" {
+		fmt.Fprintln(out, "backed up to", previous)
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, "restored", args[1])
+	return 0
+}
+
+// migrateCommand runs the migrate command
+func migrateCommand(args []string, out io.Writer) int {
+	if len(args) < 2 || args[0] != "migrate" {
+		fmt.Fprint(out, usage)
+		return 2
+	}
+	switch args[1] {
+	case "status":
+		if err := statusAll(out); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	case "up":
+		if err := migrateAll(out, true); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 1 {
+				fmt.Fprint(out, usage)
+				return 2
+			}
+			steps = n
+		}
+		if err := rollbackAll(out, steps); err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+	default:
+		fmt.Fprint(out, usage)
+		return 2
+	}
+	return 0
+}
+
+func report(out io.Writer, action string, ran []migrate.Migration, backup string) {
+	if backup != "" {
+		fmt.Fprintln(out, "backed up to", backup)
+	}
+	for _, m := range ran {
+		fmt.Fprintf(out, "%s %d %s\n", action, m.Version, m.Name)
+	}
+	if len(ran) == 0 && backup == "" {
+		fmt.Fprintln(out, "nothing to do")
+	}
+}
+
+// heading names the database at path before what is reported about it,
+// unless it is the one of the default tenant
+func heading(out io.Writer, path string) {
+	if path != user.DBPath {
+		fmt.Fprintln(out, path+":")
+	}
+}
+
+// statusAll lists the migrations of the users database of every tenant
+func statusAll(out io.Writer) error {
+	return tenant.Each(context.Background(), func(ctx context.Context) error {
+		path := tenant.FromContext(ctx).Path(user.DBPath)
+		states, err := migrate.Status(path)
+		if err != nil {
+			return err
+		}
+		heading(out, path)
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%4d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	})
+}
+
+// migrateAll applies the pending migrations to the users database of every
+// tenant, reporting what was done or only what was applied
+func migrateAll(out io.Writer, verbose bool) error {
+	return tenant.Each(context.Background(), func(ctx context.Context) error {
+		path := tenant.FromContext(ctx).Path(user.DBPath)
+		ran, backup, err := migrate.Up(path)
+		if verbose || len(ran) > 0 {
+			heading(out, path)
+			report(out, "applied", ran, backup)
+		}
+		return err
+	})
+}
+
+// rollbackAll rolls back the last steps migrations of the users database of
+// every tenant
+func rollbackAll(out io.Writer, steps int) error {
+	return tenant.Each(context.Background(), func(ctx context.Context) error {
+		path := tenant.FromContext(ctx).Path(user.DBPath)
+		ran, backup, err := migrate.Down(path, steps)
+		heading(out, path)
+		report(out, "rolled back", ran, backup)
+		return err
+	})
+}
+
+// migrateOnStartup applies the pending migrations before serving
+func migrateOnStartup() {
+	if err := migrateAll(os.Stdout, false); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
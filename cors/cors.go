@@ -0,0 +1,159 @@
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is the CORS policy applied to the origins it matches. Origins are
+// exact ("https://app.example.com"), wildcard patterns ("https://*.example.com")
+// or "*" for any origin. Empty Methods allow every method of the route.
+// Credentials are never allowed for "*": any site could otherwise read the
+// responses of the users signed in, so those origins get a literal "*".
+type Rule struct {
+	Origins          []string
+	Methods          []string
+	Headers          []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Policy is an ordered list of rules; the first rule matching an origin wins
+type Policy struct {
+	Rules []Rule
+}
+
+// MethodsFunc returns the methods served by the route of a request
+type MethodsFunc func(r *http.Request) []string
+
+// New returns a policy made of the given rules
+func New(rules ...Rule) *Policy {
+	return &Policy{Rules: rules}
+}
+
+// Match returns the rule that applies to origin or nil if the origin is not allowed
+func (p *Policy) Match(origin string) *Rule {
+	if p == nil || origin == "" {
+		return nil
+	}
+	for i := range p.Rules {
+		for _, pattern := range p.Rules[i].Origins {
+			if matchOrigin(pattern, origin) {
+				return &p.Rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// matchOrigin compares origin against an exact or wildcard pattern
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
+		return true
+	}
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return false
+	}
+	prefix, suffix := strings.ToLower(pattern[:i]), strings.ToLower(pattern[i+1:])
+	origin = strings.ToLower(origin)
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) &&
+		strings.HasSuffix(origin, suffix)
+}
+
+// Handler adds CORS headers to the responses of next and answers preflight
+// requests from the methods the route serves
+func (p *Policy) Handler(next http.Handler, methods MethodsFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		rule := p.Match(origin)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requested := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requested != "" {
+			preflight(w, r, rule, methods(r))
+			return
+		}
+		setOrigin(w.Header(), rule, origin)
+		if len(rule.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposedHeaders, ","))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setOrigin(h http.Header, rule *Rule, origin string) {
+	if contains(rule.Origins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if rule.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func preflight(w http.ResponseWriter, r *http.Request, rule *Rule, routeMethods []string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	allowed := []string{}
+	for _, m := range routeMethods {
+		if len(rule.Methods) == 0 || contains(rule.Methods, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	if !contains(allowed, r.Header.Get("Access-Control-Request-Method")) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	headers := requestedHeaders(r)
+	for _, hd := range headers {
+		if !contains(rule.Headers, "*") && !contains(rule.Headers, hd) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	setOrigin(h, rule, r.Header.Get("Origin"))
+	h.Set("Access-Control-Allow-Methods", strings.Join(allowed, ","))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ","))
+	}
+	if rule.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(rule.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func requestedHeaders(r *http.Request) []string {
+	headers := []string{}
+	for _, hd := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		hd = strings.TrimSpace(hd)
+		if hd != "" {
+			headers = append(headers, http.CanonicalHeaderKey(hd))
+		}
+	}
+	return headers
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	ts := []struct {
+		pattern string
+		origin  string
+		exp     bool
+	}{
+		{"*", "https://anything.test", true},
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "https://APP.example.com", true},
+		{"https://app.example.com", "https://evil.example.com", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.test", false},
+		{"http://localhost:*", "http://localhost:3000", true},
+	}
+
+	for _, tc := range ts {
+		got := matchOrigin(tc.pattern, tc.origin)
+		if got != tc.exp {
+			t.Errorf("Expected match of %s against %s to be %v, got %v", tc.origin, tc.pattern, tc.exp, got)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p := New(
+		Rule{
+			Origins:          []string{"https://*.example.com"},
+			Headers:          []string{"Content-Type"},
+			ExposedHeaders:   []string{"Location"},
+			AllowCredentials: true,
+			MaxAge:           time.Minute,
+		},
+		Rule{
+			Origins: []string{"https://readonly.test"},
+			Methods: []string{http.MethodGet},
+		},
+	)
+	called := false
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}), func(*http.Request) []string {
+		return []string{http.MethodGet, http.MethodPost, http.MethodOptions}
+	})
+
+	t.Log("Simple request from an allowed origin")
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !called {
+		t.Error("Expected the next handler to be called")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Expected allowed origin https://app.example.com, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Expected credentials to be allowed, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "Location" {
+		t.Errorf("Expected exposed headers Location, got %s", got)
+	}
+
+	t.Log("Request from an unknown origin")
+	r = httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Origin", "https://evil.test")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no allowed origin, got %s", got)
+	}
+
+	t.Log("Preflight request")
+	called = false
+	r = httptest.NewRequest(http.MethodOptions, "/users", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if called {
+		t.Error("Expected the preflight not to reach the next handler")
+	}
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET,POST,OPTIONS" {
+		t.Errorf("Expected allowed methods GET,POST,OPTIONS, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Content-Type" {
+		t.Errorf("Expected allowed headers Content-Type, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "60" {
+		t.Errorf("Expected max age 60, got %s", got)
+	}
+
+	t.Log("Preflight for a method the origin may not use")
+	r = httptest.NewRequest(http.MethodOptions, "/users", nil)
+	r.Header.Set("Origin", "https://readonly.test")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestHandlerWildcard(t *testing.T) {
+	h := New(Rule{Origins: []string{"*"}, AllowCredentials: true}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(*http.Request) []string {
+		return []string{http.MethodGet, http.MethodOptions}
+	})
+
+	t.Log("Any origin gets a literal * without credentials")
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Origin", "https://evil.test")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected allowed origin *, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Expected credentials not to be allowed, got %s", got)
+	}
+}
@@ -0,0 +1,282 @@
+package decode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/christianotieno/go-rest-api/codec"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Options are the rules a request body must follow
+type Options struct {
+	// ContentTypes are the accepted media types
+	ContentTypes []string
+	// MaxBytes is the maximum size of the body
+	MaxBytes int64
+	// AllowUnknown accepts fields that do not exist in the target
+	AllowUnknown bool
+	// ReadOnly are the top-level fields clients may not set
+	ReadOnly []string
+	// Codecs converts bodies in other representations to JSON before they
+	// are checked; their media types are accepted too
+	Codecs *codec.Registry
+}
+
+// Defaults are the rules applied to JSON request bodies
+var Defaults = Options{
+	ContentTypes: []string{"application/json"},
+	MaxBytes:     1 << 20,
+}
+
+// Error describes why a body was rejected and where
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = fmt.Sprintf("%s: %s", e.Field, msg)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("%s (line %d, column %d)", msg, e.Line, e.Column)
+	}
+	return msg
+}
+
+// Status returns the HTTP status code for a decoding error
+func Status(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return http.StatusBadRequest
+}
+
+// JSON decodes the body of r into v following the options
+func JSON(r *http.Request, v interface{}, o Options) error {
+	bd, err := Body(r, o)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(bd, v, o)
+}
+
+// Body checks the content type of r and reads its body up to the size limit
+func Body(r *http.Request, o Options) ([]byte, error) {
+	if r == nil || r.Body == nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "request body is empty"}
+	}
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	types := o.ContentTypes
+	if o.Codecs != nil {
+		types = append(append([]string{}, types...), o.Codecs.Types()...)
+	}
+	if err != nil || !contains(types, mt) {
+		return nil, &Error{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: "content type must be one of " + strings.Join(types, ", "),
+		}
+	}
+	body := r.Body
+	if o.MaxBytes > 0 {
+		body = http.MaxBytesReader(nil, r.Body, o.MaxBytes)
+	}
+	bd, err := io.ReadAll(body)
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, &Error{
+				Status:  http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("request body exceeds %d bytes", o.MaxBytes),
+			}
+		}
+		return nil, &Error{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if o.Codecs != nil && !contains(o.ContentTypes, mt) {
+		_, c, _ := o.Codecs.ForContentType(mt)
+		bd, err = codec.ToJSON(c, bd)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "body is not valid " + mt}
+		}
+	}
+	return bd, nil
+}
+
+// Unmarshal decodes a JSON document into v following the options
+func Unmarshal(bd []byte, v interface{}, o Options) error {
+	if v == nil {
+		return errors.New("a target is required")
+	}
+	fields, err := topLevelFields(bd)
+	if err != nil {
+		return syntaxError(bd, err)
+	}
+	known := jsonFields(v)
+	for _, f := range fields {
+		line, col := position(bd, f.offset)
+		if containsFold(o.ReadOnly, f.name) {
+			return &Error{
+				Status:  http.StatusUnprocessableEntity,
+				Message: "field is read-only",
+				Field:   f.name,
+				Line:    line,
+				Column:  col,
+			}
+		}
+		if !o.AllowUnknown && known != nil && !containsFold(known, f.name) {
+			return &Error{
+				Status:  http.StatusUnprocessableEntity,
+				Message: "unknown field",
+				Field:   f.name,
+				Line:    line,
+				Column:  col,
+			}
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(bd))
+	if !o.AllowUnknown {
+		d.DisallowUnknownFields()
+	}
+	err = d.Decode(v)
+	if err == nil {
+		return nil
+	}
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) {
+		offset := te.Offset
+		for _, f := range fields {
+			if f.name == te.Field {
+				offset = f.offset
+			}
+		}
+		line, col := position(bd, offset)
+		return &Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "expected " + te.Type.String() + " but got " + te.Value,
+			Field:   te.Field,
+			Line:    line,
+			Column:  col,
+		}
+	}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return &Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "unknown field",
+			Field:   strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+		}
+	}
+	return syntaxError(bd, err)
+}
+
+func syntaxError(bd []byte, err error) error {
+	e := &Error{Status: http.StatusBadRequest, Message: err.Error()}
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		e.Line, e.Column = position(bd, se.Offset)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		e.Message = "request body is not valid JSON"
+	}
+	return e
+}
+
+type field struct {
+	name   string
+	offset int64
+}
+
+// topLevelFields lists the members of a JSON object with their offsets
+func topLevelFields(bd []byte) ([]field, error) {
+	d := json.NewDecoder(bytes.NewReader(bd))
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, nil
+	}
+	fields := []field{}
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := tok.(string)
+		end := d.InputOffset()
+		start := bytes.LastIndexByte(bd[:end-1], '"')
+		fields = append(fields, field{name: name, offset: int64(start)})
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// jsonFields lists the JSON names of the fields of the struct v points to
+func jsonFields(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	names := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// position converts a byte offset into a 1-based line and column
+func position(bd []byte, offset int64) (int, int) {
+	if offset > int64(len(bd)) {
+		offset = int64(len(bd))
+	}
+	before := bd[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"github.com/christianotieno/go-rest-api/codec"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type target struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func request(contentType, body string) *http.Request {
+	return &http.Request{
+		Header: http.Header{"Content-Type": []string{contentType}},
+		Body:   io.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+func TestJSON(t *testing.T) {
+	o := Options{
+		ContentTypes: []string{"application/json"},
+		MaxBytes:     64,
+		ReadOnly:     []string{"id"},
+	}
+	ts := []struct {
+		txt string
+		r   *http.Request
+		o   Options
+		exp *target
+		err *Error
+	}{
+		{
+			txt: "nil request",
+			err: &Error{Status: http.StatusBadRequest},
+		},
+		{
+			txt: "valid body",
+			r:   request("application/json; charset=utf-8", `{"name":"John","age":37}`),
+			exp: &target{Name: "John", Age: 37},
+		},
+		{
+			txt: "unsupported content type",
+			r:   request("text/plain", `{"name":"John"}`),
+			err: &Error{Status: http.StatusUnsupportedMediaType},
+		},
+		{
+			txt: "missing content type",
+			r:   request("", `{"name":"John"}`),
+			err: &Error{Status: http.StatusUnsupportedMediaType},
+		},
+		{
+			txt: "body too large",
+			r:   request("application/json", `{"name":"`+strings.Repeat("a", 64)+`"}`),
+			err: &Error{Status: http.StatusRequestEntityTooLarge},
+		},
+		{
+			txt: "body in another representation",
+			r:   request("application/yaml", "name: John\nage: 37\n"),
+			o:   Options{ContentTypes: []string{"application/json"}, Codecs: codec.Default},
+			exp: &target{Name: "John", Age: 37},
+		},
+		{
+			txt: "unknown field",
+			r:   request("application/json", "{\n  \"name\": \"John\",\n  \"role\": \"Tester\"\n}"),
+			err: &Error{Status: http.StatusUnprocessableEntity, Field: "role", Line: 3, Column: 3},
+		},
+		{
+			txt: "unknown field allowed",
+			r:   request("application/json", `{"name":"John","role":"Tester"}`),
+			o:   Options{ContentTypes: []string{"application/json"}, AllowUnknown: true},
+			exp: &target{Name: "John"},
+		},
+		{
+			txt: "read-only field",
+			r:   request("application/json", `{"id":"1234","name":"John"}`),
+			err: &Error{Status: http.StatusUnprocessableEntity, Field: "id", Line: 1, Column: 2},
+		},
+		{
+			txt: "wrong type",
+			r:   request("application/json", `{"age":"37"}`),
+			err: &Error{Status: http.StatusUnprocessableEntity, Field: "age", Line: 1, Column: 2},
+		},
+		{
+			txt: "malformed body",
+			r:   request("application/json", "{\n\"name\": John}"),
+			err: &Error{Status: http.StatusBadRequest, Line: 2, Column: 10},
+		},
+	}
+
+	for _, tc := range ts {
+		t.Log(tc.txt)
+		opts := o
+		if tc.o.ContentTypes != nil {
+			opts = tc.o
+		}
+		got := &target{}
+		err := JSON(tc.r, got, opts)
+		if tc.err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Errorf("Expected a decoding error, got %v", err)
+				continue
+			}
+			if e.Status != tc.err.Status || e.Field != tc.err.Field || e.Line != tc.err.Line || e.Column != tc.err.Column {
+				t.Errorf("Expected error %+v, got %+v", tc.err, e)
+			}
+			if Status(err) != tc.err.Status {
+				t.Errorf("Expected status %d, got %d", tc.err.Status, Status(err))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Did not expect an error but got one: %s", err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.exp, got) {
+			t.Errorf("Expected %+v, got %+v", tc.exp, got)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/christianotieno/go-rest-api/client"
+	"github.com/christianotieno/go-rest-api/client/clienttest"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	clienttest.Run(t, func(t *testing.T) *client.Client {
+		id := clienttest.Tenant(t)
+		srv := httptest.NewServer(newServer())
+		t.Cleanup(srv.Close)
+		c := client.New(srv.URL)
+		c.Tenant = id
+		c.Auth = client.BearerToken(clienttest.Token(t, id))
+		return c
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAdminLimits(t *testing.T) {
+	e := newServer()
+	paths := []string{"/webhooks", "/webhooks/5f1a5b3e8f1b2c3d4e5f6a7b/deliveries", "/tenants", "/tenants/acme", "/audit", "/admin/backup"}
+	for i, path := range paths {
+		t.Log(path)
+		limited := false
+		for n := 0; n < 30 && !limited; n++ {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.RemoteAddr = "192.0.2." + strconv.Itoa(i+1) + ":1234"
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			limited = w.Code == http.StatusTooManyRequests
+		}
+		if !limited {
+			t.Errorf("Expected %s to be rate limited", path)
+		}
+	}
+}
@@ -1,20 +1,194 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/audit"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/authz"
+	"github.com/christianotieno/go-rest-api/backup"
 	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/cors"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/feed"
+	"github.com/christianotieno/go-rest-api/hal"
+	"github.com/christianotieno/go-rest-api/handlers"
+	"github.com/christianotieno/go-rest-api/idempotency"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"github.com/christianotieno/go-rest-api/openapi"
+	"github.com/christianotieno/go-rest-api/patch"
+	"github.com/christianotieno/go-rest-api/ratelimit"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/transfer"
 	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"gopkg.in/mgo.v2/bson"
+	"log"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type jsonResponse map[string]interface{}
 
+// respond writes content in the representation negotiated from the Accept
+// header of the request
+func respond(c echo.Context, code int, content jsonResponse) error {
+	mt, cd, ok := codec.Default.Negotiate(c.Request().Header.Get(echo.HeaderAccept))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotAcceptable)
+	}
+	bd, err := cd.Marshal(content)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	return c.Blob(code, mt, bd)
+}
+
+// acceptable rejects requests for representations no codec produces
+func acceptable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, _, ok := codec.Default.Negotiate(c.Request().Header.Get(echo.HeaderAccept)); !ok {
+			return echo.NewHTTPError(http.StatusNotAcceptable)
+		}
+		return next(c)
+	}
+}
+
+// userDecoding are the rules for user request bodies
+var userDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     user.ReadOnly,
+	Codecs:       codec.Default,
+}
+
+// patchDecoding are the rules for PATCH request bodies
+var patchDecoding = decode.Options{
+	ContentTypes: []string{patch.MergePatchType, patch.JSONPatchType, echo.MIMEApplicationJSON},
+	MaxBytes:     decode.Defaults.MaxBytes,
+}
+
+// decodeError reports why a request body was rejected
+func decodeError(c echo.Context, err error) error {
+	var e *decode.Error
+	if errors.As(err, &e) {
+		return respond(c, e.Status, jsonResponse{"error": e})
+	}
+	return echo.NewHTTPError(http.StatusBadRequest)
+}
+
+// includeDeleted reports whether the request asks for deleted users too
+func includeDeleted(c echo.Context) bool {
+	return c.QueryParam("include") == "deleted"
+}
+
+// indexFilter returns the indexed field, such as email, the request filters
+// users by. Filtering by more than one field is not supported.
+func indexFilter(c echo.Context) (idx user.Index, value string, ok bool, err error) {
+	query := c.QueryParams()
+	for _, i := range user.Indexes {
+		if _, set := query[i.Name]; !set {
+			continue
+		}
+		if ok {
+			return user.Index{}, "", false, errors.New("only one indexed field can be filtered on")
+		}
+		idx, value, ok = i, query.Get(i.Name), true
+	}
+	return idx, value, ok, nil
+}
+
+// cacheable reports whether the response to the request may be cached.
+// Only reads are, and filtered, paged, shaped and expanded reads and reads
+// of deleted users or past revisions are not, since writes only drop the
+// plain resources.
+func cacheable(c echo.Context) bool {
+	if m := c.Request().Method; m != http.MethodGet && m != http.MethodHead {
+		return false
+	}
+	_, _, filtered, _ := indexFilter(c)
+	page, err := hal.ParsePage(c.QueryParams())
+	return !filtered && !includeDeleted(c) && err == nil && !page.Paged() && c.QueryParam("as_of") == "" && !shapeOf(c).Shaped()
+}
+
+// shapeKey is the context key of the options shaping representations
+const shapeKey = "shape"
+
+// shape checks the fields and expand parameters of requests for
+// representations with the given fields and relations, and keeps them for
+// the handler
+func shape(fields, relations []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			opts, err := hal.Parse(c.QueryParams(), fields, relations)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			c.Set(shapeKey, opts)
+			return next(c)
+		}
+	}
+}
+
+// shapeOf returns the options shaping the representations of the response
+func shapeOf(c echo.Context) hal.Options {
+	opts, _ := c.Get(shapeKey).(hal.Options)
+	return opts
+}
+
+// respondUser writes the representation of u with its links
+func respondUser(c echo.Context, code int, u *user.User) error {
+	m, err := hal.User(c.Request().Context(), u, shapeOf(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return respond(c, code, jsonResponse{"user": m})
+}
+
+// respondUsers writes the representations of users with their links and
+// those of the collection
+func respondUsers(c echo.Context, users []user.User, links hal.Links) error {
+	ms, err := hal.Users(c.Request().Context(), users, shapeOf(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return respond(c, http.StatusOK, jsonResponse{"users": ms, hal.LinksKey: links})
+}
+
+// respondGroup writes the representation of g with its links
+func respondGroup(c echo.Context, code int, g *user.Group) error {
+	m, err := hal.Group(c.Request().Context(), g, shapeOf(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return respond(c, code, jsonResponse{"group": m})
+}
+
+// respondGroups writes the representations of groups with their links and
+// those of the collection at self
+func respondGroups(c echo.Context, groups []user.Group, self string) error {
+	ms, err := hal.Groups(c.Request().Context(), groups, shapeOf(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return respond(c, http.StatusOK, jsonResponse{"groups": ms, hal.LinksKey: hal.CollectionLinks(self)})
+}
+
 func serverCache(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !cacheable(c) {
+			return next(c)
+		}
 		if cache.Serve(c.Response(), c.Request()) {
 			return nil
 		}
@@ -24,48 +198,128 @@ func serverCache(next echo.HandlerFunc) echo.HandlerFunc {
 
 func cacheResponse(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !cacheable(c) {
+			return next(c)
+		}
 		c.Response().Writer = cache.NewWriter(c.Response().Writer, c.Request())
 		return next(c)
 	}
 }
 
-func usersOptions(c echo.Context) error {
-	methods := []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions}
-	c.Response().Header().Set("Allow", strings.Join(methods, ","))
-	return c.NoContent(http.StatusOK)
+// routeMethods returns the methods registered for the route path
+func routeMethods(e *echo.Echo, path string) []string {
+	methods := []string{}
+	for _, r := range e.Routes() {
+		if r.Path == path && r.Method != echo.RouteNotFound {
+			methods = append(methods, r.Method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
 }
 
-func userOptions(c echo.Context) error {
-	methods := []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
-	c.Response().Header().Set("Allow", strings.Join(methods, ","))
-	return c.NoContent(http.StatusOK)
+// options answers OPTIONS requests from the methods registered for the route
+func options(e *echo.Echo) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Allow", strings.Join(routeMethods(e, c.Path()), ","))
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// corsMiddleware applies the CORS policy using the methods of the matched route
+func corsMiddleware(e *echo.Echo, p *cors.Policy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var err error
+			h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				err = next(c)
+			}), func(*http.Request) []string {
+				return routeMethods(e, c.Path())
+			})
+			h.ServeHTTP(c.Response(), c.Request())
+			return err
+		}
+	}
+}
+
+// trashRetention is how long deleted users can be restored before they are
+// purged
+const trashRetention = 30 * 24 * time.Hour
+
+// Defaults of scheduled backups
+const (
+	backupInterval = 24 * time.Hour
+	backupKeep     = 7
+)
+
+// scheduleBackups snapshots the users database of every tenant to the
+// BACKUP_DIR directory, when it is set, every BACKUP_INTERVAL keeping the
+// BACKUP_KEEP most recent snapshots of each
+func scheduleBackups() {
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		return
+	}
+	interval, err := time.ParseDuration(os.Getenv("BACKUP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = backupInterval
+	}
+	keep, err := strconv.Atoi(os.Getenv("BACKUP_KEEP"))
+	if err != nil || keep < 1 {
+		keep = backupKeep
+	}
+	go backup.Scheduler(context.Background(), handlers.BackupTargets(dir), interval, keep)
 }
+
 func usersGetAll(c echo.Context) error {
-	users, err := user.All()
+	idx, value, filtered, err := indexFilter(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	page, err := hal.ParsePage(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if filtered {
+		users, err := user.FindByContext(c.Request().Context(), idx, value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return respondUsers(c, users, hal.CollectionLinks("/users"))
+	}
+	links := hal.CollectionLinks("/users")
+	var users []user.User
+	switch {
+	case includeDeleted(c):
+		users, err = user.AllWithDeletedContext(c.Request().Context())
+	case page.Paged():
+		users, err = user.PageContext(c.Request().Context(), page.After, page.Limit)
+		links = hal.PageLinks("/users", c.QueryParams(), page, users)
+	default:
+		users, err = user.AllContext(c.Request().Context())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
 	if c.Request().Method == http.MethodHead {
 		return c.NoContent(http.StatusOK)
 	}
-	return c.JSON(http.StatusOK, jsonResponse{"users": users})
+	return respondUsers(c, users, links)
 }
 
 func usersPostOne(c echo.Context) error {
 	u := new(user.User)
-	err := c.Bind(u)
+	err := decode.JSON(c.Request(), u, userDecoding)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest)
+		return decodeError(c, err)
 	}
 	u.ID = bson.NewObjectId()
-	err = u.Save()
+	err = u.SaveContext(c.Request().Context())
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			return echo.NewHTTPError(http.StatusBadRequest)
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError(c, err)
 	}
-	cache.Drop("/users/")
+	cache.DropUsers(c.Request().Context(), u.ID)
 	c.Response().Header().Set("Location", "/users/"+u.ID.Hex())
 	return c.NoContent(http.StatusCreated)
 }
@@ -75,40 +329,57 @@ func usersGetOne(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 	id := bson.ObjectIdHex(c.Param("id"))
-	u, err := user.One(id)
-	if err != nil {
-		if err == storm.ErrNotFound {
-			return echo.NewHTTPError(http.StatusNotFound)
+	one := user.OneContext
+	if includeDeleted(c) {
+		one = user.OneWithDeletedContext
+	}
+	if asOf := c.QueryParam("as_of"); asOf != "" {
+		t, err := time.Parse(time.RFC3339Nano, asOf)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest)
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		one = func(ctx context.Context, id bson.ObjectId) (*user.User, error) { return user.AsOfContext(ctx, id, t) }
+	}
+	u, err := one(c.Request().Context(), id)
+	if err != nil {
+		return storeError(c, err)
 	}
 	if c.Request().Method == http.MethodHead {
 		return c.NoContent(http.StatusOK)
 	}
-	return c.JSON(http.StatusOK, jsonResponse{"user": u})
+	return respondUser(c, http.StatusOK, u)
 }
 
 func usersPutOne(c echo.Context) error {
 	u := new(user.User)
-	err := c.Bind(u)
+	err := decode.JSON(c.Request(), u, userDecoding)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest)
+		return decodeError(c, err)
 	}
 	if !bson.IsObjectIdHex(c.Param("id")) {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 	id := bson.ObjectIdHex(c.Param("id"))
 	u.ID = id
-	err = u.Save()
+	err = u.SaveContext(c.Request().Context())
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			return echo.NewHTTPError(http.StatusBadRequest)
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError)
+		return storeError(c, err)
+	}
+	cache.DropUsers(c.Request().Context(), id)
+	return respondUser(c, http.StatusOK, u)
+}
+
+// patchStatus maps patch errors to HTTP status codes
+func patchStatus(err error) int {
+	switch {
+	case errors.Is(err, patch.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, patch.ErrTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, patch.ErrInvalidPath):
+		return http.StatusUnprocessableEntity
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(c.Request()))
-	return c.JSON(http.StatusOK, jsonResponse{"user": u})
+	return http.StatusBadRequest
 }
 
 func usersPatchOne(c echo.Context) error {
@@ -116,32 +387,47 @@ func usersPatchOne(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 	id := bson.ObjectIdHex(c.Param("id"))
-	u, err := user.One(id)
+	u, err := user.OneContext(c.Request().Context(), id)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-	err = c.Bind(u)
+	bd, err := decode.Body(c.Request(), patchDecoding)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusUnprocessableEntity)
+		return decodeError(c, err)
 	}
-	if !bson.IsObjectIdHex(c.Param("id")) {
-		return echo.NewHTTPError(http.StatusNotFound)
+	doc, err := json.Marshal(u)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-	id = bson.ObjectIdHex(c.Param("id"))
-	u.ID = id
-	err = u.Save()
+	patched, err := patch.Apply(c.Request().Header.Get(echo.HeaderContentType), doc, bd)
+	if err != nil {
+		return echo.NewHTTPError(patchStatus(err), err.Error())
+	}
+	field, err := patch.Changed(doc, patched, user.ReadOnly)
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			return echo.NewHTTPError(http.StatusBadRequest)
-		}
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(c.Request()))
-	return c.JSON(http.StatusOK, jsonResponse{"user": u})
+	if field != "" {
+		return decodeError(c, &decode.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "field is read-only",
+			Field:   field,
+		})
+	}
+	u = new(user.User)
+	err = decode.Unmarshal(patched, u, decode.Options{})
+	if err != nil {
+		return decodeError(c, err)
+	}
+	err = u.SaveContext(c.Request().Context())
+	if err != nil {
+		return storeError(c, err)
+	}
+	cache.DropUsers(c.Request().Context(), id)
+	return respondUser(c, http.StatusOK, u)
 }
 
 func usersDeleteOne(c echo.Context) error {
@@ -149,57 +435,386 @@ func usersDeleteOne(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 	id := bson.ObjectIdHex(c.Param("id"))
-	err := user.Delete(id)
+	err := user.DeleteContext(c.Request().Context(), id)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			return echo.NewHTTPError(http.StatusNotFound)
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(c.Request()))
+	cache.DropUsers(c.Request().Context(), id)
 	return c.NoContent(http.StatusOK)
 }
 
+func usersRestoreOne(c echo.Context) error {
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	id := bson.ObjectIdHex(c.Param("id"))
+	u, err := user.RestoreContext(c.Request().Context(), id)
+	if err != nil {
+		return storeError(c, err)
+	}
+	cache.DropUsers(c.Request().Context(), id)
+	return respondUser(c, http.StatusOK, u)
+}
+
+func usersRevisions(c echo.Context) error {
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	revs, err := user.RevisionsContext(c.Request().Context(), bson.ObjectIdHex(c.Param("id")))
+	if err != nil {
+		return storeError(c, err)
+	}
+	return respond(c, http.StatusOK, jsonResponse{"revisions": revs})
+}
+
+func usersRevert(c echo.Context) error {
+	if !bson.IsObjectIdHex(c.Param("id")) {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	id := bson.ObjectIdHex(c.Param("id"))
+	version, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || version < 1 {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	u, err := user.Revert(c.Request().Context(), id, version)
+	if err != nil {
+		return storeError(c, err)
+	}
+	cache.DropUsers(c.Request().Context(), id)
+	return respondUser(c, http.StatusOK, u)
+}
+
+// storeError reports why the user store rejected a request, naming the
+// invalid or conflicting field if there is one
+func storeError(c echo.Context, err error) error {
+	var fe *user.FieldError
+	if errors.As(err, &fe) {
+		return respond(c, handlers.ErrorStatus(err), jsonResponse{"error": fe})
+	}
+	var ce *user.ConflictError
+	if errors.As(err, &ce) {
+		e := jsonResponse{"message": ce.Error(), "field": ce.Field, "value": ce.Value}
+		if ce.Trashed {
+			e["trashed"], e["id"] = true, ce.ID.Hex()
+		}
+		return respond(c, handlers.ErrorStatus(err), jsonResponse{"error": e})
+	}
+	return echo.NewHTTPError(handlers.ErrorStatus(err))
+}
+
+// groupDecoding are the rules for group request bodies
+var groupDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     user.GroupReadOnly,
+	Codecs:       codec.Default,
+}
+
+// objectID returns the path parameter name as an object id, or false if it
+// is not one
+func objectID(c echo.Context, name string) (bson.ObjectId, bool) {
+	if !bson.IsObjectIdHex(c.Param(name)) {
+		return "", false
+	}
+	return bson.ObjectIdHex(c.Param(name)), true
+}
+
+func usersGetGroups(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	groups, err := user.GroupsOfContext(c.Request().Context(), id)
+	if err != nil {
+		return storeError(c, err)
+	}
+	return respondGroups(c, groups, "/users/"+id.Hex()+"/groups")
+}
+
+func groupsGetAll(c echo.Context) error {
+	groups, err := user.GroupsContext(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return respondGroups(c, groups, "/groups")
+}
+
+func groupsPostOne(c echo.Context) error {
+	g := new(user.Group)
+	if err := decode.JSON(c.Request(), g, groupDecoding); err != nil {
+		return decodeError(c, err)
+	}
+	g.ID = bson.NewObjectId()
+	if err := g.SaveContext(c.Request().Context()); err != nil {
+		return storeError(c, err)
+	}
+	c.Response().Header().Set("Location", "/groups/"+g.ID.Hex())
+	return respondGroup(c, http.StatusCreated, g)
+}
+
+func groupsGetOne(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	g, err := user.OneGroupContext(c.Request().Context(), id)
+	if err != nil {
+		return storeError(c, err)
+	}
+	return respondGroup(c, http.StatusOK, g)
+}
+
+func groupsPutOne(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	g := new(user.Group)
+	if err := decode.JSON(c.Request(), g, groupDecoding); err != nil {
+		return decodeError(c, err)
+	}
+	g.ID = id
+	if err := g.SaveContext(c.Request().Context()); err != nil {
+		return storeError(c, err)
+	}
+	return respondGroup(c, http.StatusOK, g)
+}
+
+func groupsDeleteOne(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err := user.DeleteGroupContext(c.Request().Context(), id); err != nil {
+		return storeError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func groupsGetMembers(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	users, err := user.MembersContext(c.Request().Context(), id)
+	if err != nil {
+		return storeError(c, err)
+	}
+	return respondUsers(c, users, hal.CollectionLinks("/groups/"+id.Hex()+"/members"))
+}
+
+func groupsPostMember(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	m := struct {
+		UserID bson.ObjectId `json:"user_id"`
+	}{}
+	if err := decode.JSON(c.Request(), &m, groupDecoding); err != nil {
+		return decodeError(c, err)
+	}
+	if !m.UserID.Valid() {
+		return storeError(c, &user.FieldError{Field: "user_id", Message: "is required"})
+	}
+	if err := user.AddMemberContext(c.Request().Context(), id, m.UserID); err != nil {
+		return storeError(c, err)
+	}
+	c.Response().Header().Set("Location", "/groups/"+id.Hex()+"/members/"+m.UserID.Hex())
+	return c.NoContent(http.StatusCreated)
+}
+
+func groupsDeleteMember(c echo.Context) error {
+	id, ok := objectID(c, "id")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	userID, ok := objectID(c, "user")
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err := user.RemoveMemberContext(c.Request().Context(), id, userID); err != nil {
+		return storeError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// requireAdmin lets only the operator and the admins of the tenant of the
+// request change data, as authz.Require does on the net/http server
+func requireAdmin(next http.Handler) http.Handler {
+	return authz.Require(authz.Admin, next)
+}
+
 func root(c echo.Context) error {
 	return c.String(http.StatusOK, "Running API v1!")
 }
 
-func auth(username, password string, c echo.Context) (bool, error) {
-	if username == "Peter" && password == "password" {
-		return true, nil
-	}
-	return false, nil
+// operator checks basic auth credentials against those of the operator
+func operator(username, password string, c echo.Context) (bool, error) {
+	return auth.CheckOperator(username, password), nil
 }
 
-func main() {
+// newServer returns the server with its middleware and routes
+func newServer() *echo.Echo {
 	e := echo.New()
 
 	e.Pre(middleware.RemoveTrailingSlash())
 
 	e.Use(middleware.Recover())
 
+	if development() {
+		e.Use(echo.WrapMiddleware(handlers.API.Validator(nil)))
+	}
+
+	e.Use(corsMiddleware(e, handlers.CORSPolicy()))
+
+	e.Use(echo.WrapMiddleware(audit.Middleware))
+
+	e.Use(echo.WrapMiddleware(tenant.Middleware))
+
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "${method}, ${uri}, ${status}, ${latency_human}\n",
 	}))
 
 	e.GET("/", root)
 
-	u := e.Group("/users")
+	spec := echo.WrapHandler(handlers.API)
+	e.GET("/openapi.json", spec)
+	e.HEAD("/openapi.json", spec)
+	docs := echo.WrapHandler(openapi.Docs("/openapi.json"))
+	e.GET("/docs", docs)
+	e.HEAD("/docs", docs)
+
+	limiter := ratelimit.New(
+		ratelimit.Limit{Rate: 10, Burst: 20},
+		ratelimit.Limit{Rate: 2, Burst: 5},
+	)
+	keys := idempotency.New(24 * time.Hour)
+
+	limit := echo.WrapMiddleware(limiter.Middleware)
+	admin := middleware.BasicAuth(operator)
+	writer := echo.WrapMiddleware(requireAdmin)
+
+	u := e.Group("/users", limit, acceptable)
 
-	u.OPTIONS("", usersOptions)
-	u.HEAD("", usersGetAll, serverCache, cacheResponse)
-	u.GET("", usersGetAll, serverCache, cacheResponse)
-	u.POST("", usersPostOne, middleware.BasicAuth(auth))
+	u.OPTIONS("", options(e))
+	userShape := shape(hal.UserFields, hal.UserRelations)
+	groupShape := shape(hal.GroupFields, hal.GroupRelations)
+	u.HEAD("", usersGetAll, userShape, serverCache, cacheResponse)
+	u.GET("", usersGetAll, userShape, serverCache, cacheResponse)
+	u.POST("", usersPostOne, writer, echo.WrapMiddleware(keys.Middleware))
+	u.OPTIONS("\\:batch", options(e))
+	u.POST("\\:batch", echo.WrapHandler(http.HandlerFunc(handlers.UsersBatch)), writer)
+
+	export := echo.WrapHandler(http.HandlerFunc(transfer.Export))
+	e.OPTIONS("/users/export", options(e))
+	e.HEAD("/users/export", export, limit)
+	e.GET("/users/export", export, limit)
+	e.OPTIONS("/users/import", options(e))
+	e.POST("/users/import", echo.WrapHandler(http.HandlerFunc(transfer.Import)), limit, writer)
+
+	e.GET("/users/events", echo.WrapHandler(http.HandlerFunc(feed.Events)), limit)
+	e.GET("/users/events/ws", echo.WrapHandler(feed.WebSocket(handlers.CORSPolicy())), limit)
 
 	uid := u.Group("/:id")
 
-	uid.OPTIONS("", userOptions)
-	uid.HEAD("", usersGetOne, serverCache, cacheResponse)
-	uid.GET("", usersGetOne, serverCache, cacheResponse)
-	uid.PUT("", usersPutOne, serverCache, cacheResponse, middleware.BasicAuth(auth))
-	uid.PATCH("", usersPatchOne, serverCache, cacheResponse, middleware.BasicAuth(auth))
-	uid.DELETE("", usersDeleteOne, middleware.BasicAuth(auth))
+	uid.OPTIONS("", options(e))
+	uid.HEAD("", usersGetOne, userShape, serverCache, cacheResponse)
+	uid.GET("", usersGetOne, userShape, serverCache, cacheResponse)
+	uid.PUT("", usersPutOne, userShape, serverCache, cacheResponse, writer)
+	uid.PATCH("", usersPatchOne, userShape, serverCache, cacheResponse, writer)
+	uid.DELETE("", usersDeleteOne, writer)
+	uid.OPTIONS("/restore", options(e))
+	uid.POST("/restore", usersRestoreOne, userShape, writer)
+	uid.OPTIONS("/revisions", options(e))
+	uid.GET("/revisions", usersRevisions)
+	uid.OPTIONS("/revisions/:rev/revert", options(e))
+	uid.POST("/revisions/:rev/revert", usersRevert, userShape, writer)
+
+	uid.OPTIONS("/groups", options(e))
+	uid.GET("/groups", usersGetGroups, groupShape)
+
+	g := e.Group("/groups", limit, acceptable)
+	g.OPTIONS("", options(e))
+	g.GET("", groupsGetAll, groupShape)
+	g.POST("", groupsPostOne, groupShape, writer)
+	g.OPTIONS("/:id", options(e))
+	g.GET("/:id", groupsGetOne, groupShape)
+	g.PUT("/:id", groupsPutOne, groupShape, writer)
+	g.DELETE("/:id", groupsDeleteOne, writer)
+	g.OPTIONS("/:id/members", options(e))
+	g.GET("/:id/members", groupsGetMembers, userShape)
+	g.POST("/:id/members", groupsPostMember, writer)
+	g.OPTIONS("/:id/members/:user", options(e))
+	g.DELETE("/:id/members/:user", groupsDeleteMember, writer)
+
+	hooks := echo.WrapHandler(http.HandlerFunc(handlers.WebhooksRouter))
+	e.OPTIONS("/webhooks", options(e))
+	e.GET("/webhooks", hooks, limit, admin)
+	e.POST("/webhooks", hooks, limit, admin)
+	e.OPTIONS("/webhooks/:id", options(e))
+	e.GET("/webhooks/:id", hooks, limit, admin)
+	e.DELETE("/webhooks/:id", hooks, limit, admin)
+	e.OPTIONS("/webhooks/:id/deliveries", options(e))
+	e.GET("/webhooks/:id/deliveries", hooks, limit, admin)
+	e.OPTIONS("/webhooks/:id/deliveries/:delivery/redeliver", options(e))
+	e.POST("/webhooks/:id/deliveries/:delivery/redeliver", hooks, limit, admin)
+
+	e.OPTIONS("/audit", options(e))
+	e.GET("/audit", echo.WrapHandler(http.HandlerFunc(handlers.AuditHandler)), limit, admin)
+
+	tenants := echo.WrapHandler(http.HandlerFunc(handlers.TenantsRouter))
+	e.OPTIONS("/tenants", options(e))
+	e.GET("/tenants", tenants, limit, admin)
+	e.POST("/tenants", tenants, limit, admin)
+	e.OPTIONS("/tenants/:id", options(e))
+	e.GET("/tenants/:id", tenants, limit, admin)
+	e.PUT("/tenants/:id", tenants, limit, admin)
+	e.DELETE("/tenants/:id", tenants, limit, admin)
+
+	e.OPTIONS("/admin/backup", options(e))
+	e.GET("/admin/backup", echo.WrapHandler(http.HandlerFunc(handlers.BackupHandler)), limit, admin)
+
+	return e
+}
+
+// development reports whether API_ENV asks for development mode, in which
+// requests and responses are checked against the API description
+func development() bool {
+	return os.Getenv("API_ENV") == "development"
+}
+
+func main() {
+	if path := os.Getenv("USER_ATTRIBUTE_SCHEMA"); path != "" {
+		schema, err := user.LoadSchema(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		user.AttributeSchema = schema
+	}
+	tenant.Domain = os.Getenv("TENANT_DOMAIN")
+	tenant.TokenSecret = []byte(os.Getenv("TENANT_TOKEN_SECRET"))
+	auth.Username, auth.Password = os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	auth.APIKeys = auth.ParseAPIKeys(os.Getenv("API_KEYS"))
+	if n, err := strconv.Atoi(os.Getenv("USER_REVISIONS")); err == nil {
+		user.MaxRevisions = n
+	}
+	err := tenant.Each(context.Background(), func(ctx context.Context) error {
+		_, _, err := migrate.Up(tenant.FromContext(ctx).Path(user.DBPath))
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	e := newServer()
+
+	go webhook.Dispatch(context.Background())
+	go user.Purger(context.Background(), trashRetention, time.Hour)
+	scheduleBackups()
 
 	e.Logger.Fatal(e.Start(":8000"))
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/christianotieno/go-rest-api/handlers"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// params matches the path parameters of echo routes and of the API
+// description, whose names differ
+var params = regexp.MustCompile(`/(:[a-z]+|\{[a-z]+\})`)
+
+// template returns path with anonymous parameters and unescaped colons
+func template(path string) string {
+	return strings.ReplaceAll(params.ReplaceAllString(path, "/{}"), `\:`, ":")
+}
+
+func TestAPIRoutes(t *testing.T) {
+	documented := map[string]bool{}
+	for _, op := range handlers.API.Operations() {
+		documented[op[0]+" "+template(op[1])] = true
+	}
+	// groups with middleware route everything else under them to the not
+	// found handler
+	notFound := runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+	routed := map[string]bool{}
+	for _, r := range newServer().Routes() {
+		if r.Method != http.MethodOptions && r.Name != notFound {
+			routed[r.Method+" "+template(r.Path)] = true
+		}
+	}
+
+	t.Log("Every route is documented")
+	for route := range routed {
+		if !documented[route] {
+			t.Errorf("Expected %s to be documented", route)
+		}
+	}
+	t.Log("Every documented operation is routed")
+	for op := range documented {
+		if !routed[op] {
+			t.Errorf("Expected %s to be routed", op)
+		}
+	}
+}
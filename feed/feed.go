@@ -0,0 +1,188 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/christianotieno/go-rest-api/cors"
+	"github.com/christianotieno/go-rest-api/user"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// pageSize is the number of logged events read at once while catching up
+	pageSize = 100
+	// heartbeat is how often an idle SSE stream sends a comment
+	heartbeat = 15 * time.Second
+)
+
+// since returns the sequence number a client resumes from, taken from the
+// Last-Event-ID header or the since query parameter
+func since(r *http.Request) (uint64, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("since")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// stream sends the logged events of the tenant carried by ctx following seq
+// and then the live events until send fails, done is closed or the
+// subscription is dropped
+func stream(ctx context.Context, seq uint64, done <-chan struct{}, send func(user.Event) error, idle func() error) error {
+	live, cancel := user.SubscribeContext(ctx)
+	defer cancel()
+
+	for {
+		evs, err := user.EventsSinceContext(ctx, seq, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, ev := range evs {
+			if err := send(ev); err != nil {
+				return err
+			}
+			seq = ev.Seq
+		}
+		if len(evs) < pageSize {
+			break
+		}
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if err := idle(); err != nil {
+				return err
+			}
+		case ev, ok := <-live:
+			if !ok {
+				return nil
+			}
+			if ev.Seq <= seq {
+				continue
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+			seq = ev.Seq
+		}
+	}
+}
+
+// Watch sends the logged events of the tenant carried by ctx following seq
+// and then the live events until send fails or ctx is done. It returns nil
+// when the subscription is dropped, after which callers resume from the
+// last sequence number sent.
+func Watch(ctx context.Context, seq uint64, send func(user.Event) error) error {
+	// the transports of callers notice dead connections, no heartbeat is needed
+	return stream(ctx, seq, ctx.Done(), send, func() error { return nil })
+}
+
+// Events streams user changes as Server-Sent Events. Clients resume after
+// a disconnection by sending the Last-Event-ID header.
+func Events(w http.ResponseWriter, r *http.Request) {
+	seq, err := since(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	send := func(ev user.Event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+		f.Flush()
+		return err
+	}
+	idle := func() error {
+		_, err := fmt.Fprint(w, ": ping\n\n")
+		f.Flush()
+		return err
+	}
+	stream(r.Context(), seq, r.Context().Done(), send, idle)
+}
+
+// Errors of the change feed
+var (
+	// Returns ErrForeignOrigin when a browser opens the WebSocket from an
+	// origin the CORS policy does not allow
+	ErrForeignOrigin = errors.New("origin not allowed")
+)
+
+// WebSocket streams user changes as JSON messages over a WebSocket. Clients
+// resume after a disconnection with the since query parameter. Browsers may
+// only open it from the API's own origin or an origin p allows, since
+// WebSockets are not subject to CORS.
+func WebSocket(p *cors.Policy) websocket.Server {
+	return websocket.Server{Handshake: handshake(p), Handler: watch}
+}
+
+// handshake accepts the clients sending no Origin, which are not browsers,
+// and the origins that are the API's own or that p allows
+func handshake(p *cors.Policy) func(*websocket.Config, *http.Request) error {
+	return func(config *websocket.Config, r *http.Request) error {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return nil
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(u.Host, r.Host) && p.Match(origin) == nil {
+			return ErrForeignOrigin
+		}
+		config.Origin = u
+		return nil
+	}
+}
+
+// watch streams the changes to a WebSocket client
+func watch(ws *websocket.Conn) {
+	defer ws.Close()
+	seq, err := since(ws.Request())
+	if err != nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		// Reads only fail once the client goes away
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+		}
+		close(done)
+	}()
+	send := func(ev user.Event) error {
+		return websocket.JSON.Send(ws, ev)
+	}
+	// The reader notices dead connections, no heartbeat is needed
+	idle := func() error {
+		return nil
+	}
+	stream(ws.Request().Context(), seq, done, send, idle)
+}
@@ -0,0 +1,123 @@
+package feed
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/christianotieno/go-rest-api/cors"
+	"github.com/christianotieno/go-rest-api/user"
+	"golang.org/x/net/websocket"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dbPath = "users.db"
+
+func TestMain(m *testing.M) {
+	os.Remove(dbPath)
+	code := m.Run()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+func save(t *testing.T, u *user.User) {
+	t.Helper()
+	if err := u.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+}
+
+// readEvent reads the next SSE event, skipping comments
+func readEvent(t *testing.T, r *bufio.Reader) (string, user.Event) {
+	t.Helper()
+	id, ev := "", user.Event{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading the stream: %s", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, ev
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatalf("Invalid event data %s: %s", line, err)
+			}
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	u := &user.User{ID: bson.NewObjectId(), Name: "John", Role: "Tester"}
+	save(t, u)
+	u.Role = "Developer"
+	save(t, u)
+
+	srv := httptest.NewServer(http.HandlerFunc(Events))
+	defer srv.Close()
+
+	t.Log("Resume after the first event")
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error connecting to the stream: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	id, ev := readEvent(t, r)
+	if id != "2" || ev.Type != user.EventUpdated || ev.Version != 2 {
+		t.Errorf("Expected the update event, got %s %+v", id, ev)
+	}
+	if ev.Before == nil || ev.Before.Role != "Tester" || ev.After == nil || ev.After.Role != "Developer" {
+		t.Errorf("Expected before and after states, got %+v", ev)
+	}
+
+	t.Log("Live events")
+	if err := user.Delete(u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	id, ev = readEvent(t, r)
+	if id != "3" || ev.Type != user.EventDeleted || ev.After != nil {
+		t.Errorf("Expected the delete event, got %s %+v", id, ev)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	srv := httptest.NewServer(WebSocket(cors.New(cors.Rule{Origins: []string{"https://app.example.com"}})))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?since=0"
+
+	for _, origin := range []string{srv.URL, "https://app.example.com"} {
+		t.Log("Origin " + origin)
+		ws, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatalf("Error connecting to the socket: %s", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		ev := user.Event{}
+		if err := websocket.JSON.Receive(ws, &ev); err != nil {
+			t.Fatalf("Error receiving an event: %s", err)
+		}
+		if ev.Seq != 1 || ev.Type != user.EventCreated {
+			t.Errorf("Expected the first logged event, got %+v", ev)
+		}
+		ws.Close()
+	}
+
+	t.Log("Other origins are refused")
+	if ws, err := websocket.Dial(url, "", "https://evil.example.com"); err == nil {
+		ws.Close()
+		t.Errorf("Expected the handshake from another origin to fail")
+	}
+}
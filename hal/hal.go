@@ -0,0 +1,193 @@
+package hal
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Reserved members of representations
+const (
+	LinksKey    = "_links"
+	EmbeddedKey = "_embedded"
+)
+
+// Query parameters shaping representations
+const (
+	FieldsParam = "fields"
+	ExpandParam = "expand"
+)
+
+// Query parameters paging collections
+const (
+	AfterParam = "after"
+	LimitParam = "limit"
+)
+
+// MaxLimit is the largest page clients may ask for
+const MaxLimit = 1000
+
+// Link is the target of a relation
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Links maps relation names, such as self, to their links
+type Links map[string]Link
+
+// Options shape representations. Fields is the sparse fieldset, or nil for
+// all fields, and Expand lists the relations to embed.
+type Options struct {
+	Fields []string
+	Expand []string
+}
+
+// Error tells which value of a query parameter is not supported
+type Error struct {
+	Param string `json:"param"`
+	Value string `json:"value"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unsupported %s value %q", e.Param, e.Value)
+}
+
+// list splits the comma separated values of a query parameter, which may be
+// repeated
+func list(query url.Values, param string) []string {
+	values := []string{}
+	for _, v := range query[param] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// contains reports whether s is one of values
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads the fields and expand parameters of query, which may only name
+// the given fields and relations
+func Parse(query url.Values, fields, relations []string) (Options, error) {
+	opts := Options{}
+	if _, ok := query[FieldsParam]; ok {
+		opts.Fields = list(query, FieldsParam)
+		for _, f := range opts.Fields {
+			if !contains(fields, f) {
+				return Options{}, &Error{Param: FieldsParam, Value: f}
+			}
+		}
+	}
+	opts.Expand = list(query, ExpandParam)
+	for _, rel := range opts.Expand {
+		if !contains(relations, rel) {
+			return Options{}, &Error{Param: ExpandParam, Value: rel}
+		}
+	}
+	return opts, nil
+}
+
+// Page selects up to Limit items of a collection, in id order, following
+// the item with the id After. A zero Limit selects the whole collection.
+type Page struct {
+	After bson.ObjectId
+	Limit int
+}
+
+// ParsePage reads the after and limit parameters of query. An after id
+// without a limit asks for pages of MaxLimit items.
+func ParsePage(query url.Values) (Page, error) {
+	p := Page{}
+	if v := query.Get(AfterParam); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			return Page{}, &Error{Param: AfterParam, Value: v}
+		}
+		p.After, p.Limit = bson.ObjectIdHex(v), MaxLimit
+	}
+	if v := query.Get(LimitParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLimit {
+			return Page{}, &Error{Param: LimitParam, Value: v}
+		}
+		p.Limit = n
+	}
+	return p, nil
+}
+
+// Paged reports whether p selects a part of the collection
+func (p Page) Paged() bool {
+	return p.Limit > 0
+}
+
+// Shaped reports whether the options change representations, which then
+// cannot be cached like the plain ones
+func (o Options) Shaped() bool {
+	return o.Fields != nil || len(o.Expand) > 0
+}
+
+// Expands reports whether the relation rel is to be embedded
+func (o Options) Expands(rel string) bool {
+	return contains(o.Expand, rel)
+}
+
+// JSONFields lists the names of the JSON fields of a struct
+func JSONFields(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// Represent returns the JSON form of v with its links, keeping only the
+// sparse fieldset of opts along with the id
+func Represent(v interface{}, links Links, opts Options) (map[string]interface{}, error) {
+	bd, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bd, &m); err != nil {
+		return nil, err
+	}
+	if opts.Fields != nil {
+		for k := range m {
+			if k != "id" && !contains(opts.Fields, k) {
+				delete(m, k)
+			}
+		}
+	}
+	m[LinksKey] = links
+	return m, nil
+}
+
+// Embed adds related representations to the representation m under rel
+func Embed(m map[string]interface{}, rel string, related interface{}) {
+	embedded, ok := m[EmbeddedKey].(map[string]interface{})
+	if !ok {
+		embedded = map[string]interface{}{}
+		m[EmbeddedKey] = embedded
+	}
+	embedded[rel] = related
+}
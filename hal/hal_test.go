@@ -0,0 +1,130 @@
+package hal
+
+import (
+	"context"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		txt    string
+		query  string
+		exp    Options
+		shaped bool
+		err    bool
+	}{
+		{"No parameters", "", Options{Expand: []string{}}, false, false},
+		{"Sparse fieldset", "fields=name,%20email", Options{Fields: []string{"name", "email"}, Expand: []string{}}, true, false},
+		{"Empty fieldset keeps only the id", "fields=", Options{Fields: []string{}, Expand: []string{}}, true, false},
+		{"Repeated expansions", "expand=groups&expand=", Options{Expand: []string{"groups"}}, true, false},
+		{"Unknown field", "fields=password", Options{}, false, true},
+		{"Unknown relation", "expand=friends", Options{}, false, true},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		query, _ := url.ParseQuery(tc.query)
+		opts, err := Parse(query, UserFields, UserRelations)
+		if (err != nil) != tc.err {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(opts, tc.exp) {
+			t.Errorf("Expected options %#v, got %#v", tc.exp, opts)
+		}
+		if opts.Shaped() != tc.shaped {
+			t.Errorf("Expected shaped %v, got %v", tc.shaped, opts.Shaped())
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	id := bson.NewObjectId()
+	testCases := []struct {
+		txt   string
+		query string
+		exp   Page
+		err   bool
+	}{
+		{"No parameters", "", Page{}, false},
+		{"Limit", "limit=10", Page{Limit: 10}, false},
+		{"Cursor and limit", "after=" + id.Hex() + "&limit=10", Page{After: id, Limit: 10}, false},
+		{"Cursor without limit", "after=" + id.Hex(), Page{After: id, Limit: MaxLimit}, false},
+		{"Empty page", "limit=0", Page{}, true},
+		{"Too large a page", "limit=1001", Page{}, true},
+		{"Invalid cursor", "after=1", Page{}, true},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		query, _ := url.ParseQuery(tc.query)
+		p, err := ParsePage(query)
+		if (err != nil) != tc.err || p != tc.exp {
+			t.Errorf("Expected page %+v and error %v, got %+v and %v", tc.exp, tc.err, p, err)
+		}
+	}
+}
+
+func TestRepresent(t *testing.T) {
+	u := &user.User{ID: bson.NewObjectId(), Name: "Ann", Email: "ann@example.com", Status: user.StatusActive}
+	m, err := Represent(u, UserLinks(u.ID), Options{Fields: []string{"email"}})
+	if err != nil {
+		t.Fatalf("Error representing a user: %s", err)
+	}
+	exp := map[string]interface{}{
+		"id":     u.ID.Hex(),
+		"email":  "ann@example.com",
+		LinksKey: UserLinks(u.ID),
+	}
+	if !reflect.DeepEqual(m, exp) {
+		t.Errorf("Expected %v, got %v", exp, m)
+	}
+	if href := UserLinks(u.ID)[RelGroups].Href; href != "/users/"+u.ID.Hex()+"/groups" {
+		t.Errorf("Expected groups link of the user, got %s", href)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	os.Remove(user.DBPath)
+	defer os.Remove(user.DBPath)
+	u := &user.User{ID: bson.NewObjectId(), Name: "Ann"}
+	if err := u.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	g := &user.Group{ID: bson.NewObjectId(), Name: "Admins"}
+	if err := g.Save(); err != nil {
+		t.Fatalf("Error saving a group: %s", err)
+	}
+	if err := user.AddMember(g.ID, u.ID); err != nil {
+		t.Fatalf("Error adding a member: %s", err)
+	}
+
+	t.Log("Users embed their groups")
+	m, err := User(context.Background(), u, Options{Expand: []string{RelGroups}})
+	if err != nil {
+		t.Fatalf("Error representing a user: %s", err)
+	}
+	groups := m[EmbeddedKey].(map[string]interface{})[RelGroups].([]map[string]interface{})
+	if len(groups) != 1 || groups[0]["name"] != "Admins" || groups[0][LinksKey] == nil {
+		t.Errorf("Expected the embedded group with its links, got %v", groups)
+	}
+
+	t.Log("Groups embed their members")
+	m, err = Group(context.Background(), g, Options{Expand: []string{RelMembers}})
+	if err != nil {
+		t.Fatalf("Error representing a group: %s", err)
+	}
+	members := m[EmbeddedKey].(map[string]interface{})[RelMembers].([]map[string]interface{})
+	if len(members) != 1 || members[0]["id"] != u.ID.Hex() {
+		t.Errorf("Expected the embedded member, got %v", members)
+	}
+
+	t.Log("Nothing is embedded unless expanded")
+	m, _ = User(context.Background(), u, Options{})
+	if _, ok := m[EmbeddedKey]; ok {
+		t.Errorf("Expected no embedded resources, got %v", m[EmbeddedKey])
+	}
+}
@@ -0,0 +1,155 @@
+package hal
+
+import (
+	"context"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"strconv"
+)
+
+// Relations of users and groups
+const (
+	RelSelf       = "self"
+	RelCollection = "collection"
+	RelGroups     = "groups"
+	RelMembers    = "members"
+	RelRevisions  = "revisions"
+	RelNext       = "next"
+)
+
+// Fields and expandable relations of users and groups
+var (
+	UserFields     = JSONFields(user.User{})
+	UserRelations  = []string{RelGroups}
+	GroupFields    = JSONFields(user.Group{})
+	GroupRelations = []string{RelMembers}
+)
+
+// CollectionLinks are the links of the collection at path
+func CollectionLinks(path string) Links {
+	return Links{RelSelf: {Href: path}}
+}
+
+// PageLinks are the links of the page p of users of the collection at path.
+// A full page links to the next one, with the other parameters of query.
+func PageLinks(path string, query url.Values, p Page, users []user.User) Links {
+	links := CollectionLinks(path)
+	if !p.Paged() || len(users) < p.Limit {
+		return links
+	}
+	next := url.Values{}
+	for k, v := range query {
+		next[k] = v
+	}
+	next.Set(AfterParam, users[len(users)-1].ID.Hex())
+	next.Set(LimitParam, strconv.Itoa(p.Limit))
+	links[RelNext] = Link{Href: path + "?" + next.Encode()}
+	return links
+}
+
+// UserLinks are the links of the user with the given id
+func UserLinks(id bson.ObjectId) Links {
+	self := "/users/" + id.Hex()
+	return Links{
+		RelSelf:       {Href: self},
+		RelCollection: {Href: "/users"},
+		RelGroups:     {Href: self + "/groups"},
+		RelRevisions:  {Href: self + "/revisions"},
+	}
+}
+
+// GroupLinks are the links of the group with the given id
+func GroupLinks(id bson.ObjectId) Links {
+	self := "/groups/" + id.Hex()
+	return Links{
+		RelSelf:       {Href: self},
+		RelCollection: {Href: "/groups"},
+		RelMembers:    {Href: self + "/members"},
+	}
+}
+
+// User represents u in the tenant carried by ctx, embedding its groups when
+// opts expands them
+func User(ctx context.Context, u *user.User, opts Options) (map[string]interface{}, error) {
+	ms, err := Users(ctx, []user.User{*u}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// Users represents each of users like User. The groups of all of them are
+// read at once.
+func Users(ctx context.Context, users []user.User, opts Options) ([]map[string]interface{}, error) {
+	var groups map[bson.ObjectId][]user.Group
+	if opts.Expands(RelGroups) {
+		ids := make([]bson.ObjectId, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+		}
+		var err error
+		// deleted users belong to no group
+		if groups, err = user.GroupsOfEachContext(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]map[string]interface{}, len(users))
+	for i := range users {
+		m, err := Represent(&users[i], UserLinks(users[i].ID), opts)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			related, err := Groups(ctx, groups[users[i].ID], Options{})
+			if err != nil {
+				return nil, err
+			}
+			Embed(m, RelGroups, related)
+		}
+		out[i] = m
+	}
+	return out, nil
+}
+
+// Group represents g in the tenant carried by ctx, embedding its members
+// when opts expands them
+func Group(ctx context.Context, g *user.Group, opts Options) (map[string]interface{}, error) {
+	ms, err := Groups(ctx, []user.Group{*g}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ms[0], nil
+}
+
+// Groups represents each of groups like Group. The members of all of them
+// are read at once.
+func Groups(ctx context.Context, groups []user.Group, opts Options) ([]map[string]interface{}, error) {
+	var members map[bson.ObjectId][]user.User
+	if opts.Expands(RelMembers) {
+		ids := make([]bson.ObjectId, len(groups))
+		for i := range groups {
+			ids[i] = groups[i].ID
+		}
+		var err error
+		if members, err = user.MembersOfEachContext(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]map[string]interface{}, len(groups))
+	for i := range groups {
+		m, err := Represent(&groups[i], GroupLinks(groups[i].ID), opts)
+		if err != nil {
+			return nil, err
+		}
+		if members != nil {
+			related, err := Users(ctx, members[groups[i].ID], Options{})
+			if err != nil {
+				return nil, err
+			}
+			Embed(m, RelMembers, related)
+		}
+		out[i] = m
+	}
+	return out, nil
+}
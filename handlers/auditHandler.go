@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/audit"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Page sizes of the audit log
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+// AuditHandler lists the audit log, oldest entries first:
+//
+//	GET /audit?resource=users/{id}&after={entry id}&limit={n}
+//
+// The response links to the next page while more entries may follow.
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodOptions:
+		postOptionsResponse(w, r, []string{http.MethodGet, http.MethodOptions}, nil)
+		return
+	default:
+		postError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	var after uint64
+	if v := query.Get("after"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			postError(w, http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+	limit := auditDefaultLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > auditMaxLimit {
+			postError(w, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := audit.FindContext(r.Context(), query.Get("resource"), after, limit)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	content := jsonResponse{"entries": entries}
+	if len(entries) == limit {
+		next := url.Values{}
+		if res := query.Get("resource"); res != "" {
+			next.Set("resource", res)
+		}
+		next.Set("after", strconv.FormatUint(entries[len(entries)-1].ID, 10))
+		next.Set("limit", strconv.Itoa(limit))
+		content["next"] = "/audit?" + next.Encode()
+	}
+	postBodyResponse(w, r, http.StatusOK, content)
+}
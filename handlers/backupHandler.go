@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"github.com/christianotieno/go-rest-api/backup"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"net/http"
+	"path/filepath"
+)
+
+// BackupHandler serves a snapshot of the users database of the tenant named
+// by the tenant query parameter, or of the default tenant without one
+func BackupHandler(w http.ResponseWriter, r *http.Request) {
+	t := tenant.Default
+	if id := r.URL.Query().Get("tenant"); id != "" {
+		found, err := tenant.One(id)
+		if err == tenant.ErrUnknownTenant {
+			postError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			postError(w, http.StatusInternalServerError)
+			return
+		}
+		t = *found
+	}
+	backup.Handler(t.Path(user.DBPath))(w, r)
+}
+
+// BackupTargets lists the users database of every tenant, each with its own
+// directory of snapshots in dir. The default tenant keeps its snapshots in
+// dir itself.
+func BackupTargets(dir string) func() ([]backup.Target, error) {
+	return func() ([]backup.Target, error) {
+		targets := []backup.Target{}
+		err := tenant.Each(context.Background(), func(ctx context.Context) error {
+			t := tenant.FromContext(ctx)
+			target := backup.Target{Path: t.Path(user.DBPath), Dir: dir}
+			if t.ID != "" {
+				target.Dir = filepath.Join(dir, tenant.Dir, t.ID)
+			}
+			targets = append(targets, target)
+			return nil
+		})
+		return targets, err
+	}
+}
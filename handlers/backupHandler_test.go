@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBackupHandler(t *testing.T) {
+	dir, dbPath := tenant.Dir, tenant.DBPath
+	tenant.Dir = t.TempDir()
+	tenant.DBPath = filepath.Join(tenant.Dir, "tenants.db")
+	defer func() { tenant.Dir, tenant.DBPath = dir, dbPath }()
+	acme := &tenant.Tenant{ID: "acme"}
+	if err := acme.Create(); err != nil {
+		t.Fatalf("Error creating a tenant: %s", err)
+	}
+
+	testCases := []struct {
+		txt  string
+		path string
+		code int
+	}{
+		{"The default tenant", "/admin/backup", http.StatusOK},
+		{"A tenant", "/admin/backup?tenant=acme", http.StatusOK},
+		{"An unknown tenant", "/admin/backup?tenant=globex", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := httptest.NewRecorder()
+		BackupHandler(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("Expected status %d, got %d", tc.code, w.Code)
+		}
+	}
+
+	t.Log("Scheduled backups cover every tenant")
+	targets, err := BackupTargets("backups")()
+	if err != nil {
+		t.Fatalf("Error listing the backup targets: %s", err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %+v", targets)
+	}
+	paths := []string{targets[0].Path, targets[0].Dir, targets[1].Path, targets[1].Dir}
+	exp := []string{user.DBPath, "backups", acme.Path(user.DBPath), filepath.Join("backups", tenant.Dir, "acme")}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Errorf("Expected targets %v, got %+v", exp, targets)
+	}
+}
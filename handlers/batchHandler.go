@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+)
+
+// batchRequest is the body of POST /users:batch. A plain JSON array of
+// operations is accepted too, with the mode taken from the query string.
+type batchRequest struct {
+	Mode       string    `json:"mode"`
+	Operations []user.Op `json:"operations"`
+}
+
+// BatchResult is the outcome of one operation of a batch
+type BatchResult struct {
+	Op     string     `json:"op"`
+	ID     string     `json:"id,omitempty"`
+	Status int        `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	User   *user.User `json:"user,omitempty"`
+}
+
+const (
+	batchAtomic  = "atomic"
+	batchPartial = "partial"
+)
+
+// MaxBatchOps is the largest number of operations a batch may hold
+const MaxBatchOps = 1000
+
+// batchDecoding are the rules for batch request bodies
+var batchDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     16 << 20,
+	Codecs:       codec.Default,
+}
+
+// opDecoding are the rules for the users of batch operations, which may not
+// set the fields the server maintains either
+var opDecoding = decode.Options{ReadOnly: user.ReadOnly}
+
+func bodyToBatch(r *http.Request, b *batchRequest) error {
+	bd, err := decode.Body(r, batchDecoding)
+	if err != nil {
+		return err
+	}
+	b.Mode = r.URL.Query().Get("mode")
+	var raw struct {
+		Operations []struct {
+			User json.RawMessage `json:"user"`
+		} `json:"operations"`
+	}
+	if len(bytes.TrimSpace(bd)) > 0 && bytes.TrimSpace(bd)[0] == '[' {
+		err = decode.Unmarshal(bd, &b.Operations, batchDecoding)
+		json.Unmarshal(bd, &raw.Operations)
+	} else {
+		err = decode.Unmarshal(bd, b, batchDecoding)
+		json.Unmarshal(bd, &raw)
+	}
+	if err != nil {
+		return err
+	}
+	for i, op := range raw.Operations {
+		if len(op.User) == 0 || string(op.User) == "null" {
+			continue
+		}
+		if err := decode.Unmarshal(op.User, new(user.User), opDecoding); err != nil {
+			var e *decode.Error
+			if errors.As(err, &e) {
+				e.Field = "operations[" + strconv.Itoa(i) + "].user." + e.Field
+				e.Line, e.Column = 0, 0
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrorStatus maps storage errors to HTTP status codes
+func ErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, user.ErrRecordInvalid), err == user.ErrUnknownOp:
+		return http.StatusBadRequest
+	case err == storm.ErrNotFound:
+		return http.StatusNotFound
+	case err == user.ErrDeleted, err == user.ErrNotDeleted, errors.Is(err, user.ErrConflict):
+		return http.StatusConflict
+	case err == user.ErrQuota:
+		return http.StatusForbidden
+	case err == user.ErrBatchAborted:
+		return http.StatusFailedDependency
+	}
+	return http.StatusInternalServerError
+}
+
+// UsersBatch applies the operations of POST /users:batch. Both servers serve
+// it. When an atomic batch fails, every other operation is reported as
+// aborted, since none of them was applied.
+func UsersBatch(w http.ResponseWriter, r *http.Request) {
+	b := new(batchRequest)
+	if err := bodyToBatch(r, b); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	if b.Mode == "" {
+		b.Mode = batchAtomic
+	}
+	if b.Mode != batchAtomic && b.Mode != batchPartial {
+		postError(w, http.StatusBadRequest)
+		return
+	}
+	if len(b.Operations) > MaxBatchOps {
+		postBodyResponse(w, r, http.StatusRequestEntityTooLarge, jsonResponse{"error": jsonResponse{
+			"message": "a batch holds at most " + strconv.Itoa(MaxBatchOps) + " operations",
+		}})
+		return
+	}
+
+	results, err := user.BatchContext(r.Context(), b.Operations, b.Mode == batchAtomic)
+	if results == nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+
+	dropped := []bson.ObjectId{}
+	out := make([]BatchResult, len(results))
+	for i, res := range results {
+		out[i] = BatchResult{
+			Op:     res.Op,
+			Status: ErrorStatus(res.Err),
+			User:   res.User,
+		}
+		if res.ID.Valid() {
+			out[i].ID = res.ID.Hex()
+			dropped = append(dropped, res.ID)
+		}
+		if res.Err != nil {
+			out[i].Error = res.Err.Error()
+			out[i].User = nil
+		} else if res.Op == user.OpCreate {
+			out[i].Status = http.StatusCreated
+		}
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		code = ErrorStatus(err)
+	} else {
+		cache.DropUsers(r.Context(), dropped...)
+		if b.Mode == batchPartial {
+			code = http.StatusMultiStatus
+		}
+	}
+	postBodyResponse(w, r, code, jsonResponse{"results": out})
+}
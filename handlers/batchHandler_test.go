@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsersBatch(t *testing.T) {
+	ops := strings.Repeat(`{"op":"delete","id":"5f1f6a6e2b3c4d5e6f708192"},`, MaxBatchOps+1)
+	testCases := []struct {
+		txt   string
+		body  string
+		code  int
+		field string
+	}{
+		{"Unknown mode", `{"mode":"eventual","operations":[]}`, http.StatusBadRequest, ""},
+		{"Too many operations", `[` + strings.TrimSuffix(ops, ",") + `]`, http.StatusRequestEntityTooLarge, ""},
+		{"Read-only fields of created users", `{"operations":[{"op":"delete","id":"5f1f6a6e2b3c4d5e6f708192"},{"op":"create","user":{"name":"Ann","created_at":"2020-01-01T00:00:00Z"}}]}`, http.StatusUnprocessableEntity, "operations[1].user.created_at"},
+		{"Read-only fields of updated users", `[{"op":"update","id":"5f1f6a6e2b3c4d5e6f708192","user":{"id":"5f1f6a6e2b3c4d5e6f708193","name":"Ann"}}]`, http.StatusUnprocessableEntity, "operations[0].user.id"},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		r := httptest.NewRequest(http.MethodPost, "/users:batch", bytes.NewBufferString(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		UsersBatch(w, r)
+		if w.Code != tc.code {
+			t.Errorf("Expected status %d, got %d", tc.code, w.Code)
+		}
+		if tc.field != "" && !strings.Contains(w.Body.String(), `"field":"`+tc.field+`"`) {
+			t.Errorf("Expected field %s to be named, got %s", tc.field, w.Body)
+		}
+	}
+}
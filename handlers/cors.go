@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/audit"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/cors"
+	"github.com/christianotieno/go-rest-api/idempotency"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"os"
+	"strings"
+	"time"
+)
+
+// CORSPolicy allows the comma separated origins of the CORS_ORIGINS variable.
+// Both servers use it, so that they answer browsers the same way.
+func CORSPolicy() *cors.Policy {
+	origins := os.Getenv("CORS_ORIGINS")
+	if origins == "" {
+		return cors.New()
+	}
+	return cors.New(cors.Rule{
+		Origins:          strings.Split(origins, ","),
+		Headers:          []string{"Authorization", "Content-Type", "Cache-Control", auth.APIKeyHeader, idempotency.Header, audit.RequestIDHeader, tenant.Header},
+		ExposedHeaders:   []string{"Location", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", idempotency.ReplayedHeader, audit.RequestIDHeader},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/hal"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+)
+
+// groupDecoding are the rules for group request bodies
+var groupDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     user.GroupReadOnly,
+	Codecs:       codec.Default,
+}
+
+// member is the body of requests adding a member to a group
+type member struct {
+	UserID bson.ObjectId `json:"user_id"`
+}
+
+// groupOptions returns how the fields and expand parameters of the request
+// shape group representations
+func groupOptions(r *http.Request) (hal.Options, error) {
+	return hal.Parse(r.URL.Query(), hal.GroupFields, hal.GroupRelations)
+}
+
+// postGroup writes the representation of g with its links, shaped by opts
+func postGroup(w http.ResponseWriter, r *http.Request, code int, g *user.Group, opts hal.Options) {
+	m, err := hal.Group(r.Context(), g, opts)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, code, jsonResponse{"group": m})
+}
+
+// postGroups writes the representations of groups with their links, shaped
+// by opts, along with the links of the collection at self
+func postGroups(w http.ResponseWriter, r *http.Request, groups []user.Group, self string, opts hal.Options) {
+	ms, err := hal.Groups(r.Context(), groups, opts)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"groups": ms, hal.LinksKey: hal.CollectionLinks(self)})
+}
+
+func groupsGetAll(w http.ResponseWriter, r *http.Request) {
+	opts, err := groupOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	groups, err := user.GroupsContext(r.Context())
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postGroups(w, r, groups, "/groups", opts)
+}
+
+func groupsPostOne(w http.ResponseWriter, r *http.Request) {
+	opts, err := groupOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	g := new(user.Group)
+	if err := decode.JSON(r, g, groupDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	g.ID = bson.NewObjectId()
+	if err := g.SaveContext(r.Context()); err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/groups/"+g.ID.Hex())
+	postGroup(w, r, http.StatusCreated, g, opts)
+}
+
+func groupsGetOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := groupOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	g, err := user.OneGroupContext(r.Context(), id)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	postGroup(w, r, http.StatusOK, g, opts)
+}
+
+func groupsPutOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := groupOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	g := new(user.Group)
+	if err := decode.JSON(r, g, groupDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	g.ID = id
+	if err := g.SaveContext(r.Context()); err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	postGroup(w, r, http.StatusOK, g, opts)
+}
+
+func groupsDeleteOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	if err := user.DeleteGroupContext(r.Context(), id); err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func groupsGetMembers(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	users, err := user.MembersContext(r.Context(), id)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	postUsers(w, r, http.StatusOK, users, hal.CollectionLinks("/groups/"+id.Hex()+"/members"), opts)
+}
+
+func groupsPostMember(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	m := new(member)
+	if err := decode.JSON(r, m, groupDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	if !m.UserID.Valid() {
+		postStoreError(w, r, &user.FieldError{Field: "user_id", Message: "is required"})
+		return
+	}
+	if err := user.AddMemberContext(r.Context(), id, m.UserID); err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/groups/"+id.Hex()+"/members/"+m.UserID.Hex())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func groupsDeleteMember(w http.ResponseWriter, r *http.Request, id, userID bson.ObjectId) {
+	if err := user.RemoveMemberContext(r.Context(), id, userID); err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func usersGetGroups(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := groupOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	groups, err := user.GroupsOfContext(r.Context(), id)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	postGroups(w, r, groups, "/users/"+id.Hex()+"/groups", opts)
+}
+
+// groupsMethods are the handlers of the /groups collection by method
+var groupsMethods = map[string]usersHandler{
+	http.MethodGet:  groupsGetAll,
+	http.MethodPost: groupsPostOne,
+}
+
+// groupMethods are the handlers of a single /groups/{id} resource by method
+var groupMethods = map[string]userHandler{
+	http.MethodGet:    groupsGetOne,
+	http.MethodPut:    groupsPutOne,
+	http.MethodDelete: groupsDeleteOne,
+}
+
+// membersMethods are the handlers of the /groups/{id}/members collection by
+// method
+var membersMethods = map[string]userHandler{
+	http.MethodGet:  groupsGetMembers,
+	http.MethodPost: groupsPostMember,
+}
+
+// memberMethods returns the handlers of the /groups/{id}/members/{user}
+// resource by method
+func memberMethods(userID bson.ObjectId) map[string]userHandler {
+	return map[string]userHandler{
+		http.MethodDelete: func(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+			groupsDeleteMember(w, r, id, userID)
+		},
+	}
+}
+
+// groupRoute returns the handlers by method of the route under
+// /groups/{id} matching path along with the id, or nil if no route matches
+func groupRoute(path string) (map[string]userHandler, bson.ObjectId) {
+	parts := strings.Split(strings.TrimPrefix(path, "/groups/"), "/")
+	if !bson.IsObjectIdHex(parts[0]) {
+		return nil, ""
+	}
+	id := bson.ObjectIdHex(parts[0])
+	switch {
+	case len(parts) == 1:
+		return groupMethods, id
+	case len(parts) == 2 && parts[1] == "members":
+		return membersMethods, id
+	case len(parts) == 3 && parts[1] == "members" && bson.IsObjectIdHex(parts[2]):
+		return memberMethods(bson.ObjectIdHex(parts[2])), id
+	}
+	return nil, ""
+}
+
+// GroupsAllowedMethods returns the methods served by the groups route of the
+// request, or nil if no route matches
+func GroupsAllowedMethods(r *http.Request) []string {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "/groups" {
+		return allowed(func(m string) bool { _, ok := groupsMethods[m]; return ok })
+	}
+	if methods, _ := groupRoute(path); methods != nil {
+		return allowed(func(m string) bool { _, ok := methods[m]; return ok })
+	}
+	return nil
+}
+
+// GroupsRouter handles requests for the groups route:
+//
+//	GET, POST        /groups
+//	GET, PUT, DELETE /groups/{id}
+//	GET, POST        /groups/{id}/members
+//	DELETE           /groups/{id}/members/{user}
+func GroupsRouter(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := codec.Default.Negotiate(r.Header.Get("Accept")); !ok {
+		postError(w, http.StatusNotAcceptable)
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "/groups" {
+		if r.Method == http.MethodOptions {
+			postOptionsResponse(w, r, GroupsAllowedMethods(r), nil)
+			return
+		}
+		h, ok := groupsMethods[r.Method]
+		if !ok {
+			postError(w, http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+		return
+	}
+	methods, id := groupRoute(path)
+	if methods == nil {
+		postError(w, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		postOptionsResponse(w, r, GroupsAllowedMethods(r), nil)
+		return
+	}
+	h, ok := methods[r.Method]
+	if !ok {
+		postError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	h(w, r, id)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupsAllowedMethods(t *testing.T) {
+	ts := []struct {
+		path string
+		exp  []string
+	}{
+		{"/groups", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/groups/", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/groups/5f1a5b3e8f1b2c3d4e5f6a7b", []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodOptions}},
+		{"/groups/5f1a5b3e8f1b2c3d4e5f6a7b/members", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/groups/5f1a5b3e8f1b2c3d4e5f6a7b/members/5f1a5b3e8f1b2c3d4e5f6a7c", []string{http.MethodDelete, http.MethodOptions}},
+		{"/groups/5f1a5b3e8f1b2c3d4e5f6a7b/members/unknown", nil},
+		{"/groups/unknown", nil},
+	}
+
+	for _, tc := range ts {
+		got := GroupsAllowedMethods(httptest.NewRequest(http.MethodOptions, tc.path, nil))
+		if !reflect.DeepEqual(tc.exp, got) {
+			t.Errorf("Expected methods %v for %s, got %v", tc.exp, tc.path, got)
+		}
+	}
+}
+
+func TestGroupsRouter(t *testing.T) {
+	dir := tenant.Dir
+	tenant.Dir = t.TempDir()
+	defer func() { tenant.Dir = dir }()
+	acme := tenant.Tenant{ID: "acme"}
+	os.MkdirAll(acme.Path(""), 0o755)
+	ctx := tenant.NewContext(context.Background(), acme)
+	u := &user.User{ID: bson.NewObjectId(), Name: "Ann"}
+	if err := u.SaveContext(ctx); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+
+	serve := func(h http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	w := serve(GroupsRouter, http.MethodPost, "/groups", `{"name": "Admins", "role": "admin"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	group := w.Header().Get("Location")
+
+	testCases := []struct {
+		txt    string
+		h      http.HandlerFunc
+		method string
+		path   string
+		body   string
+		status int
+		has    string
+	}{
+		{"Group names are unique", GroupsRouter, http.MethodPost, "/groups", `{"name": "Admins"}`, http.StatusConflict, "name"},
+		{"Members need a user", GroupsRouter, http.MethodPost, group + "/members", `{}`, http.StatusBadRequest, "user_id"},
+		{"Members must exist", GroupsRouter, http.MethodPost, group + "/members", `{"user_id": "` + bson.NewObjectId().Hex() + `"}`, http.StatusNotFound, ""},
+		{"Adding a member", GroupsRouter, http.MethodPost, group + "/members", `{"user_id": "` + u.ID.Hex() + `"}`, http.StatusCreated, ""},
+		{"Listing members", GroupsRouter, http.MethodGet, group + "/members", "", http.StatusOK, u.ID.Hex()},
+		{"Groups link to their members", GroupsRouter, http.MethodGet, group, "", http.StatusOK, group + "/members"},
+		{"Expanding the members of a group", GroupsRouter, http.MethodGet, group + "?expand=members&fields=name", "", http.StatusOK, `"_embedded":{"members":[{`},
+		{"Unknown fields are rejected", GroupsRouter, http.MethodGet, group + "?fields=secret", "", http.StatusBadRequest, "secret"},
+		{"Listing the groups of a user", UsersRouter, http.MethodGet, "/users/" + u.ID.Hex() + "/groups", "", http.StatusOK, "Admins"},
+		{"Removing a member", GroupsRouter, http.MethodDelete, group + "/members/" + u.ID.Hex(), "", http.StatusOK, ""},
+		{"Removing a member twice", GroupsRouter, http.MethodDelete, group + "/members/" + u.ID.Hex(), "", http.StatusNotFound, ""},
+		{"Deleting a group", GroupsRouter, http.MethodDelete, group, "", http.StatusOK, ""},
+		{"Deleted groups are not found", GroupsRouter, http.MethodGet, group, "", http.StatusNotFound, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := serve(tc.h, tc.method, tc.path, tc.body)
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tc.has) {
+			t.Errorf("Expected body containing %q, got %s", tc.has, w.Body.String())
+		}
+	}
+}
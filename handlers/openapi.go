@@ -0,0 +1,497 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/audit"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/authz"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/hal"
+	"github.com/christianotieno/go-rest-api/openapi"
+	"github.com/christianotieno/go-rest-api/patch"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/transfer"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/webhook"
+	"net/http"
+	"strings"
+)
+
+// API is the OpenAPI description of the routes served by the handlers. The
+// echo server serves the same API.
+var API = describe()
+
+// represented returns the content of bodies holding s in any of the codec
+// representations
+func represented(s *openapi.Schema) map[string]openapi.MediaType {
+	content := map[string]openapi.MediaType{}
+	for _, mt := range codec.Default.Types() {
+		content[mt] = openapi.MediaType{Schema: s}
+	}
+	return content
+}
+
+// body returns a required request body holding s in any representation
+func body(s *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: represented(s)}
+}
+
+// ok returns a response described by desc holding s in any representation,
+// or no body if s is nil
+func ok(desc string, s *openapi.Schema) *openapi.Response {
+	if s == nil {
+		return &openapi.Response{Description: desc}
+	}
+	return &openapi.Response{Description: desc, Content: represented(s)}
+}
+
+// wrapped is the schema of objects holding s under key, the way responses
+// name their content
+func wrapped(key string, s *openapi.Schema) *openapi.Schema {
+	return openapi.ObjectOf(map[string]*openapi.Schema{key: s}, key)
+}
+
+// collection is the schema of collections holding the items under key along
+// with the links of the collection
+func collection(key string, items *openapi.Schema) *openapi.Schema {
+	return openapi.ObjectOf(map[string]*openapi.Schema{
+		key:          openapi.ArrayOf(items),
+		hal.LinksKey: openapi.Ref("Links"),
+	}, key, hal.LinksKey)
+}
+
+// input is the schema of request bodies of the component record, without
+// its read-only fields
+func input(d *openapi.Document, record *openapi.Schema, readOnly []string, required ...string) *openapi.Schema {
+	s := d.Resolve(record)
+	properties := map[string]*openapi.Schema{}
+	for name, p := range s.Properties {
+		if !contains(readOnly, name) {
+			properties[name] = p
+		}
+	}
+	return openapi.ObjectOf(properties, required...)
+}
+
+// representation is the schema of the HAL representations of the component
+// record. Sparse fieldsets may leave out any field but the id, and the
+// expanded relations are embedded.
+func representation(d *openapi.Document, record *openapi.Schema, embedded map[string]*openapi.Schema) *openapi.Schema {
+	s := d.Resolve(record)
+	properties := map[string]*openapi.Schema{}
+	for name, p := range s.Properties {
+		properties[name] = p
+	}
+	properties[hal.LinksKey] = openapi.Ref("Links")
+	properties[hal.EmbeddedKey] = openapi.ObjectOf(embedded)
+	return openapi.ObjectOf(properties, "id", hal.LinksKey)
+}
+
+// pathID is the path parameter name holding an object id
+func pathID(name string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InPath, Required: true, Schema: openapi.ObjectID}
+}
+
+// query is the optional query parameter name
+func query(name, desc string, s *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: openapi.InQuery, Description: desc, Schema: s}
+}
+
+// shaping are the parameters shaping representations with the given fields
+// and relations
+func shaping(fields, relations []string) []openapi.Parameter {
+	return []openapi.Parameter{
+		query(hal.FieldsParam, "Comma separated fields to include: "+strings.Join(fields, ", "), openapi.Of(openapi.String)),
+		query(hal.ExpandParam, "Comma separated relations to embed: "+strings.Join(relations, ", "), openapi.Of(openapi.String)),
+	}
+}
+
+// atLeast is the schema of integers of at least min
+func atLeast(min float64) *openapi.Schema {
+	return &openapi.Schema{Type: openapi.Integer, Minimum: &min}
+}
+
+// describe builds the API description from the routes and the types they
+// exchange
+func describe() *openapi.Document {
+	d := openapi.New("Users API", "1.0.0")
+	d.Info.Description = "Users, groups, webhooks and tenants. Requests reach a tenant with a bearer token " +
+		"claiming it; the " + tenant.Header + " header and tenant subdomains must name the same tenant."
+
+	d.Component("Links", openapi.MapOf(openapi.ObjectOf(map[string]*openapi.Schema{"href": openapi.Of(openapi.String)}, "href")))
+	d.Component("Error", wrapped("error", openapi.OneOf(openapi.Of(openapi.String), openapi.Of(openapi.Object))))
+	userRecord := d.Schema("User", user.User{})
+	groupRecord := d.Schema("Group", user.Group{})
+	revision := d.Schema("Revision", user.Revision{})
+	op := d.Schema("Op", user.Op{})
+	batch := d.Schema("BatchRequest", batchRequest{})
+	result := d.Schema("BatchResult", BatchResult{})
+	progress := d.Schema("ImportProgress", transfer.Progress{})
+	subscription := d.Schema("Subscription", webhook.Subscription{})
+	delivery := d.Schema("Delivery", webhook.Delivery{})
+	entry := d.Schema("AuditEntry", audit.Entry{})
+	tenantRecord := d.Schema("Tenant", tenant.Tenant{})
+
+	userRep := d.Component("UserRepresentation", representation(d, userRecord, map[string]*openapi.Schema{
+		hal.RelGroups: openapi.ArrayOf(openapi.Ref("GroupRepresentation")),
+	}))
+	groupRep := d.Component("GroupRepresentation", representation(d, groupRecord, map[string]*openapi.Schema{
+		hal.RelMembers: openapi.ArrayOf(userRep),
+	}))
+	userInput := d.Component("UserInput", input(d, userRecord, user.ReadOnly, "name"))
+	groupInput := d.Component("GroupInput", input(d, groupRecord, user.GroupReadOnly, "name"))
+	subscriptionInput := d.Component("SubscriptionInput", input(d, subscription, webhookDecoding.ReadOnly, "url", "events"))
+	tenantInput := d.Component("TenantInput", input(d, tenantRecord, tenantDecoding.ReadOnly))
+	d.Resolve(op).Properties["user"] = userInput
+	d.Resolve(subscription).Properties["events"].Items.Enum = []interface{}{
+		webhook.UserCreated, webhook.UserUpdated, webhook.UserDeleted, webhook.UserRestored,
+	}
+	d.Resolve(delivery).Properties["status"].Enum = []interface{}{
+		webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead,
+	}
+
+	userShaping := shaping(hal.UserFields, hal.UserRelations)
+	groupShaping := shaping(hal.GroupFields, hal.GroupRelations)
+	include := query("include", "Include users in the trash", openapi.Of(openapi.String, "deleted"))
+	oneUser := ok("The user", wrapped("user", userRep))
+	oneGroup := ok("The group", wrapped("group", groupRep))
+	users := ok("The users", collection("users", userRep))
+	groups := ok("The groups", collection("groups", groupRep))
+	done := ok("Done", nil)
+
+	// users
+	filters := []openapi.Parameter{include}
+	for _, idx := range user.Indexes {
+		filters = append(filters, query(idx.Name, "Only users with this "+idx.Name, openapi.Of(openapi.String)))
+	}
+	paging := []openapi.Parameter{
+		query(hal.LimitParam, "Users per page, linking to the next page when full. Filtered lists and lists with the trash are not paged.",
+			openapi.Between(openapi.Of(openapi.Integer), 1, hal.MaxLimit)),
+		query(hal.AfterParam, "Id of the last user of the previous page", openapi.ObjectID),
+	}
+	listUsers := append(append(filters, paging...), userShaping...)
+	d.Add(http.MethodGet, "/users", &openapi.Operation{
+		Summary: "List users, filtered by at most one indexed field", Tags: []string{"users"},
+		Parameters: listUsers,
+		Responses:  map[string]*openapi.Response{"200": users},
+	})
+	d.Add(http.MethodHead, "/users", &openapi.Operation{
+		Summary: "Check the users", Tags: []string{"users"},
+		Parameters: listUsers,
+		Responses:  map[string]*openapi.Response{"200": ok("The users exist", nil)},
+	})
+	d.Add(http.MethodPost, "/users", &openapi.Operation{
+		Summary: "Create a user", Tags: []string{"users"},
+		RequestBody: body(userInput),
+		Responses:   map[string]*openapi.Response{"201": ok("Created, at the Location header", nil)},
+	})
+	d.Add(http.MethodPost, "/users:batch", &openapi.Operation{
+		Summary: "Apply several operations, atomically or not", Tags: []string{"users"},
+		Parameters:  []openapi.Parameter{query("mode", "How failures are handled", openapi.Of(openapi.String, batchAtomic, batchPartial))},
+		RequestBody: body(openapi.OneOf(batch, openapi.ArrayOf(op))),
+		Responses: map[string]*openapi.Response{
+			"200": ok("Every operation was applied", wrapped("results", openapi.ArrayOf(result))),
+			"207": ok("The outcome of each operation", wrapped("results", openapi.ArrayOf(result))),
+			"424": ok("The atomic batch was aborted", wrapped("results", openapi.ArrayOf(result))),
+		},
+	})
+	exportParams := []openapi.Parameter{query("format", "Format, taken from the Accept header if unset", openapi.Of(openapi.String, "ndjson", "csv"))}
+	exported := &openapi.Response{Description: "The users, with a " + transfer.ErrorTrailer + " trailer if the export stopped early", Content: map[string]openapi.MediaType{
+		transfer.NDJSON: {Schema: userRecord},
+		transfer.CSV:    {Schema: openapi.Of(openapi.String)},
+	}}
+	d.Add(http.MethodGet, "/users/export", &openapi.Operation{
+		Summary: "Export users", Tags: []string{"transfer"},
+		Parameters: exportParams,
+		Responses:  map[string]*openapi.Response{"200": exported},
+	})
+	d.Add(http.MethodHead, "/users/export", &openapi.Operation{
+		Summary: "Check the export", Tags: []string{"transfer"},
+		Parameters: exportParams,
+		Responses:  map[string]*openapi.Response{"200": ok("The export format is available", nil)},
+	})
+	d.Add(http.MethodPost, "/users/import", &openapi.Operation{
+		Summary: "Import users, one per line", Tags: []string{"transfer"},
+		Parameters: []openapi.Parameter{query("dry_run", "Validate without saving", openapi.Of(openapi.Boolean))},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			transfer.NDJSON: {Schema: userInput},
+			transfer.CSV:    {Schema: openapi.Of(openapi.String)},
+		}},
+		Responses: map[string]*openapi.Response{"200": {Description: "Rejected lines and progress", Content: map[string]openapi.MediaType{
+			transfer.NDJSON: {Schema: progress},
+		}}},
+	})
+	d.Add(http.MethodGet, "/users/events", &openapi.Operation{
+		Summary: "Stream user changes as server-sent events", Tags: []string{"events"},
+		Parameters: []openapi.Parameter{
+			query("since", "Sequence number to resume after", atLeast(0)),
+			{Name: "Last-Event-ID", In: openapi.InHeader, Description: "Sequence number to resume after", Schema: openapi.Of(openapi.String)},
+		},
+		Responses: map[string]*openapi.Response{"200": {Description: "The changes", Content: map[string]openapi.MediaType{
+			"text/event-stream": {Schema: openapi.Of(openapi.String)},
+		}}},
+	})
+	d.Add(http.MethodGet, "/users/events/ws", &openapi.Operation{
+		Summary: "Stream user changes over a WebSocket", Tags: []string{"events"},
+		Parameters: []openapi.Parameter{query("since", "Sequence number to resume after", atLeast(0))},
+		Responses:  map[string]*openapi.Response{"101": ok("Switching to the WebSocket protocol", nil)},
+	})
+
+	// a user
+	id := pathID("id")
+	oneUserParams := append([]openapi.Parameter{id, include, query("as_of", "Time of the revision to read", &openapi.Schema{Type: openapi.String, Format: "date-time"})}, userShaping...)
+	d.Add(http.MethodGet, "/users/{id}", &openapi.Operation{
+		Summary: "Read a user", Tags: []string{"users"},
+		Parameters: oneUserParams,
+		Responses:  map[string]*openapi.Response{"200": oneUser},
+	})
+	d.Add(http.MethodHead, "/users/{id}", &openapi.Operation{
+		Summary: "Check a user", Tags: []string{"users"},
+		Parameters: oneUserParams,
+		Responses:  map[string]*openapi.Response{"200": ok("The user exists", nil)},
+	})
+	d.Add(http.MethodPut, "/users/{id}", &openapi.Operation{
+		Summary: "Replace a user", Tags: []string{"users"},
+		Parameters:  append([]openapi.Parameter{id}, userShaping...),
+		RequestBody: body(userInput),
+		Responses:   map[string]*openapi.Response{"200": oneUser},
+	})
+	d.Add(http.MethodPatch, "/users/{id}", &openapi.Operation{
+		Summary: "Update a user with a merge patch or a JSON patch", Tags: []string{"users"},
+		Parameters: append([]openapi.Parameter{id}, userShaping...),
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			patch.MergePatchType: {Schema: openapi.Of(openapi.Object)},
+			patch.JSONPatchType:  {Schema: openapi.ArrayOf(openapi.Of(openapi.Object))},
+			codec.JSON:           {Schema: openapi.OneOf(openapi.Of(openapi.Object), openapi.ArrayOf(openapi.Of(openapi.Object)))},
+		}},
+		Responses: map[string]*openapi.Response{"200": oneUser},
+	})
+	d.Add(http.MethodDelete, "/users/{id}", &openapi.Operation{
+		Summary: "Move a user to the trash", Tags: []string{"users"},
+		Parameters: []openapi.Parameter{id},
+		Responses:  map[string]*openapi.Response{"200": done},
+	})
+	d.Add(http.MethodPost, "/users/{id}/restore", &openapi.Operation{
+		Summary: "Restore a user from the trash", Tags: []string{"users"},
+		Parameters: append([]openapi.Parameter{id}, userShaping...),
+		Responses:  map[string]*openapi.Response{"200": oneUser},
+	})
+	d.Add(http.MethodGet, "/users/{id}/revisions", &openapi.Operation{
+		Summary: "List the revisions of a user", Tags: []string{"users"},
+		Parameters: []openapi.Parameter{id},
+		Responses:  map[string]*openapi.Response{"200": ok("The revisions", wrapped("revisions", openapi.ArrayOf(revision)))},
+	})
+	d.Add(http.MethodPost, "/users/{id}/revisions/{version}/revert", &openapi.Operation{
+		Summary: "Revert a user to a revision", Tags: []string{"users"},
+		Parameters: append([]openapi.Parameter{id, {Name: "version", In: openapi.InPath, Required: true, Schema: atLeast(1)}}, userShaping...),
+		Responses:  map[string]*openapi.Response{"200": oneUser},
+	})
+	d.Add(http.MethodGet, "/users/{id}/groups", &openapi.Operation{
+		Summary: "List the groups of a user", Tags: []string{"groups"},
+		Parameters: append([]openapi.Parameter{id}, groupShaping...),
+		Responses:  map[string]*openapi.Response{"200": groups},
+	})
+
+	// groups
+	d.Add(http.MethodGet, "/groups", &openapi.Operation{
+		Summary: "List groups", Tags: []string{"groups"},
+		Parameters: groupShaping,
+		Responses:  map[string]*openapi.Response{"200": groups},
+	})
+	d.Add(http.MethodPost, "/groups", &openapi.Operation{
+		Summary: "Create a group", Tags: []string{"groups"},
+		Parameters:  groupShaping,
+		RequestBody: body(groupInput),
+		Responses:   map[string]*openapi.Response{"201": oneGroup},
+	})
+	d.Add(http.MethodGet, "/groups/{id}", &openapi.Operation{
+		Summary: "Read a group", Tags: []string{"groups"},
+		Parameters: append([]openapi.Parameter{id}, groupShaping...),
+		Responses:  map[string]*openapi.Response{"200": oneGroup},
+	})
+	d.Add(http.MethodPut, "/groups/{id}", &openapi.Operation{
+		Summary: "Replace a group", Tags: []string{"groups"},
+		Parameters:  append([]openapi.Parameter{id}, groupShaping...),
+		RequestBody: body(groupInput),
+		Responses:   map[string]*openapi.Response{"200": oneGroup},
+	})
+	d.Add(http.MethodDelete, "/groups/{id}", &openapi.Operation{
+		Summary: "Delete a group and its memberships", Tags: []string{"groups"},
+		Parameters: []openapi.Parameter{id},
+		Responses:  map[string]*openapi.Response{"200": done},
+	})
+	d.Add(http.MethodGet, "/groups/{id}/members", &openapi.Operation{
+		Summary: "List the members of a group", Tags: []string{"groups"},
+		Parameters: append([]openapi.Parameter{id}, userShaping...),
+		Responses:  map[string]*openapi.Response{"200": users},
+	})
+	d.Add(http.MethodPost, "/groups/{id}/members", &openapi.Operation{
+		Summary: "Add a member to a group", Tags: []string{"groups"},
+		Parameters:  []openapi.Parameter{id},
+		RequestBody: body(d.Schema("Member", member{})),
+		Responses:   map[string]*openapi.Response{"201": ok("Added, at the Location header", nil)},
+	})
+	d.Add(http.MethodDelete, "/groups/{id}/members/{user}", &openapi.Operation{
+		Summary: "Remove a member from a group", Tags: []string{"groups"},
+		Parameters: []openapi.Parameter{id, pathID("user")},
+		Responses:  map[string]*openapi.Response{"200": done},
+	})
+
+	// webhooks
+	d.Add(http.MethodGet, "/webhooks", &openapi.Operation{
+		Summary: "List webhook subscriptions", Tags: []string{"webhooks"},
+		Responses: map[string]*openapi.Response{"200": ok("The subscriptions", wrapped("webhooks", openapi.ArrayOf(subscription)))},
+	})
+	d.Add(http.MethodPost, "/webhooks", &openapi.Operation{
+		Summary: "Subscribe to user events", Tags: []string{"webhooks"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{codec.JSON: {Schema: subscriptionInput}}},
+		Responses:   map[string]*openapi.Response{"201": ok("The subscription with its secret", wrapped("webhook", subscription))},
+	})
+	d.Add(http.MethodGet, "/webhooks/{id}", &openapi.Operation{
+		Summary: "Read a subscription", Tags: []string{"webhooks"},
+		Parameters: []openapi.Parameter{id},
+		Responses:  map[string]*openapi.Response{"200": ok("The subscription", wrapped("webhook", subscription))},
+	})
+	d.Add(http.MethodDelete, "/webhooks/{id}", &openapi.Operation{
+		Summary: "Unsubscribe", Tags: []string{"webhooks"},
+		Parameters: []openapi.Parameter{id},
+		Responses:  map[string]*openapi.Response{"200": done},
+	})
+	d.Add(http.MethodGet, "/webhooks/{id}/deliveries", &openapi.Operation{
+		Summary: "List the deliveries of a subscription", Tags: []string{"webhooks"},
+		Parameters: []openapi.Parameter{id, query("status", "Only deliveries with this status", d.Resolve(delivery).Properties["status"])},
+		Responses:  map[string]*openapi.Response{"200": ok("The deliveries", wrapped("deliveries", openapi.ArrayOf(delivery)))},
+	})
+	d.Add(http.MethodPost, "/webhooks/{id}/deliveries/{delivery}/redeliver", &openapi.Operation{
+		Summary: "Queue a delivery again", Tags: []string{"webhooks"},
+		Parameters: []openapi.Parameter{id, {Name: "delivery", In: openapi.InPath, Required: true, Schema: atLeast(0)}},
+		Responses:  map[string]*openapi.Response{"202": ok("The queued delivery", wrapped("delivery", delivery))},
+	})
+
+	// administration
+	d.Add(http.MethodGet, "/audit", &openapi.Operation{
+		Summary: "List the audit log, oldest entries first", Tags: []string{"admin"},
+		Parameters: []openapi.Parameter{
+			query("resource", "Only entries of this resource, such as users/{id}", openapi.Of(openapi.String)),
+			query("after", "Id of the entry to list after", atLeast(0)),
+			query("limit", "Page size", openapi.Between(openapi.Of(openapi.Integer), 1, auditMaxLimit)),
+		},
+		Responses: map[string]*openapi.Response{"200": ok("The entries, linking to the next page while more may follow", openapi.ObjectOf(map[string]*openapi.Schema{
+			"entries": openapi.ArrayOf(entry),
+			"next":    openapi.Of(openapi.String),
+		}, "entries"))},
+	})
+	d.Add(http.MethodGet, "/tenants", &openapi.Operation{
+		Summary: "List tenants", Tags: []string{"admin"},
+		Responses: map[string]*openapi.Response{"200": ok("The tenants", wrapped("tenants", openapi.ArrayOf(tenantRecord)))},
+	})
+	d.Add(http.MethodPost, "/tenants", &openapi.Operation{
+		Summary: "Create a tenant", Tags: []string{"admin"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{codec.JSON: {Schema: tenantInput}}},
+		Responses:   map[string]*openapi.Response{"201": ok("The tenant", wrapped("tenant", tenantRecord))},
+	})
+	tenantID := openapi.Parameter{Name: "id", In: openapi.InPath, Required: true, Schema: openapi.Of(openapi.String)}
+	d.Add(http.MethodGet, "/tenants/{id}", &openapi.Operation{
+		Summary: "Read a tenant", Tags: []string{"admin"},
+		Parameters: []openapi.Parameter{tenantID},
+		Responses:  map[string]*openapi.Response{"200": ok("The tenant", wrapped("tenant", tenantRecord))},
+	})
+	d.Add(http.MethodPut, "/tenants/{id}", &openapi.Operation{
+		Summary: "Update a tenant", Tags: []string{"admin"},
+		Parameters:  []openapi.Parameter{tenantID},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{codec.JSON: {Schema: tenantInput}}},
+		Responses:   map[string]*openapi.Response{"200": ok("The tenant", wrapped("tenant", tenantRecord))},
+	})
+	d.Add(http.MethodDelete, "/tenants/{id}", &openapi.Operation{
+		Summary: "Delete a tenant and its data", Tags: []string{"admin"},
+		Parameters: []openapi.Parameter{tenantID},
+		Responses:  map[string]*openapi.Response{"200": done},
+	})
+	d.Add(http.MethodGet, "/admin/backup", &openapi.Operation{
+		Summary: "Download a snapshot of the users database", Tags: []string{"admin"},
+		Parameters: []openapi.Parameter{query("tenant", "Tenant whose database to snapshot, the default tenant if unset", openapi.Of(openapi.String))},
+		Responses: map[string]*openapi.Response{"200": {Description: "The snapshot, its checksum in a trailer", Content: map[string]openapi.MediaType{
+			"application/octet-stream": {},
+		}}},
+	})
+
+	// the API itself
+	d.Add(http.MethodGet, "/", &openapi.Operation{
+		OperationID: "get_root",
+		Summary:     "Check that the API is running", Tags: []string{"meta"},
+		Responses: map[string]*openapi.Response{"200": {Description: "Running", Content: map[string]openapi.MediaType{
+			"text/plain": {Schema: openapi.Of(openapi.String)},
+		}}},
+	})
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		d.Add(method, "/openapi.json", &openapi.Operation{
+			Summary: "This description", Tags: []string{"meta"},
+			Responses: map[string]*openapi.Response{"200": {Description: "The OpenAPI document", Content: map[string]openapi.MediaType{
+				codec.JSON: {},
+			}}},
+		})
+		d.Add(method, "/docs", &openapi.Operation{
+			Summary: "Browse this description", Tags: []string{"meta"},
+			Responses: map[string]*openapi.Response{"200": {Description: "The reference page", Content: map[string]openapi.MediaType{
+				"text/html": {Schema: openapi.Of(openapi.String)},
+			}}},
+		})
+	}
+
+	// the operator alone administers the service, the operator and admins
+	// change users and groups, and reads take any credentials or none
+	operator := d.SecurityScheme("operator", &openapi.SecurityScheme{
+		Type: openapi.HTTP, Scheme: "basic",
+		Description: "The operator, who only reaches the default tenant",
+	})
+	token := d.SecurityScheme("token", &openapi.SecurityScheme{
+		Type: openapi.HTTP, Scheme: "bearer", BearerFormat: "JWT",
+		Description: "A token whose " + tenant.Claim + " claim names the tenant and whose " + auth.RolesClaim +
+			" claim, or the user named by its sub claim, holds the " + authz.Admin + " role needed to write",
+	})
+	key := d.SecurityScheme("apiKey", &openapi.SecurityScheme{
+		Type: openapi.APIKey, In: openapi.InHeader, Name: auth.APIKeyHeader,
+		Description: "A client with its own rate limit",
+	})
+	for path, item := range d.Paths {
+		for method, op := range *item {
+			switch {
+			case administrative(path):
+				op.Security = []openapi.SecurityRequirement{operator}
+			case method == "get" || method == "head":
+				op.Security = []openapi.SecurityRequirement{{}, operator, token, key}
+			default:
+				op.Security = []openapi.SecurityRequirement{operator, token}
+			}
+		}
+	}
+
+	// failures are reported with the status text or, when a field is to
+	// blame, an error object
+	failed := &openapi.Response{Description: "Failure", Content: represented(openapi.Ref("Error"))}
+	failed.Content["text/plain"] = openapi.MediaType{Schema: openapi.Of(openapi.String)}
+	for _, item := range d.Paths {
+		for _, op := range *item {
+			op.Responses["default"] = failed
+		}
+	}
+	return d
+}
+
+// administrative reports whether path is reserved to the operator
+func administrative(path string) bool {
+	for _, prefix := range []string{"/webhooks", "/audit", "/tenants", "/admin/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
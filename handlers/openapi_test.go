@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAPIRoutes(t *testing.T) {
+	routes := map[string]func(*http.Request) []string{
+		"/users":    AllowedMethods,
+		"/groups":   GroupsAllowedMethods,
+		"/webhooks": WebhooksAllowedMethods,
+		"/tenants":  TenantsAllowedMethods,
+	}
+	params := strings.NewReplacer(
+		"{id}", bson.NewObjectId().Hex(),
+		"{user}", bson.NewObjectId().Hex(),
+		"{version}", "1",
+		"{delivery}", "1",
+	)
+	documented := map[string]bool{}
+	for _, op := range API.Operations() {
+		method, path := op[0], params.Replace(op[1])
+		documented[method+" "+path] = true
+		for prefix, methods := range routes {
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") && !strings.HasPrefix(path, prefix+":") {
+				continue
+			}
+			if !contains(methods(httptest.NewRequest(method, path, nil)), method) {
+				t.Errorf("Expected %s %s to be routed", method, op[1])
+			}
+		}
+	}
+
+	t.Log("Every routed method is documented")
+	for _, op := range API.Operations() {
+		path := params.Replace(op[1])
+		for prefix, methods := range routes {
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") && !strings.HasPrefix(path, prefix+":") {
+				continue
+			}
+			for _, m := range methods(httptest.NewRequest(http.MethodOptions, path, nil)) {
+				if m != http.MethodOptions && !documented[m+" "+path] {
+					t.Errorf("Expected %s %s to be documented", m, op[1])
+				}
+			}
+		}
+	}
+}
+
+func TestAPISecurity(t *testing.T) {
+	testCases := []struct {
+		method  string
+		path    string
+		schemes []string
+	}{
+		{http.MethodGet, "/users", []string{"", "operator", "token", "apiKey"}},
+		{http.MethodPatch, "/users/{id}", []string{"operator", "token"}},
+		{http.MethodPost, "/groups/{id}/members", []string{"operator", "token"}},
+		{http.MethodGet, "/webhooks", []string{"operator"}},
+		{http.MethodPost, "/tenants", []string{"operator"}},
+		{http.MethodGet, "/admin/backup", []string{"operator"}},
+	}
+	for _, tc := range testCases {
+		op := (*API.Paths[tc.path])[strings.ToLower(tc.method)]
+		schemes := []string{}
+		for _, req := range op.Security {
+			name := ""
+			for n := range req {
+				name = n
+			}
+			schemes = append(schemes, name)
+		}
+		if !reflect.DeepEqual(schemes, tc.schemes) {
+			t.Errorf("Expected %s %s to take %v, got %v", tc.method, tc.path, tc.schemes, schemes)
+		}
+	}
+	for _, name := range []string{"operator", "token", "apiKey"} {
+		if API.Components.SecuritySchemes[name] == nil {
+			t.Errorf("Expected the %s security scheme", name)
+		}
+	}
+}
+
+func TestAPIValidator(t *testing.T) {
+	dir := tenant.Dir
+	tenant.Dir = t.TempDir()
+	defer func() { tenant.Dir = dir }()
+	acme := tenant.Tenant{ID: "acme"}
+	os.MkdirAll(acme.Path(""), 0o755)
+	ctx := tenant.NewContext(context.Background(), acme)
+	defer os.Remove("webhooks.db")
+
+	reported := []error{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", UsersRouter)
+	mux.HandleFunc("/users/", UsersRouter)
+	mux.HandleFunc("/groups", GroupsRouter)
+	mux.HandleFunc("/groups/", GroupsRouter)
+	h := API.Validator(func(r *http.Request, err error) { reported = append(reported, err) })(mux)
+	serve := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodPost, "/users", "application/json", `{"name": "Ann", "email": "ann@example.com"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	u := w.Header().Get("Location")
+	w = serve(http.MethodPost, "/groups", "application/json", `{"name": "Admins", "role": "admin"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	group := w.Header().Get("Location")
+	serve(http.MethodPost, group+"/members", "application/json", `{"user_id": "`+strings.TrimPrefix(u, "/users/")+`"}`)
+
+	testCases := []struct {
+		txt         string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"Listing users", http.MethodGet, "/users", "", "", http.StatusOK},
+		{"Filtering users", http.MethodGet, "/users?email=ann@example.com&fields=name", "", "", http.StatusOK},
+		{"Reading a user", http.MethodGet, u, "", "", http.StatusOK},
+		{"Expanding the groups of a user", http.MethodGet, u + "?expand=groups", "", "", http.StatusOK},
+		{"Patching a user", http.MethodPatch, u, "application/merge-patch+json", `{"role": "editor"}`, http.StatusOK},
+		{"Replacing a user", http.MethodPut, u, "application/json", `{"name": "Ann", "attributes": {"team": "core"}}`, http.StatusOK},
+		{"Listing revisions", http.MethodGet, u + "/revisions", "", "", http.StatusOK},
+		{"Expanding the members of a group", http.MethodGet, group + "?expand=members", "", "", http.StatusOK},
+		{"Listing the groups of a user", http.MethodGet, u + "/groups", "", "", http.StatusOK},
+		{"Missing users", http.MethodGet, "/users/" + bson.NewObjectId().Hex(), "", "", http.StatusNotFound},
+		{"Bodies of the wrong type are rejected", http.MethodPost, "/users", "application/json", `{"name": 1}`, http.StatusBadRequest},
+		{"Bodies missing required fields are rejected", http.MethodPost, "/groups", "application/json", `{"role": "admin"}`, http.StatusBadRequest},
+		{"Undocumented parameters are rejected", http.MethodGet, "/users?sort=name", "", "", http.StatusBadRequest},
+		{"Invalid parameters are rejected", http.MethodGet, u + "?as_of=yesterday", "", "", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := serve(tc.method, tc.path, tc.contentType, tc.body)
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+		}
+		if tc.status == http.StatusBadRequest && !strings.Contains(w.Body.String(), "violations") {
+			t.Errorf("Expected the violations, got %s", w.Body)
+		}
+	}
+	for _, err := range reported {
+		t.Errorf("Expected responses to match the API description, got %s", err)
+	}
+
+	t.Log("Undocumented routes are reported")
+	serve(http.MethodGet, "/users/unknown", "", "")
+	if len(reported) != 1 {
+		t.Errorf("Expected the undocumented route to be reported, got %v", reported)
+	}
+}
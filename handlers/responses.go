@@ -1,7 +1,10 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/user"
 	"net/http"
 	"strings"
 )
@@ -12,23 +15,61 @@ func postError(w http.ResponseWriter, code int) {
 	http.Error(w, http.StatusText(code), code)
 }
 
-func postBodyResponse(w http.ResponseWriter, code int, content jsonResponse) {
+// postDecodeError reports why a request body was rejected
+func postDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *decode.Error
+	if errors.As(err, &e) {
+		postBodyResponse(w, r, e.Status, jsonResponse{"error": e})
+		return
+	}
+	postError(w, http.StatusBadRequest)
+}
+
+// postStoreError reports why the user store rejected a request, naming the
+// invalid or conflicting field if there is one
+func postStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	var fe *user.FieldError
+	if errors.As(err, &fe) {
+		postBodyResponse(w, r, ErrorStatus(err), jsonResponse{"error": fe})
+		return
+	}
+	var ce *user.ConflictError
+	if errors.As(err, &ce) {
+		e := jsonResponse{"message": ce.Error(), "field": ce.Field, "value": ce.Value}
+		if ce.Trashed {
+			e["trashed"], e["id"] = true, ce.ID.Hex()
+		}
+		postBodyResponse(w, r, ErrorStatus(err), jsonResponse{"error": e})
+		return
+	}
+	postError(w, ErrorStatus(err))
+}
+
+// postBodyResponse writes content in the representation negotiated from the
+// Accept header of the request
+func postBodyResponse(w http.ResponseWriter, r *http.Request, code int, content jsonResponse) {
 	if content != nil {
-		js, err := json.Marshal(content)
+		mt, c, ok := codec.Default.Negotiate(r.Header.Get("Accept"))
+		if !ok {
+			postError(w, http.StatusNotAcceptable)
+			return
+		}
+		bd, err := c.Marshal(content)
 		if err != nil {
 			postError(w, http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Type", mt)
+		w.Header().Add("Vary", "Accept")
 		w.WriteHeader(code)
-		w.Write(js)
+		w.Write(bd)
 		return
 	}
 	w.WriteHeader(code)
 	w.Write([]byte(http.StatusText(code)))
 }
 
-func postOptionsResponse(w http.ResponseWriter, methods []string, content jsonResponse) {
+func postOptionsResponse(w http.ResponseWriter, r *http.Request, methods []string, content jsonResponse) {
 	w.Header().Set("Allow", strings.Join(methods, ","))
-	postBodyResponse(w, http.StatusOK, content)
+	postBodyResponse(w, r, http.StatusOK, content)
 }
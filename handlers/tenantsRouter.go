@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"net/http"
+	"strings"
+)
+
+// tenantDecoding are the rules for tenant request bodies
+var tenantDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     []string{"created_at"},
+}
+
+// tenantStatus maps tenant errors to HTTP status codes
+func tenantStatus(err error) int {
+	switch err {
+	case tenant.ErrInvalidTenant:
+		return http.StatusBadRequest
+	case tenant.ErrUnknownTenant:
+		return http.StatusNotFound
+	case tenant.ErrExists:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func tenantsGetAll(w http.ResponseWriter, r *http.Request) {
+	ts, err := tenant.All()
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"tenants": ts})
+}
+
+func tenantsPostOne(w http.ResponseWriter, r *http.Request) {
+	t := new(tenant.Tenant)
+	if err := decode.JSON(r, t, tenantDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	if err := t.Create(); err != nil {
+		postError(w, tenantStatus(err))
+		return
+	}
+	// a new tenant starts with a users database at the current schema
+	if _, _, err := migrate.Up(t.Path(user.DBPath)); err != nil {
+		tenant.Delete(t.ID)
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/tenants/"+t.ID)
+	postBodyResponse(w, r, http.StatusCreated, jsonResponse{"tenant": t})
+}
+
+func tenantsGetOne(w http.ResponseWriter, r *http.Request, id string) {
+	t, err := tenant.One(id)
+	if err != nil {
+		postError(w, tenantStatus(err))
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"tenant": t})
+}
+
+func tenantsPutOne(w http.ResponseWriter, r *http.Request, id string) {
+	t := new(tenant.Tenant)
+	if err := decode.JSON(r, t, tenantDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	if t.ID != "" && t.ID != id {
+		postDecodeError(w, r, &decode.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "field is read-only",
+			Field:   "id",
+		})
+		return
+	}
+	t.ID = id
+	if err := t.Update(); err != nil {
+		postError(w, tenantStatus(err))
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"tenant": t})
+}
+
+func tenantsDeleteOne(w http.ResponseWriter, r *http.Request, id string) {
+	t, err := tenant.One(id)
+	if err != nil {
+		postError(w, tenantStatus(err))
+		return
+	}
+	if err := tenant.Delete(id); err != nil {
+		postError(w, tenantStatus(err))
+		return
+	}
+	cache.DropTenant(tenant.NewContext(r.Context(), *t))
+	w.WriteHeader(http.StatusOK)
+}
+
+// tenantRoute returns the handlers by method of the tenants route matching
+// path, or nil if no route matches
+func tenantRoute(path string) map[string]usersHandler {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] != "tenants" {
+		return nil
+	}
+	if len(parts) == 1 {
+		return map[string]usersHandler{
+			http.MethodGet:  tenantsGetAll,
+			http.MethodPost: tenantsPostOne,
+		}
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		return nil
+	}
+	id := parts[1]
+	return map[string]usersHandler{
+		http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { tenantsGetOne(w, r, id) },
+		http.MethodPut:    func(w http.ResponseWriter, r *http.Request) { tenantsPutOne(w, r, id) },
+		http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { tenantsDeleteOne(w, r, id) },
+	}
+}
+
+// TenantsAllowedMethods returns the methods served by the tenants route of
+// the request, or nil if no route matches
+func TenantsAllowedMethods(r *http.Request) []string {
+	methods := tenantRoute(r.URL.Path)
+	if methods == nil {
+		return nil
+	}
+	return allowed(func(m string) bool { _, ok := methods[m]; return ok })
+}
+
+// TenantsRouter handles requests for the tenants admin route:
+//
+//	GET, POST        /tenants
+//	GET, PUT, DELETE /tenants/{id}
+func TenantsRouter(w http.ResponseWriter, r *http.Request) {
+	methods := tenantRoute(r.URL.Path)
+	if methods == nil {
+		postError(w, http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		postOptionsResponse(w, r, TenantsAllowedMethods(r), nil)
+		return
+	}
+	h, ok := methods[r.Method]
+	if !ok {
+		postError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	h(w, r)
+}
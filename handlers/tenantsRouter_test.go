@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/golang-jwt/jwt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTenantsAllowedMethods(t *testing.T) {
+	ts := []struct {
+		path string
+		exp  []string
+	}{
+		{"/tenants", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/tenants/acme", []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodOptions}},
+		{"/tenants/acme/users", nil},
+		{"/unknown", nil},
+	}
+
+	for _, tc := range ts {
+		got := TenantsAllowedMethods(httptest.NewRequest(http.MethodOptions, tc.path, nil))
+		if !reflect.DeepEqual(tc.exp, got) {
+			t.Errorf("Expected methods %v for %s, got %v", tc.exp, tc.path, got)
+		}
+	}
+}
+
+// claim returns a token claiming the tenant id and granting its admin role
+func claim(id string) string {
+	s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		tenant.Claim:    id,
+		auth.RolesClaim: []string{"admin"},
+	}).SignedString(tenant.TokenSecret)
+	return s
+}
+
+func serve(h http.Handler, method, path, id string, body interface{}) *httptest.ResponseRecorder {
+	bd, _ := json.Marshal(body)
+	r := httptest.NewRequest(method, path, bytes.NewReader(bd))
+	r.Header.Set("Content-Type", "application/json")
+	if id != "" {
+		r.Header.Set(tenant.Header, id)
+		r.Header.Set("Authorization", "Bearer "+claim(id))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+	dir, dbPath := tenant.Dir, tenant.DBPath
+	tenant.Dir = t.TempDir()
+	tenant.DBPath = filepath.Join(tenant.Dir, "tenants.db")
+	tenant.TokenSecret = []byte("secret")
+	defer func() {
+		tenant.Dir, tenant.DBPath = dir, dbPath
+		tenant.TokenSecret = nil
+	}()
+
+	tenants := http.HandlerFunc(TenantsRouter)
+	users := tenant.Middleware(http.HandlerFunc(UsersRouter))
+	for _, id := range []string{"acme", "globex"} {
+		if w := serve(tenants, http.MethodPost, "/tenants", "", jsonResponse{"id": id, "max_users": 1}); w.Code != http.StatusCreated {
+			t.Fatalf("Expected tenant %s to be created, got %d", id, w.Code)
+		}
+	}
+
+	count := func(id string) int {
+		w := serve(users, http.MethodGet, "/users", id, nil)
+		var body struct{ Users []interface{} }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return len(body.Users)
+	}
+	// caches the empty list of both tenants
+	count("acme")
+	count("globex")
+
+	testCases := []struct {
+		txt    string
+		method string
+		path   string
+		tenant string
+		status int
+	}{
+		{"A tenant creates a user", http.MethodPost, "/users", "acme", http.StatusCreated},
+		{"The quota of the tenant is enforced", http.MethodPost, "/users", "acme", http.StatusForbidden},
+		{"Another tenant has its own quota", http.MethodPost, "/users", "globex", http.StatusCreated},
+		{"An unknown tenant is not found", http.MethodGet, "/users", "initech", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := serve(users, tc.method, tc.path, tc.tenant, jsonResponse{"name": "Ann"})
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+		}
+	}
+
+	t.Log("Each tenant reads its own users")
+	if n := count("acme"); n != 1 {
+		t.Errorf("Expected 1 user in acme, got %d", n)
+	}
+	if n := count("globex"); n != 1 {
+		t.Errorf("Expected 1 user in globex, got %d", n)
+	}
+
+	t.Log("Deleting a tenant forgets it")
+	if w := serve(tenants, http.MethodDelete, "/tenants/acme", "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := serve(users, http.MethodGet, "/users", "acme", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
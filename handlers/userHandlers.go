@@ -5,12 +5,30 @@ import (
 	"errors"
 	"github.com/asdine/storm/v3"
 	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/hal"
+	"github.com/christianotieno/go-rest-api/patch"
 	"github.com/christianotieno/go-rest-api/user"
 	"gopkg.in/mgo.v2/bson"
-	"io"
 	"net/http"
+	"time"
 )
 
+// userDecoding are the rules for user request bodies
+var userDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     user.ReadOnly,
+	Codecs:       codec.Default,
+}
+
+// patchDecoding are the rules for PATCH request bodies
+var patchDecoding = decode.Options{
+	ContentTypes: []string{patch.MergePatchType, patch.JSONPatchType, "application/json"},
+	MaxBytes:     decode.Defaults.MaxBytes,
+}
+
 func bodyToUser(r *http.Request, u *user.User) error {
 	if r == nil {
 		return errors.New("a request is required")
@@ -21,57 +39,181 @@ func bodyToUser(r *http.Request, u *user.User) error {
 	if u == nil {
 		return errors.New("a user is required")
 	}
-	bd, err := io.ReadAll(r.Body)
+	return decode.JSON(r, u, userDecoding)
+}
+
+// includeDeleted reports whether the request asks for deleted users too.
+// Such responses, like filtered ones, are not cached since writes only drop
+// the plain resources.
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("include") == "deleted"
+}
+
+// indexFilter returns the indexed field, such as email, the request filters
+// users by. Filtering by more than one field is not supported.
+func indexFilter(r *http.Request) (idx user.Index, value string, ok bool, err error) {
+	query := r.URL.Query()
+	for _, i := range user.Indexes {
+		if _, set := query[i.Name]; !set {
+			continue
+		}
+		if ok {
+			return user.Index{}, "", false, errors.New("only one indexed field can be filtered on")
+		}
+		idx, value, ok = i, query.Get(i.Name), true
+	}
+	return idx, value, ok, nil
+}
+
+// userOptions returns how the fields and expand parameters of the request
+// shape user representations
+func userOptions(r *http.Request) (hal.Options, error) {
+	return hal.Parse(r.URL.Query(), hal.UserFields, hal.UserRelations)
+}
+
+// postUser writes the representation of u with its links, shaped by opts
+func postUser(w http.ResponseWriter, r *http.Request, code int, u *user.User, opts hal.Options) {
+	m, err := hal.User(r.Context(), u, opts)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, code, jsonResponse{"user": m})
+}
+
+// postUsers writes the representations of users with their links, shaped
+// by opts, along with the links of the collection
+func postUsers(w http.ResponseWriter, r *http.Request, code int, users []user.User, links hal.Links, opts hal.Options) {
+	ms, err := hal.Users(r.Context(), users, opts)
 	if err != nil {
-		return err
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, code, jsonResponse{"users": ms, hal.LinksKey: links})
+}
+
+// cacheWriter caches the response written to w, unless opts shape it since
+// writes only drop the plain resources
+func cacheWriter(w http.ResponseWriter, r *http.Request, opts hal.Options) http.ResponseWriter {
+	if opts.Shaped() {
+		return w
 	}
-	return json.Unmarshal(bd, u)
+	return cache.NewWriter(w, r)
 }
 
 func usersGetAll(w http.ResponseWriter, r *http.Request) {
-	if cache.Serve(w, r) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	idx, value, filtered, err := indexFilter(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
 		return
 	}
-	users, err := user.All()
+	page, err := hal.ParsePage(r.URL.Query())
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	if filtered {
+		users, err := user.FindByContext(r.Context(), idx, value)
+		if err != nil {
+			postError(w, http.StatusInternalServerError)
+			return
+		}
+		postUsers(w, r, http.StatusOK, users, hal.CollectionLinks("/users"), opts)
+		return
+	}
+	if includeDeleted(r) {
+		users, err := user.AllWithDeletedContext(r.Context())
+		if err != nil {
+			postError(w, http.StatusInternalServerError)
+			return
+		}
+		postUsers(w, r, http.StatusOK, users, hal.CollectionLinks("/users"), opts)
+		return
+	}
+	if page.Paged() {
+		users, err := user.PageContext(r.Context(), page.After, page.Limit)
+		if err != nil {
+			postError(w, http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodHead {
+			postBodyResponse(w, r, http.StatusOK, jsonResponse{})
+			return
+		}
+		postUsers(w, r, http.StatusOK, users, hal.PageLinks("/users", r.URL.Query(), page, users), opts)
+		return
+	}
+	if !opts.Shaped() && cache.Serve(w, r) {
+		return
+	}
+	users, err := user.AllContext(r.Context())
 	if err != nil {
 		postError(w, http.StatusInternalServerError)
 		return
 	}
 	if r.Method == http.MethodHead {
-		postBodyResponse(w, http.StatusOK, jsonResponse{})
+		postBodyResponse(w, r, http.StatusOK, jsonResponse{})
 		return
 	}
-	cw := cache.NewWriter(w, r)
-	postBodyResponse(cw, http.StatusOK, jsonResponse{"users": users})
+	postUsers(cacheWriter(w, r, opts), r, http.StatusOK, users, hal.CollectionLinks("/users"), opts)
 }
 
 func usersPostOne(w http.ResponseWriter, r *http.Request) {
 	u := new(user.User)
 	err := bodyToUser(r, u)
 	if err != nil {
-		postError(w, http.StatusBadRequest)
+		postDecodeError(w, r, err)
 		return
 	}
 	u.ID = bson.NewObjectId()
-	err = u.Save()
+	err = u.SaveContext(r.Context())
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			postError(w, http.StatusBadRequest)
-		} else {
-			postError(w, http.StatusInternalServerError)
-		}
+		postStoreError(w, r, err)
 		return
 	}
-	cache.Drop("/users")
+	cache.DropUsers(r.Context(), u.ID)
 	w.Header().Set("Location", "/users/"+u.ID.Hex())
 	w.WriteHeader(http.StatusCreated)
 }
 
 func usersGetOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
-	if cache.Serve(w, r) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		t, err := time.Parse(time.RFC3339Nano, asOf)
+		if err != nil {
+			postError(w, http.StatusBadRequest)
+			return
+		}
+		u, err := user.AsOfContext(r.Context(), id, t)
+		if err != nil {
+			postStoreError(w, r, err)
+			return
+		}
+		postUser(w, r, http.StatusOK, u, opts)
 		return
 	}
-	u, err := user.One(id)
+	if includeDeleted(r) {
+		u, err := user.OneWithDeletedContext(r.Context(), id)
+		if err != nil {
+			postStoreError(w, r, err)
+			return
+		}
+		postUser(w, r, http.StatusOK, u, opts)
+		return
+	}
+	if !opts.Shaped() && cache.Serve(w, r) {
+		return
+	}
+	u, err := user.OneContext(r.Context(), id)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			postError(w, http.StatusNotFound)
@@ -82,38 +224,54 @@ func usersGetOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
 	}
 
 	if r.Method == http.MethodHead {
-		postBodyResponse(w, http.StatusOK, jsonResponse{})
+		postBodyResponse(w, r, http.StatusOK, jsonResponse{})
 		return
 	}
-	cw := cache.NewWriter(w, r)
-	postBodyResponse(cw, http.StatusOK, jsonResponse{"user": u})
+	postUser(cacheWriter(w, r, opts), r, http.StatusOK, u, opts)
 }
 
 func usersPutOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
 	u := new(user.User)
-	err := bodyToUser(r, u)
+	err = bodyToUser(r, u)
 	if err != nil {
-		postError(w, http.StatusBadRequest)
+		postDecodeError(w, r, err)
 		return
 	}
 	u.ID = id
-	err = u.Save()
+	err = u.SaveContext(r.Context())
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			postError(w, http.StatusBadRequest)
-		} else {
-			postError(w, http.StatusInternalServerError)
-		}
+		postStoreError(w, r, err)
 		return
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(r))
-	cw := cache.NewWriter(w, r)
-	postBodyResponse(cw, http.StatusOK, jsonResponse{"user": u})
+	cache.DropUsers(r.Context(), id)
+	postUser(cacheWriter(w, r, opts), r, http.StatusOK, u, opts)
+}
+
+// patchStatus maps patch errors to HTTP status codes
+func patchStatus(err error) int {
+	switch {
+	case errors.Is(err, patch.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, patch.ErrTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, patch.ErrInvalidPath):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
 }
 
 func usersPatchOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
-	u, err := user.One(id)
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	u, err := user.OneContext(r.Context(), id)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			postError(w, http.StatusNotFound)
@@ -122,29 +280,51 @@ func usersPatchOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
 		postError(w, http.StatusInternalServerError)
 		return
 	}
-	err = bodyToUser(r, u)
+	bd, err := decode.Body(r, patchDecoding)
 	if err != nil {
-		postError(w, http.StatusBadRequest)
+		postDecodeError(w, r, err)
 		return
 	}
-	u.ID = id
-	err = u.Save()
+	doc, err := json.Marshal(u)
 	if err != nil {
-		if err == user.ErrRecordInvalid {
-			postError(w, http.StatusBadRequest)
-		} else {
-			postError(w, http.StatusInternalServerError)
-		}
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	patched, err := patch.Apply(r.Header.Get("Content-Type"), doc, bd)
+	if err != nil {
+		postBodyResponse(w, r, patchStatus(err), jsonResponse{"error": err.Error()})
+		return
+	}
+	field, err := patch.Changed(doc, patched, user.ReadOnly)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	if field != "" {
+		postDecodeError(w, r, &decode.Error{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "field is read-only",
+			Field:   field,
+		})
+		return
+	}
+	u = new(user.User)
+	err = decode.Unmarshal(patched, u, decode.Options{})
+	if err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	err = u.SaveContext(r.Context())
+	if err != nil {
+		postStoreError(w, r, err)
 		return
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(r))
-	cw := cache.NewWriter(w, r)
-	postBodyResponse(cw, http.StatusOK, jsonResponse{"user": u})
+	cache.DropUsers(r.Context(), id)
+	postUser(cacheWriter(w, r, opts), r, http.StatusOK, u, opts)
 }
 
 func usersDeleteOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
-	err := user.Delete(id)
+	err := user.DeleteContext(r.Context(), id)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			postError(w, http.StatusNotFound)
@@ -153,7 +333,45 @@ func usersDeleteOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
 		postError(w, http.StatusInternalServerError)
 		return
 	}
-	cache.Drop("/users")
-	cache.Drop(cache.MakeResource(r))
+	cache.DropUsers(r.Context(), id)
 	w.WriteHeader(http.StatusOK)
 }
+
+func usersRestoreOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	u, err := user.RestoreContext(r.Context(), id)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	cache.DropUsers(r.Context(), id)
+	postUser(w, r, http.StatusOK, u, opts)
+}
+
+func usersRevisions(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	revs, err := user.RevisionsContext(r.Context(), id)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"revisions": revs})
+}
+
+func usersRevert(w http.ResponseWriter, r *http.Request, id bson.ObjectId, version int) {
+	opts, err := userOptions(r)
+	if err != nil {
+		postBodyResponse(w, r, http.StatusBadRequest, jsonResponse{"error": err.Error()})
+		return
+	}
+	u, err := user.Revert(r.Context(), id, version)
+	if err != nil {
+		postStoreError(w, r, err)
+		return
+	}
+	cache.DropUsers(r.Context(), id)
+	postUser(w, r, http.StatusOK, u, opts)
+}
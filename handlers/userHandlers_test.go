@@ -2,31 +2,38 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"github.com/christianotieno/go-rest-api/patch"
+	"github.com/christianotieno/go-rest-api/tenant"
 	"github.com/christianotieno/go-rest-api/user"
 	"gopkg.in/mgo.v2/bson"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
 )
 
 func TestBuildUser(t *testing.T) {
 	valid := &user.User{
-		ID:   bson.NewObjectId(),
 		Name: "John",
 		Role: "Tester",
 	}
 	valid2 := &user.User{
-		ID:   valid.ID,
 		Name: "John",
 		Role: "Developer",
 	}
-	js, err := json.Marshal(valid)
+	js, err := json.Marshal(struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}{valid.Name, valid.Role})
 	if err != nil {
 		t.Errorf("Error marshalling a valid user: %s", err)
 		t.FailNow()
 	}
+	jsonHeader := http.Header{"Content-Type": []string{"application/json"}}
 	ts := []struct {
 		txt string
 		r   *http.Request
@@ -46,14 +53,43 @@ func TestBuildUser(t *testing.T) {
 		{
 			txt: "empty user",
 			r: &http.Request{
-				Body: io.NopCloser(bytes.NewBufferString("{}")),
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBufferString("{}")),
 			},
 			err: true,
 		},
 		{
 			txt: "malformed data in request body",
 			r: &http.Request{
-				Body: io.NopCloser(bytes.NewBufferString(`{"id": "1234"}`)),
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBufferString(`{"name": 1234}`)),
+			},
+			u:   &user.User{},
+			err: true,
+		},
+		{
+			txt: "client supplied id",
+			r: &http.Request{
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBufferString(`{"id": "5f1a5b3e8f1b2c3d4e5f6a7b", "name": "John"}`)),
+			},
+			u:   &user.User{},
+			err: true,
+		},
+		{
+			txt: "unknown field",
+			r: &http.Request{
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBufferString(`{"age": "37", "role": "Developer"}`)),
+			},
+			u:   &user.User{},
+			err: true,
+		},
+		{
+			txt: "unsupported content type",
+			r: &http.Request{
+				Header: http.Header{"Content-Type": []string{"text/plain"}},
+				Body:   io.NopCloser(bytes.NewBuffer(js)),
 			},
 			u:   &user.User{},
 			err: true,
@@ -61,7 +97,8 @@ func TestBuildUser(t *testing.T) {
 		{
 			txt: "valid request body",
 			r: &http.Request{
-				Body: io.NopCloser(bytes.NewBuffer(js)),
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBuffer(js)),
 			},
 			u:   &user.User{},
 			exp: valid,
@@ -69,7 +106,8 @@ func TestBuildUser(t *testing.T) {
 		{
 			txt: "valid partial request",
 			r: &http.Request{
-				Body: io.NopCloser(bytes.NewBufferString(`{"age": "37", "role": "Developer"}`)),
+				Header: jsonHeader,
+				Body:   io.NopCloser(bytes.NewBufferString(`{"role": "Developer"}`)),
 			},
 			u:   valid,
 			exp: valid2,
@@ -95,3 +133,121 @@ func TestBuildUser(t *testing.T) {
 		}
 	}
 }
+
+func TestIndexFilter(t *testing.T) {
+	ts := []struct {
+		query string
+		field string
+		value string
+		err   bool
+	}{
+		{"", "", "", false},
+		{"email=ann@example.com", "Email", "ann@example.com", false},
+		{"role=Admin&include=deleted", "Role", "Admin", false},
+		{"attributes=x", "", "", false},
+		{"role=Admin&status=active", "", "", true},
+	}
+
+	for _, tc := range ts {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+tc.query, nil)
+		idx, value, ok, err := indexFilter(r)
+		if (err != nil) != tc.err {
+			t.Errorf("Expected error %v for %q, got %v", tc.err, tc.query, err)
+			continue
+		}
+		if ok != (tc.field != "") || idx.Field != tc.field || value != tc.value {
+			t.Errorf("Expected filter %s=%s for %q, got %s=%s", tc.field, tc.value, tc.query, idx.Field, value)
+		}
+	}
+}
+
+func TestUsersPages(t *testing.T) {
+	dir := tenant.Dir
+	tenant.Dir = t.TempDir()
+	defer func() { tenant.Dir = dir }()
+	acme := tenant.Tenant{ID: "acme"}
+	os.MkdirAll(acme.Path(""), 0o755)
+	ctx := tenant.NewContext(context.Background(), acme)
+	ids := []string{}
+	for _, name := range []string{"Ann", "Bob", "Cid"} {
+		u := &user.User{ID: bson.NewObjectId(), Name: name}
+		if err := u.SaveContext(ctx); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+		ids = append(ids, u.ID.Hex())
+	}
+
+	testCases := []struct {
+		txt    string
+		query  string
+		status int
+		users  int
+		next   string
+	}{
+		{"First page", "limit=2&fields=name", http.StatusOK, 2, "/users?after=" + ids[1] + "&fields=name&limit=2"},
+		{"Last page", "after=" + ids[1] + "&limit=2", http.StatusOK, 1, ""},
+		{"Full last page", "after=" + ids[0] + "&limit=2", http.StatusOK, 2, "/users?after=" + ids[2] + "&limit=2"},
+		{"Filtered lists are not paged", "name=Ann&limit=1", http.StatusOK, 1, ""},
+		{"Invalid limit", "limit=0", http.StatusBadRequest, 0, ""},
+		{"Invalid cursor", "after=x", http.StatusBadRequest, 0, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		w := httptest.NewRecorder()
+		usersGetAll(w, httptest.NewRequest(http.MethodGet, "/users?"+tc.query, nil).WithContext(ctx))
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+			continue
+		}
+		var body struct {
+			Users []map[string]interface{}     `json:"users"`
+			Links map[string]map[string]string `json:"_links"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if len(body.Users) != tc.users || body.Links["next"]["href"] != tc.next {
+			t.Errorf("Expected %d users and next link %q, got %d and %v", tc.users, tc.next, len(body.Users), body.Links)
+		}
+	}
+}
+
+func TestUsersPatchReadOnly(t *testing.T) {
+	dir := tenant.Dir
+	tenant.Dir = t.TempDir()
+	defer func() { tenant.Dir = dir }()
+	acme := tenant.Tenant{ID: "acme"}
+	os.MkdirAll(acme.Path(""), 0o755)
+	ctx := tenant.NewContext(context.Background(), acme)
+	u := &user.User{ID: bson.NewObjectId(), Name: "Ann"}
+	if err := u.SaveContext(ctx); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+
+	testCases := []struct {
+		txt         string
+		contentType string
+		body        string
+		status      int
+		field       string
+	}{
+		{"Merge patch of the version", patch.MergePatchType, `{"version":7}`, http.StatusUnprocessableEntity, "version"},
+		{"Merge patch of the creation time", patch.MergePatchType, `{"created_at":"2020-01-01T00:00:00Z"}`, http.StatusUnprocessableEntity, "created_at"},
+		{"JSON Patch of the update time", patch.JSONPatchType, `[{"op":"replace","path":"/updated_at","value":"2020-01-01T00:00:00Z"}]`, http.StatusUnprocessableEntity, "updated_at"},
+		{"JSON Patch of the deletion time", patch.JSONPatchType, `[{"op":"add","path":"/deleted_at","value":"2020-01-01T00:00:00Z"}]`, http.StatusUnprocessableEntity, "deleted_at"},
+		{"JSON Patch of the id", patch.JSONPatchType, `[{"op":"replace","path":"/id","value":"` + bson.NewObjectId().Hex() + `"}]`, http.StatusUnprocessableEntity, "id"},
+		{"Patch of other fields", patch.MergePatchType, `{"name":"Anne"}`, http.StatusOK, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		r := httptest.NewRequest(http.MethodPatch, "/users/"+u.ID.Hex(), bytes.NewBufferString(tc.body)).WithContext(ctx)
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		usersPatchOne(w, r, u.ID)
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+			continue
+		}
+		if tc.field != "" && !bytes.Contains(w.Body.Bytes(), []byte(`"field":"`+tc.field+`"`)) {
+			t.Errorf("Expected field %s to be named, got %s", tc.field, w.Body)
+		}
+	}
+}
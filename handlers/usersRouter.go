@@ -1,61 +1,201 @@
 package handlers
 
 import (
+	"github.com/christianotieno/go-rest-api/codec"
+	"github.com/christianotieno/go-rest-api/feed"
+	"github.com/christianotieno/go-rest-api/transfer"
 	"gopkg.in/mgo.v2/bson"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+type usersHandler func(w http.ResponseWriter, r *http.Request)
+
+type userHandler func(w http.ResponseWriter, r *http.Request, id bson.ObjectId)
+
+// usersMethods are the handlers of the /users collection by method
+var usersMethods = map[string]usersHandler{
+	http.MethodGet:  usersGetAll,
+	http.MethodHead: usersGetAll,
+	http.MethodPost: usersPostOne,
+}
+
+// userMethods are the handlers of a single /users/{id} resource by method
+var userMethods = map[string]userHandler{
+	http.MethodGet:    usersGetOne,
+	http.MethodHead:   usersGetOne,
+	http.MethodPut:    usersPutOne,
+	http.MethodPatch:  usersPatchOne,
+	http.MethodDelete: usersDeleteOne,
+}
+
+// restoreMethods are the handlers of the /users/{id}/restore endpoint by method
+var restoreMethods = map[string]userHandler{
+	http.MethodPost: usersRestoreOne,
+}
+
+// revisionsMethods are the handlers of the /users/{id}/revisions endpoint by method
+var revisionsMethods = map[string]userHandler{
+	http.MethodGet: usersRevisions,
+}
+
+// userGroupsMethods are the handlers of the /users/{id}/groups endpoint by method
+var userGroupsMethods = map[string]userHandler{
+	http.MethodGet: usersGetGroups,
+}
+
+// revertMethods returns the handlers of the
+// /users/{id}/revisions/{version}/revert endpoint by method
+func revertMethods(version int) map[string]userHandler {
+	return map[string]userHandler{
+		http.MethodPost: func(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+			usersRevert(w, r, id, version)
+		},
+	}
+}
+
+// batchMethods are the handlers of the /users:batch endpoint by method
+var batchMethods = map[string]usersHandler{
+	http.MethodPost: UsersBatch,
+}
+
+// exportMethods are the handlers of the /users/export endpoint by method
+var exportMethods = map[string]usersHandler{
+	http.MethodGet:  transfer.Export,
+	http.MethodHead: transfer.Export,
+}
+
+// importMethods are the handlers of the /users/import endpoint by method
+var importMethods = map[string]usersHandler{
+	http.MethodPost: transfer.Import,
+}
+
+// eventsMethods are the handlers of the /users/events change feed by method
+var eventsMethods = map[string]usersHandler{
+	http.MethodGet: feed.Events,
+}
+
+// eventsSocketMethods are the handlers of the /users/events/ws change feed by method
+var eventsSocketMethods = map[string]usersHandler{
+	http.MethodGet: feed.WebSocket(CORSPolicy()).ServeHTTP,
+}
+
+// collectionRoutes are the routes under /users that do not name a user
+var collectionRoutes = map[string]map[string]usersHandler{
+	"/users":           usersMethods,
+	"/users:batch":     batchMethods,
+	"/users/export":    exportMethods,
+	"/users/import":    importMethods,
+	"/users/events":    eventsMethods,
+	"/users/events/ws": eventsSocketMethods,
+}
+
+// streamingRoutes produce their own formats instead of the codec representations
+var streamingRoutes = map[string]bool{
+	"/users/export":    true,
+	"/users/import":    true,
+	"/users/events":    true,
+	"/users/events/ws": true,
+}
+
+// methodOrder is the order in which methods are listed in Allow headers
+var methodOrder = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// allowed lists the methods accepted by has, OPTIONS included
+func allowed(has func(method string) bool) []string {
+	methods := []string{}
+	for _, m := range methodOrder {
+		if m == http.MethodOptions || has(m) {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// userRoute returns the handlers by method of the route under /users/{id}
+// matching path along with the id, or nil if no route matches
+func userRoute(path string) (map[string]userHandler, bson.ObjectId) {
+	parts := strings.Split(strings.TrimPrefix(path, "/users/"), "/")
+	if !bson.IsObjectIdHex(parts[0]) {
+		return nil, ""
+	}
+	id := bson.ObjectIdHex(parts[0])
+	switch {
+	case len(parts) == 1:
+		return userMethods, id
+	case len(parts) == 2 && parts[1] == "restore":
+		return restoreMethods, id
+	case len(parts) == 2 && parts[1] == "revisions":
+		return revisionsMethods, id
+	case len(parts) == 2 && parts[1] == "groups":
+		return userGroupsMethods, id
+	case len(parts) == 4 && parts[1] == "revisions" && parts[3] == "revert":
+		version, err := strconv.Atoi(parts[2])
+		if err != nil || version < 1 {
+			return nil, ""
+		}
+		return revertMethods(version), id
+	}
+	return nil, ""
+}
+
+// AllowedMethods returns the methods served by the route of the request,
+// or nil if no route matches
+func AllowedMethods(r *http.Request) []string {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if methods, ok := collectionRoutes[path]; ok {
+		return allowed(func(m string) bool { _, ok := methods[m]; return ok })
+	}
+	if methods, _ := userRoute(path); methods != nil {
+		return allowed(func(m string) bool { _, ok := methods[m]; return ok })
+	}
+	return nil
+}
+
 // UsersRouter handles requests for the users route
 func UsersRouter(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimSuffix(r.URL.Path, "/")
+	if _, _, ok := codec.Default.Negotiate(r.Header.Get("Accept")); !ok && !streamingRoutes[path] {
+		postError(w, http.StatusNotAcceptable)
+		return
+	}
 
-	if path == "/users" {
-		switch r.Method {
-		case http.MethodGet:
-			usersGetAll(w, r)
-			return
-		case http.MethodHead:
-			usersGetAll(w, r)
-			return
-		case http.MethodPost:
-			usersPostOne(w, r)
-			return
-		case http.MethodOptions:
-			postOptionsResponse(w, []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions}, nil)
+	if methods, ok := collectionRoutes[path]; ok {
+		if r.Method == http.MethodOptions {
+			postOptionsResponse(w, r, AllowedMethods(r), nil)
 			return
-		default:
+		}
+		h, ok := methods[r.Method]
+		if !ok {
 			postError(w, http.StatusMethodNotAllowed)
+			return
 		}
+		h(w, r)
+		return
 	}
-	path = strings.TrimPrefix(path, "/users/")
-	if !bson.IsObjectIdHex(path) {
+	methods, id := userRoute(path)
+	if methods == nil {
 		postError(w, http.StatusNotFound)
 		return
 	}
 
-	id := bson.ObjectIdHex(path)
-
-	switch r.Method {
-	case http.MethodGet:
-		usersGetOne(w, r, id)
+	if r.Method == http.MethodOptions {
+		postOptionsResponse(w, r, AllowedMethods(r), nil)
 		return
-	case http.MethodHead:
-		usersGetOne(w, r, id)
-		return
-	case http.MethodPut:
-		usersPutOne(w, r, id)
-		return
-	case http.MethodPatch:
-		usersPatchOne(w, r, id)
-		return
-	case http.MethodDelete:
-		usersDeleteOne(w, r, id)
-		return
-	case http.MethodOptions:
-		postOptionsResponse(w, []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}, nil)
-		return
-	default:
+	}
+	h, ok := methods[r.Method]
+	if !ok {
 		postError(w, http.StatusMethodNotAllowed)
+		return
 	}
+	h(w, r, id)
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAllowedMethods(t *testing.T) {
+	ts := []struct {
+		path string
+		exp  []string
+	}{
+		{"/users", []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions}},
+		{"/users/", []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions}},
+		{"/users:batch", []string{http.MethodPost, http.MethodOptions}},
+		{"/users/export", []string{http.MethodGet, http.MethodHead, http.MethodOptions}},
+		{"/users/import", []string{http.MethodPost, http.MethodOptions}},
+		{"/users/events", []string{http.MethodGet, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b", []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/restore", []string{http.MethodPost, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/revisions", []string{http.MethodGet, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/groups", []string{http.MethodGet, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/revisions/3/revert", []string{http.MethodPost, http.MethodOptions}},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/revisions/latest/revert", nil},
+		{"/users/5f1a5b3e8f1b2c3d4e5f6a7b/unknown", nil},
+		{"/users/unknown", nil},
+	}
+
+	for _, tc := range ts {
+		got := AllowedMethods(httptest.NewRequest(http.MethodOptions, tc.path, nil))
+		if !reflect.DeepEqual(tc.exp, got) {
+			t.Errorf("Expected methods %v for %s, got %v", tc.exp, tc.path, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/webhook"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webhookDecoding are the rules for subscription request bodies
+var webhookDecoding = decode.Options{
+	ContentTypes: decode.Defaults.ContentTypes,
+	MaxBytes:     decode.Defaults.MaxBytes,
+	ReadOnly:     []string{"id", "created_at"},
+}
+
+// withoutSecret hides the secret of subscriptions once they are created
+func withoutSecret(s webhook.Subscription) webhook.Subscription {
+	s.Secret = ""
+	return s
+}
+
+func webhooksGetAll(w http.ResponseWriter, r *http.Request) {
+	subs, err := webhook.Subscriptions()
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	for i := range subs {
+		subs[i] = withoutSecret(subs[i])
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"webhooks": subs})
+}
+
+func webhooksPostOne(w http.ResponseWriter, r *http.Request) {
+	s := new(webhook.Subscription)
+	if err := decode.JSON(r, s, webhookDecoding); err != nil {
+		postDecodeError(w, r, err)
+		return
+	}
+	err := s.Create()
+	if err != nil {
+		if err == webhook.ErrInvalidSubscription {
+			postError(w, http.StatusBadRequest)
+		} else {
+			postError(w, http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Location", "/webhooks/"+s.ID.Hex())
+	postBodyResponse(w, r, http.StatusCreated, jsonResponse{"webhook": s})
+}
+
+func webhooksGetOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	s, err := webhook.One(id)
+	if err != nil {
+		postError(w, ErrorStatus(err))
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"webhook": withoutSecret(*s)})
+}
+
+func webhooksDeleteOne(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	if err := webhook.Delete(id); err != nil {
+		postError(w, ErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func webhookDeliveries(w http.ResponseWriter, r *http.Request, id bson.ObjectId) {
+	if _, err := webhook.One(id); err != nil {
+		postError(w, ErrorStatus(err))
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusDead:
+	default:
+		postError(w, http.StatusBadRequest)
+		return
+	}
+	ds, err := webhook.Deliveries(id, status)
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	postBodyResponse(w, r, http.StatusOK, jsonResponse{"deliveries": ds})
+}
+
+func webhookRedeliver(w http.ResponseWriter, r *http.Request, id bson.ObjectId, delivery uint64) {
+	ds, err := webhook.Deliveries(id, "")
+	if err != nil {
+		postError(w, http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, d := range ds {
+		found = found || d.ID == delivery
+	}
+	if !found {
+		postError(w, http.StatusNotFound)
+		return
+	}
+	d, err := webhook.Redeliver(delivery)
+	if err != nil {
+		postError(w, ErrorStatus(err))
+		return
+	}
+	postBodyResponse(w, r, http.StatusAccepted, jsonResponse{"delivery": d})
+}
+
+// webhookRoute returns the handlers by method of the webhooks route
+// matching path, or nil if no route matches
+func webhookRoute(path string) map[string]usersHandler {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] != "webhooks" {
+		return nil
+	}
+	if len(parts) == 1 {
+		return map[string]usersHandler{
+			http.MethodGet:  webhooksGetAll,
+			http.MethodPost: webhooksPostOne,
+		}
+	}
+	if !bson.IsObjectIdHex(parts[1]) {
+		return nil
+	}
+	id := bson.ObjectIdHex(parts[1])
+	switch {
+	case len(parts) == 2:
+		return map[string]usersHandler{
+			http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { webhooksGetOne(w, r, id) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { webhooksDeleteOne(w, r, id) },
+		}
+	case len(parts) == 3 && parts[2] == "deliveries":
+		return map[string]usersHandler{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { webhookDeliveries(w, r, id) },
+		}
+	case len(parts) == 5 && parts[2] == "deliveries" && parts[4] == "redeliver":
+		delivery, err := strconv.ParseUint(parts[3], 10, 64)
+		if err != nil {
+			return nil
+		}
+		return map[string]usersHandler{
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { webhookRedeliver(w, r, id, delivery) },
+		}
+	}
+	return nil
+}
+
+// WebhooksAllowedMethods returns the methods served by the webhooks route of
+// the request, or nil if no route matches
+func WebhooksAllowedMethods(r *http.Request) []string {
+	methods := webhookRoute(r.URL.Path)
+	if methods == nil {
+		return nil
+	}
+	return allowed(func(m string) bool { _, ok := methods[m]; return ok })
+}
+
+// WebhooksRouter handles requests for the webhooks route:
+//
+//	GET, POST   /webhooks
+//	GET, DELETE /webhooks/{id}
+//	GET         /webhooks/{id}/deliveries?status=pending|delivered|dead
+//	POST        /webhooks/{id}/deliveries/{delivery}/redeliver
+func WebhooksRouter(w http.ResponseWriter, r *http.Request) {
+	methods := webhookRoute(r.URL.Path)
+	if methods == nil {
+		postError(w, http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodOptions {
+		postOptionsResponse(w, r, WebhooksAllowedMethods(r), nil)
+		return
+	}
+	h, ok := methods[r.Method]
+	if !ok {
+		postError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	h(w, r)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWebhooksAllowedMethods(t *testing.T) {
+	ts := []struct {
+		path string
+		exp  []string
+	}{
+		{"/webhooks", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/webhooks/", []string{http.MethodGet, http.MethodPost, http.MethodOptions}},
+		{"/webhooks/5f1a5b3e8f1b2c3d4e5f6a7b", []string{http.MethodGet, http.MethodDelete, http.MethodOptions}},
+		{"/webhooks/5f1a5b3e8f1b2c3d4e5f6a7b/deliveries", []string{http.MethodGet, http.MethodOptions}},
+		{"/webhooks/5f1a5b3e8f1b2c3d4e5f6a7b/deliveries/12/redeliver", []string{http.MethodPost, http.MethodOptions}},
+		{"/webhooks/5f1a5b3e8f1b2c3d4e5f6a7b/deliveries/x/redeliver", nil},
+		{"/webhooks/unknown", nil},
+	}
+
+	for _, tc := range ts {
+		got := WebhooksAllowedMethods(httptest.NewRequest(http.MethodOptions, tc.path, nil))
+		if !reflect.DeepEqual(tc.exp, got) {
+			t.Errorf("Expected methods %v for %s, got %v", tc.exp, tc.path, got)
+		}
+	}
+}
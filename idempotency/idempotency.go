@@ -0,0 +1,229 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header is the request header carrying the idempotency key
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from the store
+const ReplayedHeader = "Idempotent-Replayed"
+
+// Response is the stored response of the first request made with a key
+type Response struct {
+	Code     int
+	Location string
+	Type     string
+	Body     []byte
+}
+
+// Record is the state of an idempotency key. Response is nil while the first
+// request is still being processed.
+type Record struct {
+	Fingerprint string
+	Response    *Response
+	Expires     time.Time
+}
+
+// Store keeps idempotency records until they expire
+type Store interface {
+	// Reserve claims key for a new request. If the key is already known its
+	// record is returned and ok is false.
+	Reserve(key string, rec Record) (existing Record, ok bool)
+	// Complete stores the response of the request holding key
+	Complete(key string, res Response)
+	// Release forgets key so the request can be retried
+	Release(key string)
+}
+
+// Keys replays the responses of requests carrying the same idempotency key.
+// Requests with a key are buffered to fingerprint them, so their bodies may
+// not be larger than MaxBytes.
+type Keys struct {
+	Store    Store
+	Window   time.Duration
+	MaxBytes int64
+}
+
+// New returns idempotency keys kept in memory for the given window
+func New(window time.Duration) *Keys {
+	return &Keys{
+		Store:    NewMemStore(),
+		Window:   window,
+		MaxBytes: 16 << 20,
+	}
+}
+
+// Fingerprint identifies a request by method, path and body
+func Fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key header
+// idempotent: retries replay the original response, concurrent retries get
+// 409 Conflict and reusing a key with another payload gets 422. Keys are
+// scoped by the verified principal of the request, so that clients cannot
+// replay each other's responses; requests with credentials that cannot be
+// verified are passed on untouched for the handler to reject.
+func (k *Keys) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := auth.Authenticate(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal := "anonymous"
+		if p != nil {
+			principal = p.Key()
+		}
+		var body []byte
+		if r.Body != nil {
+			rd := r.Body
+			if k.MaxBytes > 0 {
+				rd = http.MaxBytesReader(w, r.Body, k.MaxBytes)
+			}
+			bd, err := io.ReadAll(rd)
+			if err != nil {
+				var mbe *http.MaxBytesError
+				if errors.As(err, &mbe) {
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			body = bd
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		key = tenant.FromContext(r.Context()).ID + " " + principal + " " + r.URL.Path + " " + key
+		rec := Record{
+			Fingerprint: Fingerprint(r, body),
+			Expires:     time.Now().Add(k.Window),
+		}
+		existing, ok := k.Store.Reserve(key, rec)
+		if !ok {
+			switch {
+			case existing.Fingerprint != rec.Fingerprint:
+				http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+			case existing.Response == nil:
+				http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+			default:
+				replay(w, existing.Response)
+			}
+			return
+		}
+
+		rw := &recorder{writer: w}
+		next.ServeHTTP(rw, r)
+		if rw.response.Code == 0 || rw.response.Code >= http.StatusInternalServerError {
+			k.Store.Release(key)
+			return
+		}
+		k.Store.Complete(key, rw.response)
+	})
+}
+
+func replay(w http.ResponseWriter, res *Response) {
+	if res.Location != "" {
+		w.Header().Set("Location", res.Location)
+	}
+	if res.Type != "" {
+		w.Header().Set("Content-Type", res.Type)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(res.Code)
+	w.Write(res.Body)
+}
+
+// recorder passes the response through while keeping a copy of it
+type recorder struct {
+	writer   http.ResponseWriter
+	response Response
+}
+
+func (w *recorder) Header() http.Header {
+	return w.writer.Header()
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.response.Code == 0 {
+		w.response.Code = code
+		w.response.Location = w.writer.Header().Get("Location")
+		w.response.Type = w.writer.Header().Get("Content-Type")
+	}
+	w.writer.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.response.Code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.response.Body = append(w.response.Body, b...)
+	return w.writer.Write(b)
+}
+
+type memStore struct {
+	lock    sync.Mutex
+	records map[string]Record
+	swept   time.Time
+}
+
+// sweepInterval is how often expired records are dropped from memory
+const sweepInterval = time.Minute
+
+// NewMemStore returns a store that keeps the records in process memory
+func NewMemStore() Store {
+	return &memStore{records: map[string]Record{}}
+}
+
+func (s *memStore) Reserve(key string, rec Record) (Record, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > sweepInterval {
+		for k, v := range s.records {
+			if now.After(v.Expires) {
+				delete(s.records, k)
+			}
+		}
+		s.swept = now
+	}
+	if existing, ok := s.records[key]; ok && now.Before(existing.Expires) {
+		return existing, false
+	}
+	s.records[key] = rec
+	return rec, true
+}
+
+func (s *memStore) Complete(key string, res Response) {
+	s.lock.Lock()
+	if rec, ok := s.records[key]; ok {
+		rec.Response = &res
+		s.records[key] = rec
+	}
+	s.lock.Unlock()
+}
+
+func (s *memStore) Release(key string) {
+	s.lock.Lock()
+	delete(s.records, key)
+	s.lock.Unlock()
+}
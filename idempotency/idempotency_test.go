@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"bytes"
+	"github.com/christianotieno/go-rest-api/auth"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	created := 0
+	k := New(time.Minute)
+	h := k.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		created++
+		w.Header().Set("Location", "/users/"+strconv.Itoa(created))
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	auth.APIKeys = map[string]string{"s3cret": "billing", "0ther": "crm"}
+	defer func() { auth.APIKeys = map[string]string{} }()
+	k.MaxBytes = 64
+
+	post := func(key, body string, apiKey ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+		if key != "" {
+			r.Header.Set(Header, key)
+		}
+		if len(apiKey) > 0 {
+			r.Header.Set(auth.APIKeyHeader, apiKey[0])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Log("First request")
+	w := post("abc", `{"name":"John"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/1" {
+		t.Errorf("Expected 201 with location /users/1, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	t.Log("Retry is replayed")
+	w = post("abc", `{"name":"John"}`)
+	if created != 1 {
+		t.Errorf("Expected 1 user to be created, got %d", created)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/1" {
+		t.Errorf("Expected replayed 201 with location /users/1, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("Expected %s header to be set", ReplayedHeader)
+	}
+
+	t.Log("Key reused with a different payload")
+	w = post("abc", `{"name":"Jane"}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	t.Log("Requests without a key are not deduplicated")
+	post("", `{"name":"John"}`)
+	post("", `{"name":"John"}`)
+	if created != 3 {
+		t.Errorf("Expected 3 users to be created, got %d", created)
+	}
+
+	t.Log("Keys are scoped by principal")
+	post("def", `{"name":"John"}`, "s3cret")
+	w = post("def", `{"name":"John"}`, "0ther")
+	if created != 5 || w.Header().Get(ReplayedHeader) != "" {
+		t.Errorf("Expected 5 users to be created without replay, got %d", created)
+	}
+
+	t.Log("Body larger than the limit")
+	w = post("ghi", `{"name":"`+strings.Repeat("J", 64)+`"}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	s := NewMemStore()
+	rec := Record{Fingerprint: "a", Expires: time.Now().Add(time.Minute)}
+
+	if _, ok := s.Reserve("key", rec); !ok {
+		t.Fatal("Expected key to be reserved")
+	}
+	existing, ok := s.Reserve("key", rec)
+	if ok || existing.Response != nil {
+		t.Errorf("Expected in-flight record, got %+v", existing)
+	}
+
+	s.Complete("key", Response{Code: http.StatusCreated})
+	existing, _ = s.Reserve("key", rec)
+	if existing.Response == nil || existing.Response.Code != http.StatusCreated {
+		t.Errorf("Expected completed record, got %+v", existing)
+	}
+
+	s.Release("key")
+	if _, ok := s.Reserve("key", rec); !ok {
+		t.Error("Expected released key to be reserved again")
+	}
+
+	expired := Record{Fingerprint: "b", Expires: time.Now().Add(-time.Second)}
+	s.Reserve("old", expired)
+	if _, ok := s.Reserve("old", rec); !ok {
+		t.Error("Expected expired key to be reserved again")
+	}
+}
@@ -1,18 +1,133 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/christianotieno/go-rest-api/audit"
+	"github.com/christianotieno/go-rest-api/auth"
+	"github.com/christianotieno/go-rest-api/authz"
+	"github.com/christianotieno/go-rest-api/backup"
 	"github.com/christianotieno/go-rest-api/handlers"
+	"github.com/christianotieno/go-rest-api/idempotency"
+	"github.com/christianotieno/go-rest-api/openapi"
+	"github.com/christianotieno/go-rest-api/ratelimit"
+	"github.com/christianotieno/go-rest-api/rpc"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/webhook"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
+// trashRetention is how long deleted users can be restored before they are
+// purged
+const trashRetention = 30 * 24 * time.Hour
+
+// Defaults of scheduled backups
+const (
+	backupInterval = 24 * time.Hour
+	backupKeep     = 7
+)
+
+// scheduleBackups snapshots the users database of every tenant to the
+// BACKUP_DIR directory, when it is set, every BACKUP_INTERVAL keeping the
+// BACKUP_KEEP most recent snapshots of each
+func scheduleBackups() {
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		return
+	}
+	interval, err := time.ParseDuration(os.Getenv("BACKUP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = backupInterval
+	}
+	keep, err := strconv.Atoi(os.Getenv("BACKUP_KEEP"))
+	if err != nil || keep < 1 {
+		keep = backupKeep
+	}
+	go backup.Scheduler(context.Background(), handlers.BackupTargets(dir), interval, keep)
+}
+
+// development reports whether API_ENV asks for development mode, in which
+// requests and responses are checked against the API description
+func development() bool {
+	return os.Getenv("API_ENV") == "development"
+}
+
 func main() {
-	http.HandleFunc("/users", handlers.UsersRouter)
-	http.HandleFunc("/users/", handlers.UsersRouter)
+	if len(os.Args) > 1 {
+		os.Exit(command(os.Args[1:], os.Stdout))
+	}
+	if path := os.Getenv("USER_ATTRIBUTE_SCHEMA"); path != "" {
+		schema, err := user.LoadSchema(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		user.AttributeSchema = schema
+	}
+	tenant.Domain = os.Getenv("TENANT_DOMAIN")
+	tenant.TokenSecret = []byte(os.Getenv("TENANT_TOKEN_SECRET"))
+	auth.Username, auth.Password = os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	auth.APIKeys = auth.ParseAPIKeys(os.Getenv("API_KEYS"))
+	if n, err := strconv.Atoi(os.Getenv("USER_REVISIONS")); err == nil {
+		user.MaxRevisions = n
+	}
+	migrateOnStartup()
+
+	limiter := ratelimit.New(
+		ratelimit.Limit{Rate: 10, Burst: 20},
+		ratelimit.Limit{Rate: 2, Burst: 5},
+	)
+	keys := idempotency.New(24 * time.Hour)
+	users := handlers.CORSPolicy().Handler(
+		limiter.Middleware(audit.Middleware(tenant.Middleware(authz.Require(authz.Admin, keys.Middleware(http.HandlerFunc(handlers.UsersRouter)))))),
+		handlers.AllowedMethods,
+	)
+
+	http.Handle("/users", users)
+	http.Handle("/users/", users)
+	http.Handle("/users:batch", users)
+	groups := handlers.CORSPolicy().Handler(
+		limiter.Middleware(audit.Middleware(tenant.Middleware(authz.Require(authz.Admin, http.HandlerFunc(handlers.GroupsRouter))))),
+		handlers.GroupsAllowedMethods,
+	)
+	http.Handle("/groups", groups)
+	http.Handle("/groups/", groups)
+	webhooks := handlers.CORSPolicy().Handler(limiter.Middleware(auth.RequireOperator(http.HandlerFunc(handlers.WebhooksRouter))), handlers.WebhooksAllowedMethods)
+	http.Handle("/webhooks", webhooks)
+	http.Handle("/webhooks/", webhooks)
+	http.Handle("/audit", handlers.CORSPolicy().Handler(limiter.Middleware(auth.RequireOperator(tenant.Middleware(http.HandlerFunc(handlers.AuditHandler)))), func(*http.Request) []string {
+		return []string{http.MethodGet, http.MethodOptions}
+	}))
+	tenants := handlers.CORSPolicy().Handler(limiter.Middleware(auth.RequireOperator(http.HandlerFunc(handlers.TenantsRouter))), handlers.TenantsAllowedMethods)
+	http.Handle("/tenants", tenants)
+	http.Handle("/tenants/", tenants)
+	http.Handle("/admin/backup", limiter.Middleware(auth.RequireOperator(http.HandlerFunc(handlers.BackupHandler))))
+	http.Handle("/openapi.json", handlers.API)
+	http.Handle("/docs", openapi.Docs("/openapi.json"))
 	http.HandleFunc("/", handlers.RootHandler)
 
-	err := http.ListenAndServe("localhost:8080", nil)
+	go webhook.Dispatch(context.Background())
+	go user.Purger(context.Background(), trashRetention, time.Hour)
+	scheduleBackups()
+
+	var api http.Handler = http.DefaultServeMux
+	if development() {
+		api = handlers.API.Validator(nil)(api)
+	}
+	// The RPC service is left out of the validator, which buffers responses
+	// and would hold back its streams. Clients without TLS reach it over
+	// HTTP/2 through h2c, on the same port as the REST API.
+	mux := http.NewServeMux()
+	mux.Handle("/", api)
+	path, service := rpc.Handler()
+	mux.Handle(path, limiter.Middleware(audit.Middleware(tenant.Middleware(service))))
+	err := http.ListenAndServe("localhost:8080", h2c.NewHandler(mux, &http2.Server{}))
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,281 @@
+package migrate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration is a versioned change to the schema of a database. Up and Down
+// run inside a single bbolt transaction; a migration without Down cannot be
+// rolled back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *Tx) error
+	Down    func(tx *Tx) error
+}
+
+// State is a registered migration and whether it is applied
+type State struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// applied is the record of a migration in the metadata bucket
+type applied struct {
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// metaBucket records the applied migrations by version
+const metaBucket = "__migrations"
+
+// Errors used by migrations
+var (
+	// Returns ErrIrreversible when rolling back a migration without Down
+	ErrIrreversible = errors.New("migration cannot be rolled back")
+	// Returns ErrUnknownVersion when the database has a migration that is not registered
+	ErrUnknownVersion = errors.New("database has an unknown migration")
+)
+
+// BackupDir is where databases are copied before migrations are applied.
+// When empty backups are written next to the database.
+var BackupDir = ""
+
+var registry = struct {
+	sync.Mutex
+	migrations map[int]Migration
+}{migrations: map[int]Migration{}}
+
+// Register adds migrations to the registry. It panics if a version is
+// registered twice.
+func Register(ms ...Migration) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, m := range ms {
+		if _, ok := registry.migrations[m.Version]; ok || m.Version < 1 || m.Up == nil {
+			panic(fmt.Sprintf("migrate: invalid or duplicate migration %d", m.Version))
+		}
+		registry.migrations[m.Version] = m
+	}
+}
+
+// Registered returns the registered migrations ordered by version
+func Registered() []Migration {
+	registry.Lock()
+	defer registry.Unlock()
+	ms := make([]Migration, 0, len(registry.migrations))
+	for _, m := range registry.migrations {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
+
+func key(version int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(version))
+	return k
+}
+
+// appliedVersions reads the metadata bucket
+func appliedVersions(tx *bolt.Tx) (map[int]applied, error) {
+	done := map[int]applied{}
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return done, nil
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		a := applied{}
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		done[int(binary.BigEndian.Uint64(k))] = a
+		return nil
+	})
+	return done, err
+}
+
+// Status lists the registered migrations and whether they are applied to
+// the database at path
+func Status(path string) ([]State, error) {
+	db, err := storm.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	var done map[int]applied
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		done, err = appliedVersions(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	states := []State{}
+	for _, m := range Registered() {
+		s := State{Version: m.Version, Name: m.Name}
+		if a, ok := done[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &a.AppliedAt
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Backup copies the database at path from a read transaction and returns
+// the path of the copy
+func Backup(db *storm.DB, path string) (string, error) {
+	dir := BackupDir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s.%s.bak", filepath.Base(path), time.Now().UTC().Format("20060102T150405.000000000"))
+	dst := filepath.Join(dir, name)
+	err := db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(dst, 0o600)
+	})
+	if err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// Up applies the pending migrations to the database at path in version
+// order, each in a transaction of its own, after backing the database up.
+// It returns the applied migrations and the path of the backup, if any.
+func Up(path string) ([]Migration, string, error) {
+	db, err := storm.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer db.Close()
+
+	var done map[int]applied
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		done, err = appliedVersions(tx)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	pending := []Migration{}
+	for _, m := range Registered() {
+		if _, ok := done[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, "", nil
+	}
+
+	backup, err := Backup(db, path)
+	if err != nil {
+		return nil, "", err
+	}
+	ran := []Migration{}
+	for _, m := range pending {
+		err := db.Bolt.Update(func(btx *bolt.Tx) error {
+			if err := m.Up(&Tx{Tx: btx, Node: db.WithTransaction(btx)}); err != nil {
+				return err
+			}
+			b, err := btx.CreateBucketIfNotExists([]byte(metaBucket))
+			if err != nil {
+				return err
+			}
+			v, err := json.Marshal(applied{Name: m.Name, AppliedAt: time.Now().UTC()})
+			if err != nil {
+				return err
+			}
+			return b.Put(key(m.Version), v)
+		})
+		if err != nil {
+			return ran, backup, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, backup, nil
+}
+
+// Down rolls back the last steps applied migrations of the database at path,
+// most recent first, after backing the database up
+func Down(path string, steps int) ([]Migration, string, error) {
+	db, err := storm.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer db.Close()
+
+	var done map[int]applied
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		done, err = appliedVersions(tx)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	versions := []int{}
+	for v := range done {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+	if len(versions) == 0 {
+		return nil, "", nil
+	}
+
+	registered := map[int]Migration{}
+	for _, m := range Registered() {
+		registered[m.Version] = m
+	}
+	for _, v := range versions {
+		m, ok := registered[v]
+		if !ok {
+			return nil, "", fmt.Errorf("migration %d: %w", v, ErrUnknownVersion)
+		}
+		if m.Down == nil {
+			return nil, "", fmt.Errorf("migration %d %s: %w", m.Version, m.Name, ErrIrreversible)
+		}
+	}
+
+	backup, err := Backup(db, path)
+	if err != nil {
+		return nil, "", err
+	}
+	ran := []Migration{}
+	for _, v := range versions {
+		m := registered[v]
+		err := db.Bolt.Update(func(btx *bolt.Tx) error {
+			if err := m.Down(&Tx{Tx: btx, Node: db.WithTransaction(btx)}); err != nil {
+				return err
+			}
+			return btx.Bucket([]byte(metaBucket)).Delete(key(m.Version))
+		})
+		if err != nil {
+			return ran, backup, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, backup, nil
+}
@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const dbPath = "users.db"
+
+func TestMain(m *testing.M) {
+	os.Remove(dbPath)
+	code := m.Run()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+// Person is stored by the test migrations, first as {ID, FullName}
+type Person struct {
+	ID     int    `storm:"id"`
+	Name   string `storm:"index"`
+	Status string
+}
+
+type legacyPerson struct {
+	ID       int
+	FullName string
+}
+
+var errBroken = errors.New("broken")
+
+func reset(ms ...Migration) {
+	registry.Lock()
+	registry.migrations = map[int]Migration{}
+	registry.Unlock()
+	Register(ms...)
+}
+
+func TestUpDownStatus(t *testing.T) {
+	os.Remove(dbPath)
+	BackupDir = t.TempDir()
+
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	for i, name := range []string{"Ann", "Bob"} {
+		if err := db.From().Set("Person", i+1, legacyPerson{ID: i + 1, FullName: name}); err != nil {
+			t.Fatalf("Error saving a legacy record: %s", err)
+		}
+	}
+	db.Close()
+
+	reset(
+		Migration{
+			Version: 1,
+			Name:    "rename full name",
+			Up:      func(tx *Tx) error { return tx.RenameField("Person", "FullName", "Name") },
+			Down:    func(tx *Tx) error { return tx.RenameField("Person", "Name", "FullName") },
+		},
+		Migration{
+			Version: 2,
+			Name:    "backfill status",
+			Up:      func(tx *Tx) error { return tx.Backfill("Person", "Status", "active") },
+		},
+		Migration{
+			Version: 3,
+			Name:    "index names",
+			Up:      func(tx *Tx) error { return tx.Reindex(new(Person)) },
+			Down:    func(tx *Tx) error { return nil },
+		},
+	)
+
+	ran, backup, err := Up(dbPath)
+	if err != nil {
+		t.Fatalf("Error migrating up: %s", err)
+	}
+	if len(ran) != 3 {
+		t.Errorf("Expected 3 migrations to run, got %d", len(ran))
+	}
+	if _, err := os.Stat(backup); err != nil || filepath.Dir(backup) != BackupDir {
+		t.Errorf("Expected a backup in %s, got %s: %v", BackupDir, backup, err)
+	}
+
+	db, err = storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	p := new(Person)
+	if err := db.One("Name", "Bob", p); err != nil {
+		t.Errorf("Error finding a migrated record by index: %s", err)
+	}
+	if p.Status != "active" {
+		t.Errorf("Expected status active, got %q", p.Status)
+	}
+	db.Close()
+
+	if ran, _, _ := Up(dbPath); len(ran) != 0 {
+		t.Errorf("Expected nothing to run twice, got %d migrations", len(ran))
+	}
+
+	t.Log("Down")
+	ran, _, err = Down(dbPath, 1)
+	if err != nil || len(ran) != 1 || ran[0].Version != 3 {
+		t.Fatalf("Expected migration 3 to be rolled back, got %v, %v", ran, err)
+	}
+	if _, _, err := Down(dbPath, 1); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Expected error %v, got %v", ErrIrreversible, err)
+	}
+
+	states, err := Status(dbPath)
+	if err != nil {
+		t.Fatalf("Error reading the status: %s", err)
+	}
+	expect := []bool{true, true, false}
+	for i, s := range states {
+		if s.Applied != expect[i] {
+			t.Errorf("Expected migration %d applied %v, got %v", s.Version, expect[i], s.Applied)
+		}
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	os.Remove(dbPath)
+	BackupDir = t.TempDir()
+
+	reset(
+		Migration{
+			Version: 1,
+			Name:    "backfill then fail",
+			Up: func(tx *Tx) error {
+				if err := tx.Node.Set("Person", 1, legacyPerson{ID: 1}); err != nil {
+					return err
+				}
+				return errBroken
+			},
+		},
+	)
+	if _, _, err := Up(dbPath); !errors.Is(err, errBroken) {
+		t.Fatalf("Expected error %v, got %v", errBroken, err)
+	}
+	if states, err := Status(dbPath); err != nil || len(states) != 1 || states[0].Applied {
+		t.Errorf("Expected the failed migration to be pending, got %+v (%v)", states, err)
+	}
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	defer db.Close()
+	var lp legacyPerson
+	if err := db.Get("Person", 1, &lp); err != storm.ErrNotFound {
+		t.Errorf("Expected the failed migration to be rolled back, got %v", err)
+	}
+}
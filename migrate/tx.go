@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"encoding/json"
+	"github.com/asdine/storm/v3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Tx gives a migration access to the raw buckets and to storm within the
+// same bbolt transaction
+type Tx struct {
+	*bolt.Tx
+	Node storm.Node
+}
+
+// Record is a stored value decoded into its top-level JSON fields
+type Record map[string]json.RawMessage
+
+// Update calls fn for every record of the storm bucket, which is named
+// after the stored type, and saves the records fn reports as changed.
+// Index entries are not updated; use Reindex afterwards when indexed fields
+// change.
+func (tx *Tx) Update(bucket string, fn func(rec Record) (bool, error)) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	type change struct {
+		key []byte
+		val []byte
+	}
+	changes := []change{}
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			// nested buckets hold storm indexes and metadata
+			return nil
+		}
+		rec := Record{}
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return err
+		}
+		changed, err := fn(rec)
+		if err != nil || !changed {
+			return err
+		}
+		bd, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{append([]byte(nil), k...), bd})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := b.Put(c.key, c.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameField renames a field of every record of the bucket. Records that
+// already have the new field keep its value.
+func (tx *Tx) RenameField(bucket, from, to string) error {
+	return tx.Update(bucket, func(rec Record) (bool, error) {
+		v, ok := rec[from]
+		if !ok {
+			return false, nil
+		}
+		delete(rec, from)
+		if _, exists := rec[to]; !exists {
+			rec[to] = v
+		}
+		return true, nil
+	})
+}
+
+// Backfill sets a field of every record of the bucket that lacks it or
+// holds null or an empty string
+func (tx *Tx) Backfill(bucket, field string, value interface{}) error {
+	bd, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return tx.Update(bucket, func(rec Record) (bool, error) {
+		if v, ok := rec[field]; ok && string(v) != "null" && string(v) != `""` {
+			return false, nil
+		}
+		rec[field] = bd
+		return true, nil
+	})
+}
+
+// Reindex rebuilds the storm indexes of the type of data, a pointer to a
+// struct
+func (tx *Tx) Reindex(data interface{}) error {
+	err := tx.Node.ReIndex(data)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
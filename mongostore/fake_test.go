@@ -0,0 +1,191 @@
+package mongostore
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// fakeCollection is an in-memory collection that behaves like MongoDB for
+// the filters and updates the store uses: equality, nil matching missing
+// fields, $set and $inc, and unique sparse indexes
+type fakeCollection struct {
+	lock    sync.Mutex
+	docs    []bson.M
+	indexes []index
+}
+
+func (c *fakeCollection) EnsureIndex(ctx context.Context, idx index) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.indexes = append(c.indexes, idx)
+	return nil
+}
+
+func (c *fakeCollection) One(ctx context.Context, filter bson.M, result interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			return convert(doc, result)
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (c *fakeCollection) All(ctx context.Context, filter bson.M, key string, result interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	found := []bson.M{}
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			found = append(found, doc)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return fmt.Sprint(found[i][key]) < fmt.Sprint(found[j][key])
+	})
+	slice := reflect.ValueOf(result).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(found)))
+	for _, doc := range found {
+		elem := reflect.New(slice.Type().Elem())
+		if err := convert(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+	return nil
+}
+
+func (c *fakeCollection) Insert(ctx context.Context, v interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	doc := bson.M{}
+	if err := convert(v, &doc); err != nil {
+		return err
+	}
+	if err := c.unique(doc, -1); err != nil {
+		return err
+	}
+	c.docs = append(c.docs, doc)
+	return nil
+}
+
+func (c *fakeCollection) Replace(ctx context.Context, filter bson.M, v interface{}) error {
+	return c.change(filter, func(doc bson.M) (bson.M, error) {
+		replacement := bson.M{}
+		if err := convert(v, &replacement); err != nil {
+			return nil, err
+		}
+		replacement["_id"] = doc["_id"]
+		return replacement, nil
+	})
+}
+
+func (c *fakeCollection) Update(ctx context.Context, filter, update bson.M) error {
+	return c.change(filter, func(doc bson.M) (bson.M, error) {
+		return apply(doc, update)
+	})
+}
+
+// change replaces the first document matching filter with the one returned
+// by fn
+func (c *fakeCollection) change(filter bson.M, fn func(bson.M) (bson.M, error)) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, doc := range c.docs {
+		if !matches(doc, filter) {
+			continue
+		}
+		updated, err := fn(doc)
+		if err != nil {
+			return err
+		}
+		if err := c.unique(updated, i); err != nil {
+			return err
+		}
+		c.docs[i] = updated
+		return nil
+	}
+	return mongo.ErrNoDocuments
+}
+
+// unique checks doc against the unique indexes and the ids of the other
+// documents than the one at index self
+func (c *fakeCollection) unique(doc bson.M, self int) error {
+	indexes := append([]index{{Name: "_id_", Key: "_id", Unique: true}}, c.indexes...)
+	for _, idx := range indexes {
+		value, ok := doc[idx.Key]
+		if !idx.Unique || !ok && idx.Sparse {
+			continue
+		}
+		for i, other := range c.docs {
+			if i != self && reflect.DeepEqual(other[idx.Key], value) {
+				return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+					Code:    11000,
+					Message: fmt.Sprintf("E11000 duplicate key error collection: test.users index: %s dup key: %v", idx.Name, value),
+				}}}
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether doc has the fields of filter, nil matching
+// missing fields
+func matches(doc, filter bson.M) bool {
+	for k, v := range filter {
+		if v == nil {
+			if doc[k] != nil {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(doc[k], normalize(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns doc after $set and $inc operators
+func apply(doc, update bson.M) (bson.M, error) {
+	updated := bson.M{}
+	for k, v := range doc {
+		updated[k] = v
+	}
+	for op, fields := range update {
+		for k, v := range fields.(bson.M) {
+			switch op {
+			case "$set":
+				updated[k] = normalize(v)
+			case "$inc":
+				updated[k] = updated[k].(int32) + normalize(v).(int32)
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", op)
+			}
+		}
+	}
+	return updated, nil
+}
+
+// convert copies v into result through BSON, as a round trip to the
+// server would
+func convert(v, result interface{}) error {
+	bd, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(bd, result)
+}
+
+// normalize returns v as it reads back from BSON
+func normalize(v interface{}) interface{} {
+	doc := bson.M{}
+	convert(bson.M{"v": v}, &doc)
+	return doc["v"]
+}
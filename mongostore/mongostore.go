@@ -0,0 +1,327 @@
+// Package mongostore keeps users in a MongoDB collection, with their
+// ObjectIds as document ids. It talks to the server with the official
+// driver, which supports the servers from MongoDB 3.6 on, 6.0 and later
+// included.
+//
+// Like sqlstore, it implements user.Store, the CRUD subset of the user
+// package: events, the trash, groups and tenants are only kept by the storm
+// backend, so the servers are not wired to this store.
+package mongostore
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	mgobson "gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+)
+
+// Collection is the name of the collection holding users
+const Collection = "users"
+
+// index is an ascending index on a single field
+type index struct {
+	Name   string
+	Key    string
+	Unique bool
+	Sparse bool
+}
+
+// emailIndex is the unique index of emails. It is sparse since users
+// without an email have no email field.
+var emailIndex = index{Name: "users_email", Key: "email", Unique: true, Sparse: true}
+
+// indexes are the indexes of the collection
+var indexes = []index{
+	emailIndex,
+	{Name: "users_name", Key: "name"},
+	{Name: "users_role", Key: "role"},
+	{Name: "users_status", Key: "status"},
+	{Name: "users_deleted_at", Key: "deleted_at"},
+}
+
+// collection is the part of a MongoDB collection the store uses. One,
+// Replace and Update return mongo.ErrNoDocuments when no document matches
+// filter.
+type collection interface {
+	One(ctx context.Context, filter bson.M, result interface{}) error
+	All(ctx context.Context, filter bson.M, sort string, result interface{}) error
+	Insert(ctx context.Context, doc interface{}) error
+	Replace(ctx context.Context, filter bson.M, doc interface{}) error
+	Update(ctx context.Context, filter, update bson.M) error
+	EnsureIndex(ctx context.Context, idx index) error
+}
+
+// Store keeps users in MongoDB with the semantics of user.Store. MongoDB
+// has no transactions here: concurrent saves are serialized on the user
+// version instead.
+type Store struct {
+	client *mongo.Client
+	users  collection
+}
+
+var _ user.Store = (*Store)(nil)
+
+// Open connects to the MongoDB server at url, such as
+// mongodb://localhost/app, and ensures the indexes of the users collection
+// of its database
+func Open(url string) (*Store, error) {
+	cs, err := connstring.ParseAndValidate(url)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		return nil, err
+	}
+	s, err := newStore(ctx, driverCollection{client.Database(cs.Database).Collection(Collection)})
+	if err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	s.client = client
+	return s, nil
+}
+
+func newStore(ctx context.Context, c collection) (*Store, error) {
+	for _, idx := range indexes {
+		if err := c.EnsureIndex(ctx, idx); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{users: c}, nil
+}
+
+// Close closes the connection to the server
+func (s *Store) Close() {
+	if s.client != nil {
+		s.client.Disconnect(context.Background())
+	}
+}
+
+// document is a user as stored in MongoDB
+type document struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Name       string             `bson:"name"`
+	Role       string             `bson:"role"`
+	Email      string             `bson:"email,omitempty"`
+	Status     string             `bson:"status"`
+	Attributes bson.M             `bson:"attributes,omitempty"`
+	Version    int                `bson:"version"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at"`
+	DeletedAt  *time.Time         `bson:"deleted_at,omitempty"`
+}
+
+// objectID is the driver ObjectId of a user id
+func objectID(id mgobson.ObjectId) primitive.ObjectID {
+	var oid primitive.ObjectID
+	copy(oid[:], id)
+	return oid
+}
+
+func fromUser(u *user.User) *document {
+	return &document{
+		ID:         objectID(u.ID),
+		Name:       u.Name,
+		Role:       u.Role,
+		Email:      u.Email,
+		Status:     u.Status,
+		Attributes: u.Attributes,
+		Version:    u.Version,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+		DeletedAt:  u.DeletedAt,
+	}
+}
+
+// user converts the document, decoding its attributes as JSON does, like
+// the storm backend
+func (d *document) user() (*user.User, error) {
+	u := &user.User{
+		ID:        mgobson.ObjectId(d.ID[:]),
+		Name:      d.Name,
+		Role:      d.Role,
+		Email:     d.Email,
+		Status:    d.Status,
+		Version:   d.Version,
+		CreatedAt: d.CreatedAt.UTC(),
+		UpdatedAt: d.UpdatedAt.UTC(),
+	}
+	if d.DeletedAt != nil {
+		t := d.DeletedAt.UTC()
+		u.DeletedAt = &t
+	}
+	if len(d.Attributes) > 0 {
+		bd, err := json.Marshal(d.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(bd, &u.Attributes); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// notFound maps mongo.ErrNoDocuments to storm.ErrNotFound, the error of
+// every user backend
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return storm.ErrNotFound
+	}
+	return err
+}
+
+// All retrieves all users except deleted ones, ordered by ID
+func (s *Store) All(ctx context.Context) ([]user.User, error) {
+	docs := []document{}
+	if err := s.users.All(ctx, bson.M{"deleted_at": nil}, "_id", &docs); err != nil {
+		return nil, err
+	}
+	users := make([]user.User, len(docs))
+	for i := range docs {
+		u, err := docs[i].user()
+		if err != nil {
+			return nil, err
+		}
+		users[i] = *u
+	}
+	return users, nil
+}
+
+// One returns a single user. Deleted users are reported as not found.
+func (s *Store) One(ctx context.Context, id mgobson.ObjectId) (*user.User, error) {
+	doc := new(document)
+	if err := s.users.One(ctx, bson.M{"_id": objectID(id), "deleted_at": nil}, doc); err != nil {
+		return nil, notFound(err)
+	}
+	return doc.user()
+}
+
+// Save updates or creates a given user. A save racing with another change
+// of the same user is retried against the new version.
+func (s *Store) Save(ctx context.Context, u *user.User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := now()
+		before := new(document)
+		err := s.users.One(ctx, bson.M{"_id": objectID(u.ID)}, before)
+		found := err == nil
+		switch {
+		case found:
+			if before.DeletedAt != nil {
+				return user.ErrDeleted
+			}
+			u.Version = before.Version + 1
+			u.CreatedAt = before.CreatedAt.UTC()
+		case err == mongo.ErrNoDocuments:
+			u.Version = 1
+			if u.CreatedAt.IsZero() {
+				u.CreatedAt = now
+			}
+			u.CreatedAt = u.CreatedAt.UTC().Truncate(time.Millisecond)
+		default:
+			return err
+		}
+		u.UpdatedAt = now
+		u.DeletedAt = nil
+
+		if found {
+			err = s.users.Replace(ctx, bson.M{"_id": objectID(u.ID), "version": before.Version}, fromUser(u))
+		} else {
+			err = s.users.Insert(ctx, fromUser(u))
+		}
+		switch {
+		case err == nil:
+			return nil
+		case found && err == mongo.ErrNoDocuments, !found && mongo.IsDuplicateKeyError(err) && !taken(err):
+			// changed or created in the meantime
+			continue
+		case mongo.IsDuplicateKeyError(err):
+			return &user.ConflictError{Field: "email", Value: u.Email}
+		}
+		return err
+	}
+}
+
+// taken reports whether a duplicate key error is about the email index
+func taken(err error) bool {
+	return strings.Contains(err.Error(), emailIndex.Name)
+}
+
+// Delete moves a given user to the trash
+func (s *Store) Delete(ctx context.Context, id mgobson.ObjectId) error {
+	now := now()
+	err := s.users.Update(ctx, bson.M{"_id": objectID(id), "deleted_at": nil}, bson.M{
+		"$set": bson.M{"updated_at": now, "deleted_at": now},
+		"$inc": bson.M{"version": 1},
+	})
+	return notFound(err)
+}
+
+// now is the current time at the millisecond precision of BSON, so that
+// saved users equal the ones read back
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// driverCollection is a collection of a MongoDB server
+type driverCollection struct {
+	*mongo.Collection
+}
+
+func (c driverCollection) One(ctx context.Context, filter bson.M, result interface{}) error {
+	return c.FindOne(ctx, filter).Decode(result)
+}
+
+func (c driverCollection) All(ctx context.Context, filter bson.M, sort string, result interface{}) error {
+	cursor, err := c.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: sort, Value: 1}}))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, result)
+}
+
+func (c driverCollection) Insert(ctx context.Context, doc interface{}) error {
+	_, err := c.InsertOne(ctx, doc)
+	return err
+}
+
+func (c driverCollection) Replace(ctx context.Context, filter bson.M, doc interface{}) error {
+	res, err := c.ReplaceOne(ctx, filter, doc)
+	if err == nil && res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func (c driverCollection) Update(ctx context.Context, filter, update bson.M) error {
+	res, err := c.UpdateOne(ctx, filter, update)
+	if err == nil && res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func (c driverCollection) EnsureIndex(ctx context.Context, idx index) error {
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: idx.Key, Value: 1}},
+		Options: options.Index().SetName(idx.Name).SetUnique(idx.Unique).SetSparse(idx.Sparse),
+	})
+	return err
+}
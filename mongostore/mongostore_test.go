@@ -0,0 +1,236 @@
+package mongostore
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/user/storetest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	mgobson "gopkg.in/mgo.v2/bson"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) user.Store {
+		s, err := newStore(context.Background(), new(fakeCollection))
+		if err != nil {
+			t.Fatalf("Error opening the store: %s", err)
+		}
+		return s
+	})
+}
+
+func TestConcurrentSaves(t *testing.T) {
+	s, err := newStore(context.Background(), new(fakeCollection))
+	if err != nil {
+		t.Fatalf("Error opening the store: %s", err)
+	}
+	ctx := context.Background()
+	id := mgobson.NewObjectId()
+	if err := s.Save(ctx, &user.User{ID: id, Name: "Ann"}); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+
+	const saves = 20
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Save(ctx, &user.User{ID: id, Name: "Ann"}); err != nil {
+				t.Errorf("Error saving a user: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	u, err := s.One(ctx, id)
+	if err != nil {
+		t.Fatalf("Error retrieving a user: %s", err)
+	}
+	if u.Version != saves+1 {
+		t.Errorf("Expected version %d, got %d", saves+1, u.Version)
+	}
+}
+
+// TestMongo runs against the database of MONGO_TEST_URL, such as
+// mongodb://localhost/users_test, whose users collection is dropped
+func TestMongo(t *testing.T) {
+	url := os.Getenv("MONGO_TEST_URL")
+	if url == "" {
+		t.Skip("MONGO_TEST_URL is not set")
+	}
+	storetest.Run(t, func(t *testing.T) user.Store {
+		s, err := Open(url)
+		if err != nil {
+			t.Fatalf("Error opening the store: %s", err)
+		}
+		t.Cleanup(func() {
+			s.users.(driverCollection).Drop(context.Background())
+			s.Close()
+		})
+		return s
+	})
+}
+
+// TestDriver runs the store on the driver adapter against the mock
+// deployment of the driver, which answers commands with the given server
+// replies
+func TestDriver(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ctx := context.Background()
+	id := mgobson.NewObjectId()
+	created := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	mt.Run("Indexes", func(mt *mtest.T) {
+		for range indexes {
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+		}
+		if _, err := newStore(ctx, driverCollection{mt.Coll}); err != nil {
+			mt.Fatalf("Error opening the store: %s", err)
+		}
+		cmd := mt.GetStartedEvent().Command
+		idx := cmd.Lookup("indexes").Array().Index(0).Value().Document()
+		if name := idx.Lookup("name").StringValue(); name != emailIndex.Name {
+			mt.Errorf("Expected index %s, got %s", emailIndex.Name, name)
+		}
+		if !idx.Lookup("unique").Boolean() || !idx.Lookup("sparse").Boolean() {
+			mt.Errorf("Expected a unique sparse index, got %s", idx)
+		}
+	})
+
+	mt.Run("One", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: objectID(id)},
+			{Key: "name", Value: "Ann"},
+			{Key: "role", Value: "admin"},
+			{Key: "status", Value: "active"},
+			{Key: "attributes", Value: bson.D{{Key: "team", Value: bson.D{{Key: "size", Value: int32(3)}}}}},
+			{Key: "version", Value: int32(2)},
+			{Key: "created_at", Value: primitive.NewDateTimeFromTime(created)},
+			{Key: "updated_at", Value: primitive.NewDateTimeFromTime(created)},
+		}))
+		s := &Store{users: driverCollection{mt.Coll}}
+		u, err := s.One(ctx, id)
+		if err != nil {
+			mt.Fatalf("Error retrieving a user: %s", err)
+		}
+		exp := &user.User{
+			ID:         id,
+			Name:       "Ann",
+			Role:       "admin",
+			Status:     "active",
+			Attributes: map[string]interface{}{"team": map[string]interface{}{"size": float64(3)}},
+			Version:    2,
+			CreatedAt:  created,
+			UpdatedAt:  created,
+		}
+		if !reflect.DeepEqual(u, exp) {
+			mt.Errorf("Expected %+v, got %+v", exp, u)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if oid := filter.Lookup("_id").ObjectID(); oid != objectID(id) {
+			mt.Errorf("Expected _id %s, got %s", objectID(id), oid)
+		}
+		if v := filter.Lookup("deleted_at"); v.Type != bsontype.Null {
+			mt.Errorf("Expected a null deleted_at, got %s", v)
+		}
+	})
+
+	mt.Run("One of a missing user", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch))
+		s := &Store{users: driverCollection{mt.Coll}}
+		if _, err := s.One(ctx, id); err != storm.ErrNotFound {
+			mt.Errorf("Expected %v, got %v", storm.ErrNotFound, err)
+		}
+	})
+
+	mt.Run("All", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "test.users", mtest.FirstBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Ann"}}),
+			mtest.CreateCursorResponse(0, "test.users", mtest.NextBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "name", Value: "Bob"}}),
+		)
+		s := &Store{users: driverCollection{mt.Coll}}
+		users, err := s.All(ctx)
+		if err != nil {
+			mt.Fatalf("Error retrieving users: %s", err)
+		}
+		if len(users) != 2 || users[0].Name != "Ann" || users[1].Name != "Bob" {
+			mt.Errorf("Expected Ann and Bob, got %+v", users)
+		}
+		sort := mt.GetStartedEvent().Command.Lookup("sort").Document()
+		if v := sort.Lookup("_id").Int32(); v != 1 {
+			mt.Errorf("Expected ascending ids, got %s", sort)
+		}
+	})
+
+	mt.Run("Save of a new user", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		s := &Store{users: driverCollection{mt.Coll}}
+		if err := s.Save(ctx, &user.User{ID: id, Name: "Ann"}); err != nil {
+			mt.Fatalf("Error saving a user: %s", err)
+		}
+		mt.GetStartedEvent()
+		doc := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if v := doc.Lookup("version").Int32(); v != 1 {
+			mt.Errorf("Expected version 1, got %d", v)
+		}
+		if _, err := doc.LookupErr("email"); err == nil {
+			mt.Errorf("Expected no email field, got %s", doc)
+		}
+	})
+
+	mt.Run("Save of a taken email", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch),
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "E11000 duplicate key error collection: test.users index: users_email dup key: { email: \"ann@example.com\" }"}),
+		)
+		s := &Store{users: driverCollection{mt.Coll}}
+		err := s.Save(ctx, &user.User{ID: id, Name: "Ann", Email: "ann@example.com"})
+		if _, ok := err.(*user.ConflictError); !ok {
+			mt.Errorf("Expected a conflict, got %v", err)
+		}
+	})
+
+	mt.Run("Save racing with another one", func(mt *mtest.T) {
+		current := bson.D{
+			{Key: "_id", Value: objectID(id)},
+			{Key: "name", Value: "Ann"},
+			{Key: "version", Value: int32(1)},
+			{Key: "created_at", Value: primitive.NewDateTimeFromTime(created)},
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, current),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateCursorResponse(0, "test.users", mtest.FirstBatch, append(current[:2:2], bson.E{Key: "version", Value: int32(2)}, current[3])),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		s := &Store{users: driverCollection{mt.Coll}}
+		u := &user.User{ID: id, Name: "Anna"}
+		if err := s.Save(ctx, u); err != nil {
+			mt.Fatalf("Error saving a user: %s", err)
+		}
+		if u.Version != 3 {
+			mt.Errorf("Expected version 3, got %d", u.Version)
+		}
+	})
+
+	mt.Run("Delete of a missing user", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+		s := &Store{users: driverCollection{mt.Coll}}
+		if err := s.Delete(ctx, id); err != storm.ErrNotFound {
+			mt.Errorf("Expected %v, got %v", storm.ErrNotFound, err)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Version is the version of the OpenAPI specification documents follow
+const Version = "3.1.0"
+
+// Document is an OpenAPI description of an API
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower-case method
+type PathItem map[string]*Operation
+
+// Operation is a single method of a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Security lists the alternative ways to authenticate, an empty
+	// requirement making credentials optional
+	Security []SecurityRequirement `json:"security,omitempty"`
+}
+
+// SecurityRequirement names the security schemes that together
+// authenticate a request
+type SecurityRequirement map[string][]string
+
+// SecurityScheme is a way requests authenticate
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+}
+
+// Parameter is a path, query or header parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of requests by media type
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response status and its body by media type
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a representation
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Components holds the schemas and security schemes referenced by the
+// document
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// Locations of parameters
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// Types of security schemes
+const (
+	HTTP   = "http"
+	APIKey = "apiKey"
+)
+
+// SecurityScheme registers a security scheme under name and returns the
+// requirement of it alone
+func (d *Document) SecurityScheme(name string, s *SecurityScheme) SecurityRequirement {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = map[string]*SecurityScheme{}
+	}
+	d.Components.SecuritySchemes[name] = s
+	return SecurityRequirement{name: []string{}}
+}
+
+// New returns an empty document describing the API title at version
+func New(title, version string) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Version: version},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+}
+
+// Add describes the method of path, a template such as /users/{id}. The
+// operation id defaults to the method and path.
+func (d *Document) Add(method, path string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	if op.OperationID == "" {
+		op.OperationID = strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "", ":", "_", ".", "_").Replace(path)
+	}
+	if op.Responses == nil {
+		op.Responses = map[string]*Response{}
+	}
+	(*item)[strings.ToLower(method)] = op
+}
+
+// Operations lists the method and path of every operation, sorted by path
+func (d *Document) Operations() [][2]string {
+	ops := [][2]string{}
+	for path, item := range d.Paths {
+		for method := range *item {
+			ops = append(ops, [2]string{strings.ToUpper(method), path})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i][1] != ops[j][1] {
+			return ops[i][1] < ops[j][1]
+		}
+		return ops[i][0] < ops[j][0]
+	})
+	return ops
+}
+
+// ServeHTTP serves the document as JSON
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET,HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	d.once.Do(func() { d.body, d.err = json.MarshalIndent(d, "", "  ") })
+	if d.err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(d.body)
+}
+
+// match returns the operation describing the request along with its path
+// parameters, or nil if none does
+func (d *Document) match(r *http.Request) (*Operation, map[string]string) {
+	path := r.URL.Path
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	segments := strings.Split(path, "/")
+	for template, item := range d.Paths {
+		op, ok := (*item)[strings.ToLower(r.Method)]
+		if !ok {
+			continue
+		}
+		params, ok := d.matchPath(template, segments, op)
+		if ok {
+			return op, params
+		}
+	}
+	return nil, nil
+}
+
+// matchPath reports whether the path segments match the template, with
+// the path parameters valid against their schemas
+func (d *Document) matchPath(template string, segments []string, op *Operation) (map[string]string, bool) {
+	parts := strings.Split(template, "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[part[1:len(part)-1]] = segments[i]
+			continue
+		}
+		if part != segments[i] {
+			return nil, false
+		}
+	}
+	for _, p := range op.Parameters {
+		if p.In != InPath {
+			continue
+		}
+		v, err := coerce(p.Schema, params[p.Name])
+		if err != nil || len(d.validate(p.Schema, v, p.Name)) > 0 {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,188 @@
+package openapi
+
+import (
+	"encoding/json"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `json:"city"`
+}
+
+type person struct {
+	ID      bson.ObjectId     `json:"id"`
+	Name    string            `json:"name"`
+	Age     int               `json:"age,omitempty"`
+	Tags    []string          `json:"tags"`
+	Born    time.Time         `json:"born"`
+	Home    *address          `json:"home,omitempty"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	private string
+	Ignored string `json:"-"`
+}
+
+func TestSchema(t *testing.T) {
+	d := New("Test", "1")
+	ref := d.Schema("Person", person{})
+	if ref.Ref != "#/components/schemas/Person" {
+		t.Errorf("Expected a reference to the component, got %q", ref.Ref)
+	}
+	s := d.Resolve(ref)
+	if exp := []string{"id", "name", "tags", "born"}; !reflect.DeepEqual(s.Required, exp) {
+		t.Errorf("Expected required fields %v, got %v", exp, s.Required)
+	}
+	if _, ok := s.Properties["Ignored"]; ok || len(s.Properties) != 7 {
+		t.Errorf("Expected only the JSON fields, got %v", s.Properties)
+	}
+	if exp := []string{Array, Null}; !reflect.DeepEqual(s.Properties["tags"].Type, exp) {
+		t.Errorf("Expected nil slices to be nullable, got %v", s.Properties["tags"].Type)
+	}
+	if s.Properties["born"].Format != "date-time" || s.Properties["id"] != ObjectID {
+		t.Errorf("Expected times and object ids to be strings, got %v and %v", s.Properties["born"], s.Properties["id"])
+	}
+	if s.Properties["home"].Ref != "#/components/schemas/address" || d.Components.Schemas["address"] == nil {
+		t.Errorf("Expected nested structs to be components, got %v", s.Properties["home"])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d := New("Test", "1")
+	ref := d.Schema("Person", person{})
+	testCases := []struct {
+		txt   string
+		s     *Schema
+		value string
+		exp   []string
+	}{
+		{"Valid objects", ref, `{"id": "5f1a5b3e8f1b2c3d4e5f6a7b", "name": "Ann", "tags": null, "born": "2020-01-02T03:04:05Z", "home": {"city": "Oslo"}}`, []string{}},
+		{"Missing fields", ref, `{"name": "Ann"}`, []string{"id: is required", "tags: is required", "born: is required"}},
+		{"Wrong types", ref, `{"id": "5f1a5b3e8f1b2c3d4e5f6a7b", "name": 1, "age": 1.5, "tags": [1], "born": "2020-01-02T03:04:05Z"}`, []string{"age: expected integer, got number", "name: expected string, got integer", "tags.0: expected string, got integer"}},
+		{"Patterns and formats", ref, `{"id": "x", "name": "Ann", "tags": [], "born": "yesterday"}`, []string{"born: is not a date-time", "id: does not match ^[0-9a-f]{24}$"}},
+		{"Nested components", ref, `{"id": "5f1a5b3e8f1b2c3d4e5f6a7b", "name": "Ann", "tags": [], "born": "2020-01-02T03:04:05Z", "home": {}}`, []string{"home.city: is required"}},
+		{"Enums", Of(String, "a", "b"), `"c"`, []string{"c is not one of [a b]"}},
+		{"Ranges", Between(Of(Integer), 1, 10), `11`, []string{"is greater than 10"}},
+		{"Integers are numbers", Of(Number), `1`, []string{}},
+		{"One of the alternatives", OneOf(Of(Object), ArrayOf(Of(Object))), `[{}]`, []string{}},
+		{"None of the alternatives", OneOf(Of(Object), ArrayOf(Of(Object))), `"x"`, []string{"matches 0 of 2 alternatives instead of one"}},
+		{"Maps", MapOf(Of(Boolean)), `{"a": true, "b": "no"}`, []string{"b: expected boolean, got string"}},
+		{"Any value", &Schema{}, `[1, "a"]`, []string{}},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		var v interface{}
+		if err := json.Unmarshal([]byte(tc.value), &v); err != nil {
+			t.Fatalf("Error decoding %s: %s", tc.value, err)
+		}
+		got := []string{}
+		for _, violation := range d.Validate(tc.s, v) {
+			got = append(got, violation.Error())
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("Expected violations %v, got %v", tc.exp, got)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	d := New("Test", "1")
+	thing := d.Component("Thing", ObjectOf(map[string]*Schema{"name": Of(String)}, "name"))
+	id := Parameter{Name: "id", In: InPath, Required: true, Schema: ObjectID}
+	d.Add(http.MethodGet, "/things/{id}", &Operation{
+		Parameters: []Parameter{id, {Name: "limit", In: InQuery, Schema: Between(Of(Integer), 1, 10)}},
+		Responses:  map[string]*Response{"200": {Description: "The thing", Content: map[string]MediaType{"application/json": {Schema: thing}}}},
+	})
+	d.Add(http.MethodPut, "/things/{id}", &Operation{
+		Parameters:  []Parameter{id},
+		RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: thing}}},
+		Responses:   map[string]*Response{"204": {Description: "Saved"}},
+	})
+
+	var body, received string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			v := struct{ Name string }{}
+			json.NewDecoder(r.Body).Decode(&v)
+			received = v.Name
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+	reported := []error{}
+	h := d.Validator(func(r *http.Request, err error) { reported = append(reported, err) })(next)
+	thingPath := "/things/" + bson.NewObjectId().Hex()
+
+	testCases := []struct {
+		txt      string
+		method   string
+		path     string
+		body     string
+		response string
+		status   int
+		reported int
+	}{
+		{"Matching requests and responses", http.MethodGet, thingPath + "?limit=5", "", `{"name": "a"}`, http.StatusOK, 0},
+		{"Responses are checked", http.MethodGet, thingPath, "", `{"name": 1}`, http.StatusOK, 1},
+		{"Query parameters are checked", http.MethodGet, thingPath + "?limit=50", "", `{"name": "a"}`, http.StatusBadRequest, 0},
+		{"Query parameters must be integers", http.MethodGet, thingPath + "?limit=a", "", `{"name": "a"}`, http.StatusBadRequest, 0},
+		{"Undocumented parameters are rejected", http.MethodGet, thingPath + "?sort=name", "", `{"name": "a"}`, http.StatusBadRequest, 0},
+		{"Bodies are checked", http.MethodPut, thingPath, `{}`, "", http.StatusBadRequest, 0},
+		{"Valid bodies reach the handler", http.MethodPut, thingPath, `{"name": "a"}`, "", http.StatusNoContent, 0},
+		{"Invalid path parameters leave the route undocumented", http.MethodGet, "/things/1", "", `{"name": "a"}`, http.StatusOK, 1},
+		{"Undocumented methods are reported", http.MethodDelete, thingPath, "", "", http.StatusOK, 1},
+		{"Preflight requests pass", http.MethodOptions, thingPath, "", "", http.StatusOK, 0},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		reported, body = reported[:0], tc.response
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d: %s", tc.status, w.Code, w.Body)
+		}
+		if len(reported) != tc.reported {
+			t.Errorf("Expected %d reports, got %v", tc.reported, reported)
+		}
+	}
+
+	t.Log("Checked bodies are still readable")
+	r := httptest.NewRequest(http.MethodPut, thingPath, strings.NewReader(`{"name": "b"}`))
+	r.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if received != "b" {
+		t.Errorf("Expected the handler to read b, got %q", received)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	d := New("Test", "1")
+	d.Add(http.MethodGet, "/things/{id}", &Operation{})
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Error decoding the document: %s", err)
+	}
+	if doc["openapi"] != Version {
+		t.Errorf("Expected version %s, got %v", Version, doc["openapi"])
+	}
+	op := doc["paths"].(map[string]interface{})["/things/{id}"].(map[string]interface{})["get"].(map[string]interface{})
+	if op["operationId"] != "get_things_id" {
+		t.Errorf("Expected a default operation id, got %v", op["operationId"])
+	}
+
+	w = httptest.NewRecorder()
+	Docs("/openapi.json").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if !strings.Contains(w.Body.String(), `spec-url="/openapi.json"`) {
+		t.Errorf("Expected the docs page to load the document, got %s", w.Body)
+	}
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"encoding/json"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema, limited to the keywords the API uses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+}
+
+// JSON types
+const (
+	String  = "string"
+	Integer = "integer"
+	Number  = "number"
+	Boolean = "boolean"
+	Object  = "object"
+	Array   = "array"
+	Null    = "null"
+)
+
+// Ref returns a reference to the component schema name
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Of returns a schema of type typ with the given enum values, if any
+func Of(typ string, enum ...interface{}) *Schema {
+	return &Schema{Type: typ, Enum: enum}
+}
+
+// Between returns a copy of s limited to values from min to max
+func Between(s *Schema, min, max float64) *Schema {
+	c := *s
+	c.Minimum, c.Maximum = &min, &max
+	return &c
+}
+
+// ArrayOf returns the schema of arrays of items
+func ArrayOf(items *Schema) *Schema {
+	return &Schema{Type: Array, Items: items}
+}
+
+// ObjectOf returns the schema of objects with the given properties, of which
+// required ones must be present
+func ObjectOf(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: Object, Properties: properties, Required: required}
+}
+
+// MapOf returns the schema of objects whose members are all values
+func MapOf(values *Schema) *Schema {
+	return &Schema{Type: Object, AdditionalProperties: values}
+}
+
+// OneOf returns the schema of values matching exactly one of schemas
+func OneOf(schemas ...*Schema) *Schema {
+	return &Schema{OneOf: schemas}
+}
+
+// Nullable returns a schema accepting the values of s and null
+func Nullable(s *Schema) *Schema {
+	switch typ := s.Type.(type) {
+	case string:
+		c := *s
+		c.Type = []string{typ, Null}
+		return &c
+	case nil:
+		if s.Ref == "" && len(s.OneOf) == 0 {
+			// s accepts any value already
+			return s
+		}
+		return OneOf(s, Of(Null))
+	}
+	return s
+}
+
+// ObjectID is the schema of the hexadecimal object ids of records
+var ObjectID = &Schema{Type: String, Pattern: "^[0-9a-f]{24}$"}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(bson.ObjectId(""))
+	rawType      = reflect.TypeOf(json.RawMessage{})
+)
+
+// Schema registers the schema of the JSON form of v's type, a struct, as the
+// component name and returns a reference to it. Fields without omitempty are
+// required, and nullable if they are slices, maps or pointers. Nested named
+// structs are registered as components of their own.
+func (d *Document) Schema(name string, v interface{}) *Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	d.Components.Schemas[name] = d.structSchema(t)
+	return Ref(name)
+}
+
+// Component registers s as the component name and returns a reference to it
+func (d *Document) Component(name string, s *Schema) *Schema {
+	d.Components.Schemas[name] = s
+	return Ref(name)
+}
+
+// Resolve returns the schema referenced by s, or s itself
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: Object, Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" || f.PkgPath != "" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := false
+		for _, opt := range tag[1:] {
+			omitempty = omitempty || opt == "omitempty"
+		}
+		s.Properties[name] = d.typeSchema(f.Type)
+		if omitempty {
+			continue
+		}
+		s.Required = append(s.Required, name)
+		switch f.Type.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Ptr:
+			s.Properties[name] = Nullable(s.Properties[name])
+		}
+	}
+	return s
+}
+
+func (d *Document) typeSchema(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: String, Format: "date-time"}
+	case t == objectIDType:
+		return ObjectID
+	case t == rawType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return d.typeSchema(t.Elem())
+	case reflect.String:
+		return Of(String)
+	case reflect.Bool:
+		return Of(Boolean)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Of(Integer)
+	case reflect.Float32, reflect.Float64:
+		return Of(Number)
+	case reflect.Slice, reflect.Array:
+		return ArrayOf(d.typeSchema(t.Elem()))
+	case reflect.Map:
+		return MapOf(&Schema{})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// reserve the name so that recursive types terminate
+			d.Components.Schemas[t.Name()] = nil
+			d.Components.Schemas[t.Name()] = d.structSchema(t)
+		}
+		return Ref(t.Name())
+	}
+	// interfaces accept any value
+	return &Schema{}
+}
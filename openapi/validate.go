@@ -0,0 +1,376 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Violation is a part of a request or response that does not match the
+// document
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) Error() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Error lists the violations of a request or response
+type Error struct {
+	Message    string      `json:"message"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Error()
+	}
+	if len(msgs) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(msgs, "; ")
+}
+
+// types lists the JSON types a schema accepts
+func types(s *Schema) []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+// typeOf returns the JSON type of a decoded value
+func typeOf(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return Null
+	case bool:
+		return Boolean
+	case float64:
+		if n == math.Trunc(n) {
+			return Integer
+		}
+		return Number
+	case string:
+		return String
+	case []interface{}:
+		return Array
+	case map[string]interface{}:
+		return Object
+	}
+	return ""
+}
+
+// accepts reports whether a value of type typ is one of allowed
+func accepts(allowed []string, typ string) bool {
+	for _, a := range allowed {
+		if a == typ || (a == Number && typ == Integer) {
+			return true
+		}
+	}
+	return false
+}
+
+// join appends a member or index to a path
+func join(path string, key interface{}) string {
+	if path == "" {
+		return fmt.Sprint(key)
+	}
+	return fmt.Sprintf("%s.%v", path, key)
+}
+
+// Validate checks a decoded JSON value against the schema s
+func (d *Document) Validate(s *Schema, v interface{}) []Violation {
+	return d.validate(s, v, "")
+}
+
+func (d *Document) validate(s *Schema, v interface{}, path string) []Violation {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, alt := range s.OneOf {
+			if len(d.validate(alt, v, path)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return []Violation{{Path: path, Message: fmt.Sprintf("matches %d of %d alternatives instead of one", matched, len(s.OneOf))}}
+		}
+		return nil
+	}
+	typ := typeOf(v)
+	if allowed := types(s); allowed != nil && !accepts(allowed, typ) {
+		return []Violation{{Path: path, Message: fmt.Sprintf("expected %s, got %s", strings.Join(allowed, " or "), typ)}}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || fmt.Sprint(e) == fmt.Sprint(v)
+		}
+		if !found {
+			return []Violation{{Path: path, Message: fmt.Sprintf("%v is not one of %v", v, s.Enum)}}
+		}
+	}
+	violations := []Violation{}
+	switch value := v.(type) {
+	case string:
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(value) {
+			violations = append(violations, Violation{Path: path, Message: "does not match " + s.Pattern})
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+				violations = append(violations, Violation{Path: path, Message: "is not a date-time"})
+			}
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("is less than %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("is greater than %v", *s.Maximum)})
+		}
+	case []interface{}:
+		for i, item := range value {
+			violations = append(violations, d.validate(s.Items, item, join(path, i))...)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				violations = append(violations, Violation{Path: join(path, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := s.Properties[name]; ok {
+				violations = append(violations, d.validate(p, value[name], join(path, name))...)
+			} else if s.AdditionalProperties != nil {
+				violations = append(violations, d.validate(s.AdditionalProperties, value[name], join(path, name))...)
+			}
+		}
+	}
+	return violations
+}
+
+// coerce converts a parameter to the JSON type of its schema
+func coerce(s *Schema, value string) (interface{}, error) {
+	for _, typ := range types(s) {
+		switch typ {
+		case Integer:
+			n, err := strconv.ParseInt(value, 10, 64)
+			return float64(n), err
+		case Number:
+			return strconv.ParseFloat(value, 64)
+		case Boolean:
+			return strconv.ParseBool(value)
+		}
+	}
+	return value, nil
+}
+
+// jsonType reports whether the media type mt is JSON
+func jsonType(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// mediaType returns the media type of a Content-Type header
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// checkRequest lists how the request differs from the operation
+func (d *Document) checkRequest(r *http.Request, op *Operation) []Violation {
+	violations := []Violation{}
+	query := r.URL.Query()
+	documented := map[string]bool{}
+	for _, p := range op.Parameters {
+		if p.In != InQuery {
+			continue
+		}
+		documented[p.Name] = true
+		values, ok := query[p.Name]
+		if !ok {
+			if p.Required {
+				violations = append(violations, Violation{Path: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		for _, value := range values {
+			v, err := coerce(p.Schema, value)
+			if err != nil {
+				violations = append(violations, Violation{Path: p.Name, Message: "expected " + strings.Join(types(p.Schema), " or ")})
+				continue
+			}
+			violations = append(violations, d.validate(p.Schema, v, p.Name)...)
+		}
+	}
+	for name := range query {
+		if !documented[name] {
+			violations = append(violations, Violation{Path: name, Message: "is not a documented query parameter"})
+		}
+	}
+
+	if op.RequestBody == nil || r.Body == nil {
+		return violations
+	}
+	mt := mediaType(r.Header.Get("Content-Type"))
+	content, ok := op.RequestBody.Content[mt]
+	if !ok || !jsonType(mt) || content.Schema == nil {
+		return violations
+	}
+	bd, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(bd))
+	if err != nil {
+		return append(violations, Violation{Path: "body", Message: err.Error()})
+	}
+	if len(bytes.TrimSpace(bd)) == 0 {
+		if op.RequestBody.Required {
+			violations = append(violations, Violation{Path: "body", Message: "is required"})
+		}
+		return violations
+	}
+	var v interface{}
+	if err := json.Unmarshal(bd, &v); err != nil {
+		return append(violations, Violation{Path: "body", Message: "is not valid JSON"})
+	}
+	return append(violations, d.validate(content.Schema, v, "body")...)
+}
+
+// checkResponse lists how the response differs from the operation
+func (d *Document) checkResponse(op *Operation, rec *recorder) []Violation {
+	resp, ok := op.Responses[strconv.Itoa(rec.status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []Violation{{Path: "status", Message: strconv.Itoa(rec.status) + " is not documented"}}
+	}
+	mt := mediaType(rec.Header().Get("Content-Type"))
+	content, ok := resp.Content[mt]
+	if !ok || !jsonType(mt) || content.Schema == nil || rec.body.Len() == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(rec.body.Bytes(), &v); err != nil {
+		return []Violation{{Path: "body", Message: "is not valid JSON"}}
+	}
+	return d.validate(content.Schema, v, "body")
+}
+
+// buffered reports whether any response of the operation has a JSON body
+// worth buffering for validation. Streams are passed through as they are.
+func buffered(op *Operation) bool {
+	for _, resp := range op.Responses {
+		for mt, content := range resp.Content {
+			if jsonType(mt) && content.Schema != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recorder copies the response it writes through to check it afterwards
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Validator returns middleware checking requests and responses against the
+// document, meant for development. Requests that do not match it get 400
+// Bad Request listing the violations. Responses are already sent when they
+// are checked, so their violations, like requests the document does not
+// describe, are passed to report, which logs them if nil.
+func (d *Document) Validator(report func(r *http.Request, err error)) func(http.Handler) http.Handler {
+	if report == nil {
+		report = func(r *http.Request, err error) {
+			log.Printf("openapi: %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			op, _ := d.match(r)
+			if op == nil {
+				report(r, &Error{Message: "operation is not documented"})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if violations := d.checkRequest(r, op); len(violations) > 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": &Error{
+					Message:    "request does not match the API description",
+					Violations: violations,
+				}})
+				return
+			}
+			if !buffered(op) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if r.Method == http.MethodHead {
+				rec.body.Reset()
+			}
+			if violations := d.checkResponse(op, rec); len(violations) > 0 {
+				report(r, &Error{Message: "response does not match the API description", Violations: violations})
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Media types of the supported patch documents
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// Errors returned when applying a patch
+var (
+	// Returns ErrUnsupportedType when the content type is not a known patch format
+	ErrUnsupportedType = errors.New("unsupported patch type")
+	// Returns ErrInvalidPatch when the patch document is malformed
+	ErrInvalidPatch = errors.New("patch is invalid")
+	// Returns ErrInvalidPath when an operation targets a location that does not exist
+	ErrInvalidPath = errors.New("path is invalid")
+	// Returns ErrTestFailed when a test operation does not match the document
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// Error locates the JSON Patch operation that could not be applied
+type Error struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Operation is a single JSON Patch (RFC 6902) operation. Value holds the
+// literal null when the value member is null and is empty when it is absent.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply patches doc with the patch document of the given content type.
+// Plain application/json is treated as a merge patch.
+func Apply(contentType string, doc, patch []byte) ([]byte, error) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	switch mt {
+	case MergePatchType, "application/json":
+		return MergePatch(doc, patch)
+	case JSONPatchType:
+		return JSONPatch(doc, patch)
+	}
+	return nil, ErrUnsupportedType
+}
+
+// Changed returns the first of the given top-level fields whose value differs
+// between the JSON objects before and after, or an empty string if none
+// does. Missing fields are null.
+func Changed(before, after []byte, fields []string) (string, error) {
+	var b, a map[string]interface{}
+	if err := decode(before, &b); err != nil {
+		return "", err
+	}
+	if err := decode(after, &a); err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if !equal(b[f], a[f]) {
+			return f, nil
+		}
+	}
+	return "", nil
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to doc
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := decode(patch, &p); err != nil {
+		return nil, ErrInvalidPatch
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = merge(t[k], v)
+	}
+	return t
+}
+
+// JSONPatch applies a JSON Patch (RFC 6902) to doc. The operations are
+// applied in order and the whole patch fails if any of them fails.
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+	ops := []Operation{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, ErrInvalidPatch
+	}
+	for i, op := range ops {
+		var err error
+		target, err = applyOp(target, op)
+		if err != nil {
+			return nil, &Error{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyOp(doc interface{}, op Operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, ErrInvalidPatch
+		}
+		if err := decode(op.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		doc, _, err = remove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, ErrInvalidPath
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			if err == nil {
+				value, err = clone(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+	return nil, ErrInvalidPatch
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+	if p[0] != '/' {
+		return nil, ErrInvalidPath
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// index parses an array index; "-" refers to the end of the array when allowed
+func index(token string, length int, end bool) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrInvalidPath
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, ErrInvalidPath
+	}
+	if i > length || (!end && i == length) {
+		return 0, ErrInvalidPath
+	}
+	return i, nil
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[t]
+			if !ok {
+				return nil, ErrInvalidPath
+			}
+			node = v
+		case []interface{}:
+			i, err := index(t, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, ErrInvalidPath
+		}
+	}
+	return node, nil
+}
+
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	t, last := path[0], len(path) == 1
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if last {
+			n[t] = value
+			return n, nil
+		}
+		child, ok := n[t]
+		if !ok {
+			return nil, ErrInvalidPath
+		}
+		c, err := add(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[t] = c
+		return n, nil
+	case []interface{}:
+		i, err := index(t, len(n), last)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		c, err := add(n[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = c
+		return n, nil
+	}
+	return nil, ErrInvalidPath
+}
+
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, ErrInvalidPath
+	}
+	t, last := path[0], len(path) == 1
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[t]
+		if !ok {
+			return nil, nil, ErrInvalidPath
+		}
+		if last {
+			delete(n, t)
+			return n, child, nil
+		}
+		c, removed, err := remove(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[t] = c
+		return n, removed, nil
+	case []interface{}:
+		i, err := index(t, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if last {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		c, removed, err := remove(n[i], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = c
+		return n, removed, nil
+	}
+	return nil, nil, ErrInvalidPath
+}
+
+// equal compares two decoded JSON values, numbers by value
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := av.Float64()
+		bf, berr := bv.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+	return a == b
+}
+
+func clone(v interface{}) (interface{}, error) {
+	bd, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	err = decode(bd, &c)
+	return c, err
+}
+
+// decode unmarshals JSON keeping numbers as json.Number
+func decode(bd []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(bd))
+	d.UseNumber()
+	return d.Decode(v)
+}
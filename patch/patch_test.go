@@ -0,0 +1,232 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func assertJSON(t *testing.T, exp string, got []byte) {
+	t.Helper()
+	var e, g interface{}
+	if err := json.Unmarshal([]byte(exp), &e); err != nil {
+		t.Fatalf("Invalid expectation %s: %s", exp, err)
+	}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("Invalid result %s: %s", got, err)
+	}
+	if !reflect.DeepEqual(e, g) {
+		t.Errorf("Expected %s, got %s", exp, got)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	ts := []struct {
+		doc   string
+		patch string
+		exp   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+	}
+
+	for _, tc := range ts {
+		got, err := MergePatch([]byte(tc.doc), []byte(tc.patch))
+		if err != nil {
+			t.Errorf("Did not expect an error patching %s with %s: %s", tc.doc, tc.patch, err)
+			continue
+		}
+		assertJSON(t, tc.exp, got)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	ts := []struct {
+		txt   string
+		doc   string
+		patch string
+		exp   string
+		err   error
+	}{
+		{
+			txt:   "add an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			exp:   `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			txt:   "add an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			exp:   `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			txt:   "append to an array",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":"qux"}]`,
+			exp:   `{"foo":["bar","qux"]}`,
+		},
+		{
+			txt:   "remove an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			exp:   `{"foo":["bar","baz"]}`,
+		},
+		{
+			txt:   "replace a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			exp:   `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			txt:   "set a member to null",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"add","path":"/bar","value":null},{"op":"replace","path":"/baz","value":null},{"op":"test","path":"/baz","value":null}]`,
+			exp:   `{"bar":null,"baz":null,"foo":"bar"}`,
+		},
+		{
+			txt:   "missing value",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			txt:   "move a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			exp:   `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			txt:   "copy a value",
+			doc:   `{"foo":{"bar":1}}`,
+			patch: `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`,
+			exp:   `{"foo":{"bar":1},"baz":{"bar":2}}`,
+		},
+		{
+			txt:   "escaped pointer",
+			doc:   `{"a/b":1,"m~n":2}`,
+			patch: `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`,
+			exp:   `{}`,
+		},
+		{
+			txt:   "successful test",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			exp:   `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			txt:   "failed test",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			txt:   "add to a missing parent",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			txt:   "remove a missing member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			txt:   "array index out of bounds",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/5","value":"qux"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			txt:   "move into a child",
+			doc:   `{"foo":{"bar":1}}`,
+			patch: `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`,
+			err:   ErrInvalidPath,
+		},
+		{
+			txt:   "unknown operation",
+			doc:   `{}`,
+			patch: `[{"op":"merge","path":"/foo"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			txt:   "malformed patch",
+			doc:   `{}`,
+			patch: `{"op":"add"}`,
+			err:   ErrInvalidPatch,
+		},
+	}
+
+	for _, tc := range ts {
+		t.Log(tc.txt)
+		got, err := JSONPatch([]byte(tc.doc), []byte(tc.patch))
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected error %s, got %v", tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Did not expect an error but got one: %s", err)
+			continue
+		}
+		assertJSON(t, tc.exp, got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	doc := []byte(`{"name":"John","role":"Tester"}`)
+
+	got, err := Apply(MergePatchType+"; charset=utf-8", doc, []byte(`{"role":null}`))
+	if err != nil {
+		t.Fatalf("Did not expect an error but got one: %s", err)
+	}
+	assertJSON(t, `{"name":"John"}`, got)
+
+	got, err = Apply(JSONPatchType, doc, []byte(`[{"op":"remove","path":"/role"}]`))
+	if err != nil {
+		t.Fatalf("Did not expect an error but got one: %s", err)
+	}
+	assertJSON(t, `{"name":"John"}`, got)
+
+	_, err = Apply("text/plain", doc, []byte(`role=`))
+	if err != ErrUnsupportedType {
+		t.Errorf("Expected error %s, got %v", ErrUnsupportedType, err)
+	}
+
+	var pe *Error
+	_, err = Apply(JSONPatchType, doc, []byte(`[{"op":"test","path":"/name","value":"John"},{"op":"remove","path":"/age"}]`))
+	if !errors.As(err, &pe) || pe.Index != 1 || pe.Path != "/age" {
+		t.Errorf("Expected error locating the second operation, got %v", err)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	before := []byte(`{"id":"1","version":2,"name":"John"}`)
+	testCases := []struct {
+		txt   string
+		after string
+		exp   string
+	}{
+		{"Nothing changed", `{"id":"1","version":2.0,"name":"Jane"}`, ""},
+		{"A field changed", `{"id":"1","version":3,"name":"John"}`, "version"},
+		{"A field removed", `{"version":2,"name":"John"}`, "id"},
+		{"A field added", `{"id":"1","version":2,"deleted_at":"2020-01-01T00:00:00Z"}`, "deleted_at"},
+		{"A null field", `{"id":"1","version":2,"deleted_at":null}`, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		got, err := Changed(before, []byte(tc.after), []string{"id", "version", "deleted_at"})
+		if err != nil || got != tc.exp {
+			t.Errorf("Expected field %q, got %q (%v)", tc.exp, got, err)
+		}
+	}
+}
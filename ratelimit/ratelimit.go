@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"github.com/christianotieno/go-rest-api/auth"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit describes a token bucket: Rate tokens are added per second up to Burst
+type Limit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// Result describes the state of a bucket after a request has been counted
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets of every client. The in-memory store is used
+// by default; a shared store lets several replicas enforce the same budget.
+type Store interface {
+	Take(key string, l Limit, now time.Time) Result
+}
+
+// Limiter enforces separate read and write budgets per client
+type Limiter struct {
+	Read  Limit
+	Write Limit
+	Store Store
+	Key   func(r *http.Request) string
+}
+
+// APIKeyHeader is the header clients use to identify themselves with an API key
+const APIKeyHeader = auth.APIKeyHeader
+
+// New returns a limiter backed by an in-memory store and keyed by ClientKey
+func New(read, write Limit) *Limiter {
+	return &Limiter{
+		Read:  read,
+		Write: write,
+		Store: NewMemStore(),
+		Key:   ClientKey,
+	}
+}
+
+// ClientKey identifies the client by its verified API key, operator
+// credentials or bearer token, and otherwise by IP address. Unverified
+// credentials are ignored, since a client could send new ones with every
+// request to get a fresh bucket.
+func ClientKey(r *http.Request) string {
+	if p, err := auth.Authenticate(r); err == nil && p != nil {
+		return p.Key()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func isRead(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// Allow counts the request against the client's read or write budget
+func (l *Limiter) Allow(r *http.Request) Result {
+	key := l.Key(r)
+	if isRead(r.Method) {
+		return l.Store.Take(key+":read", l.Read, time.Now())
+	}
+	return l.Store.Take(key+":write", l.Write, time.Now())
+}
+
+// Middleware rejects requests over the client's budget with 429 Too Many Requests
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Allow(r)
+		SetHeaders(w.Header(), res)
+		if !res.Allowed {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetHeaders adds the RateLimit-* and Retry-After headers describing the result
+func SetHeaders(h http.Header, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type memStore struct {
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// idleTimeout is how long an unused bucket is kept in memory
+const idleTimeout = 10 * time.Minute
+
+// NewMemStore returns a store that keeps the buckets in process memory
+func NewMemStore() Store {
+	return &memStore{buckets: map[string]*bucket{}}
+}
+
+// Take removes a token from the bucket identified by key
+func (s *memStore) Take(key string, l Limit, now time.Time) Result {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.Rate, l.Burst)}
+		s.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := Result{
+		Allowed: b.limiter.AllowN(now, 1),
+		Limit:   l.Burst,
+	}
+	tokens := b.limiter.TokensAt(now)
+	if tokens > 0 {
+		res.Remaining = int(tokens)
+	}
+	if l.Rate > 0 {
+		res.Reset = time.Duration((float64(l.Burst) - tokens) / float64(l.Rate) * float64(time.Second))
+		if !res.Allowed {
+			res.RetryAfter = time.Duration((1 - tokens) / float64(l.Rate) * float64(time.Second))
+		}
+	}
+	return res
+}
+
+// sweep drops buckets that have not been used for a while
+func (s *memStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < idleTimeout {
+		return
+	}
+	for k, b := range s.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(s.buckets, k)
+		}
+	}
+	s.swept = now
+}
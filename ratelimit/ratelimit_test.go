@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"github.com/christianotieno/go-rest-api/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientKey(t *testing.T) {
+	auth.Username, auth.Password, auth.APIKeys = "Peter", "password", map[string]string{"secret": "billing"}
+	defer func() {
+		auth.Username, auth.Password, auth.APIKeys = "", "", map[string]string{}
+	}()
+
+	ts := []struct {
+		txt string
+		r   *http.Request
+		exp string
+	}{
+		{
+			txt: "remote address",
+			r:   &http.Request{RemoteAddr: "10.0.0.1:1234", Header: http.Header{}},
+			exp: "ip:10.0.0.1",
+		},
+		{
+			txt: "api key",
+			r: &http.Request{
+				RemoteAddr: "10.0.0.1:1234",
+				Header:     http.Header{"X-Api-Key": []string{"secret"}},
+			},
+			exp: "key:billing",
+		},
+		{
+			txt: "unknown api key",
+			r: &http.Request{
+				RemoteAddr: "10.0.0.1:1234",
+				Header:     http.Header{"X-Api-Key": []string{"random"}},
+			},
+			exp: "ip:10.0.0.1",
+		},
+		{
+			txt: "authenticated user",
+			r: &http.Request{
+				RemoteAddr: "10.0.0.1:1234",
+				Header:     http.Header{"Authorization": []string{"Basic UGV0ZXI6cGFzc3dvcmQ="}},
+			},
+			exp: "user:Peter",
+		},
+		{
+			txt: "unverified user",
+			r: &http.Request{
+				RemoteAddr: "10.0.0.1:1234",
+				Header:     http.Header{"Authorization": []string{"Basic UGV0ZXI6Z3Vlc3M="}},
+			},
+			exp: "ip:10.0.0.1",
+		},
+	}
+
+	for _, tc := range ts {
+		t.Log(tc.txt)
+		got := ClientKey(tc.r)
+		if got != tc.exp {
+			t.Errorf("Expected key %s, got %s", tc.exp, got)
+		}
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	s := NewMemStore()
+	l := Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	res := s.Take("client", l, now)
+	if !res.Allowed || res.Remaining != 1 || res.Limit != 2 {
+		t.Errorf("Expected first request to be allowed with 1 remaining, got %+v", res)
+	}
+	res = s.Take("client", l, now)
+	if !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Expected second request to be allowed with 0 remaining, got %+v", res)
+	}
+	res = s.Take("client", l, now)
+	if res.Allowed {
+		t.Errorf("Expected third request to be limited, got %+v", res)
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("Expected retry after %s, got %s", time.Second, res.RetryAfter)
+	}
+
+	res = s.Take("other", l, now)
+	if !res.Allowed {
+		t.Errorf("Expected other client to have its own bucket, got %+v", res)
+	}
+
+	res = s.Take("client", l, now.Add(time.Second))
+	if !res.Allowed {
+		t.Errorf("Expected bucket to refill, got %+v", res)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l := New(Limit{Rate: 1, Burst: 1}, Limit{Rate: 1, Burst: 1})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := httptest.NewRequest(http.MethodGet, "/users", nil)
+	post := httptest.NewRequest(http.MethodPost, "/users", nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, get)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("Expected RateLimit-Limit 1, got %s", w.Header().Get("RateLimit-Limit"))
+	}
+
+	t.Log("Writes have a separate budget")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, post)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, get)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After 1, got %s", w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"connectrpc.com/connect"
+	"errors"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	usersv1 "github.com/christianotieno/go-rest-api/rpc/users/v1"
+	"github.com/christianotieno/go-rest-api/user"
+)
+
+// errInternal hides the causes of internal errors from clients
+var errInternal = errors.New("internal error")
+
+// fieldError returns an error with the given code carrying detail, so that
+// clients know which field to blame
+func fieldError(code connect.Code, detail *usersv1.FieldError) error {
+	err := connect.NewError(code, errors.New(detail.Field+": "+detail.Message))
+	if d, derr := connect.NewErrorDetail(detail); derr == nil {
+		err.AddDetail(d)
+	}
+	return err
+}
+
+// storeError maps the errors of the user store to RPC errors, the way the
+// REST handlers map them to status codes
+func storeError(err error) error {
+	var ce *connect.Error
+	if errors.As(err, &ce) {
+		return err
+	}
+	var fe *user.FieldError
+	if errors.As(err, &fe) {
+		return fieldError(connect.CodeInvalidArgument, &usersv1.FieldError{Field: fe.Field, Message: fe.Message})
+	}
+	var conflict *user.ConflictError
+	if errors.As(err, &conflict) {
+		msg := "is already taken"
+		if conflict.Trashed {
+			msg += " by user " + conflict.ID.Hex() + " in the trash"
+		}
+		return fieldError(connect.CodeAlreadyExists, &usersv1.FieldError{
+			Field:   conflict.Field,
+			Message: msg,
+			Value:   fmt.Sprint(conflict.Value),
+		})
+	}
+	switch {
+	case err == storm.ErrNotFound:
+		return connect.NewError(connect.CodeNotFound, errors.New("user not found"))
+	case errors.Is(err, user.ErrRecordInvalid):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case errors.Is(err, user.ErrConflict):
+		return connect.NewError(connect.CodeAlreadyExists, err)
+	case err == user.ErrDeleted, err == user.ErrNotDeleted:
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case err == user.ErrQuota:
+		return connect.NewError(connect.CodeResourceExhausted, err)
+	}
+	return connect.NewError(connect.CodeInternal, errInternal)
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"connectrpc.com/connect"
+	usersv1 "github.com/christianotieno/go-rest-api/rpc/users/v1"
+	"github.com/christianotieno/go-rest-api/user"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// eventTypes are the message types of the change events
+var eventTypes = map[string]usersv1.EventType{
+	user.EventCreated:  usersv1.EventType_EVENT_TYPE_CREATED,
+	user.EventUpdated:  usersv1.EventType_EVENT_TYPE_UPDATED,
+	user.EventDeleted:  usersv1.EventType_EVENT_TYPE_DELETED,
+	user.EventRestored: usersv1.EventType_EVENT_TYPE_RESTORED,
+}
+
+// timestamp is the message of t, nil if t is nil
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// toMessage returns the message of u, nil if u is nil
+func toMessage(u *user.User) (*usersv1.User, error) {
+	if u == nil {
+		return nil, nil
+	}
+	m := &usersv1.User{
+		Id:        u.ID.Hex(),
+		Name:      u.Name,
+		Role:      u.Role,
+		Email:     u.Email,
+		Status:    u.Status,
+		Version:   int64(u.Version),
+		CreatedAt: timestamp(&u.CreatedAt),
+		UpdatedAt: timestamp(&u.UpdatedAt),
+		DeletedAt: timestamp(u.DeletedAt),
+	}
+	if u.Attributes != nil {
+		attrs, err := structpb.NewStruct(u.Attributes)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, errInternal)
+		}
+		m.Attributes = attrs
+	}
+	return m, nil
+}
+
+// fromMessage returns the user holding the fields of m clients can set.
+// The output only fields are ignored.
+func fromMessage(m *usersv1.User) *user.User {
+	u := &user.User{
+		Name:   m.GetName(),
+		Role:   m.GetRole(),
+		Email:  m.GetEmail(),
+		Status: m.GetStatus(),
+	}
+	if m.GetAttributes() != nil {
+		u.Attributes = m.GetAttributes().AsMap()
+	}
+	return u
+}
+
+// merge copies the fields of in named by paths to u
+func merge(u, in *user.User, paths []string) error {
+	for _, path := range paths {
+		switch path {
+		case "name":
+			u.Name = in.Name
+		case "role":
+			u.Role = in.Role
+		case "email":
+			u.Email = in.Email
+		case "status":
+			u.Status = in.Status
+		case "attributes":
+			u.Attributes = in.Attributes
+		default:
+			return fieldError(connect.CodeInvalidArgument, &usersv1.FieldError{Field: "update_mask", Message: "names a field that cannot be updated", Value: path})
+		}
+	}
+	return nil
+}
+
+// toEvent returns the message of a change event
+func toEvent(ev user.Event) (*usersv1.WatchUsersResponse, error) {
+	before, err := toMessage(ev.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := toMessage(ev.After)
+	if err != nil {
+		return nil, err
+	}
+	return &usersv1.WatchUsersResponse{
+		Seq:     ev.Seq,
+		Type:    eventTypes[ev.Type],
+		UserId:  ev.UserID.Hex(),
+		Version: int64(ev.Version),
+		Before:  before,
+		After:   after,
+		Time:    timestamp(&ev.Time),
+	}, nil
+}
+
+// parseID returns the user id named by a request
+func parseID(id string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(id) {
+		return "", fieldError(connect.CodeInvalidArgument, &usersv1.FieldError{Field: "id", Message: "is not a valid id", Value: id})
+	}
+	return bson.ObjectIdHex(id), nil
+}
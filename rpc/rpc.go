@@ -0,0 +1,188 @@
+// Package rpc serves the users over gRPC, gRPC-Web and the Connect protocol.
+// The service is described by proto/users/v1/users.proto and shares the
+// storage, validation and tenancy of the REST handlers. Writes drop the cached
+// REST representations as the REST handlers do, and webhooks are queued from
+// the event log.
+package rpc
+
+//go:generate buf generate ../proto --template ../buf.gen.yaml --output ..
+
+import (
+	"connectrpc.com/connect"
+	"context"
+	"github.com/christianotieno/go-rest-api/authz"
+	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/feed"
+	usersv1 "github.com/christianotieno/go-rest-api/rpc/users/v1"
+	"github.com/christianotieno/go-rest-api/rpc/users/v1/usersv1connect"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+)
+
+// UserService implements the users.v1.UserService on the user store of the
+// tenant carried by the request context
+type UserService struct{}
+
+// Handler returns the path the service is served under and its handler
+func Handler(opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append([]connect.HandlerOption{connect.WithInterceptors(authorize())}, opts...)
+	return usersv1connect.NewUserServiceHandler(UserService{}, opts...)
+}
+
+// reads are the unary procedures that change nothing. The streaming ones
+// only read too.
+var reads = map[string]bool{
+	usersv1connect.UserServiceGetUserProcedure: true,
+}
+
+// authorize lets only the operator and the admins of the tenant call the
+// procedures that change users, like authz.Require does for REST writes
+func authorize() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient || reads[req.Spec().Procedure] {
+				return next(ctx, req)
+			}
+			r := (&http.Request{Header: req.Header()}).WithContext(ctx)
+			switch err := authz.Permit(r, authz.Admin); err {
+			case nil:
+				return next(ctx, req)
+			case authz.ErrUnauthenticated:
+				return nil, connect.NewError(connect.CodeUnauthenticated, err)
+			case authz.ErrForbidden:
+				return nil, connect.NewError(connect.CodePermissionDenied, err)
+			}
+			return nil, connect.NewError(connect.CodeInternal, errInternal)
+		}
+	}
+}
+
+// GetUser returns a user that is not in the trash
+func (UserService) GetUser(ctx context.Context, req *connect.Request[usersv1.GetUserRequest]) (*connect.Response[usersv1.GetUserResponse], error) {
+	id, err := parseID(req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	u, err := user.OneContext(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	m, err := toMessage(u)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&usersv1.GetUserResponse{User: m}), nil
+}
+
+// CreateUser stores a new user with a new id
+func (UserService) CreateUser(ctx context.Context, req *connect.Request[usersv1.CreateUserRequest]) (*connect.Response[usersv1.CreateUserResponse], error) {
+	u := fromMessage(req.Msg.User)
+	u.ID = bson.NewObjectId()
+	if err := u.SaveContext(ctx); err != nil {
+		return nil, storeError(err)
+	}
+	cache.DropUsers(ctx, u.ID)
+	m, err := toMessage(u)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&usersv1.CreateUserResponse{User: m}), nil
+}
+
+// UpdateUser replaces the fields of an existing user, or only those named by
+// the update mask
+func (UserService) UpdateUser(ctx context.Context, req *connect.Request[usersv1.UpdateUserRequest]) (*connect.Response[usersv1.UpdateUserResponse], error) {
+	in := req.Msg.User
+	if in == nil {
+		return nil, fieldError(connect.CodeInvalidArgument, &usersv1.FieldError{Field: "user", Message: "is required"})
+	}
+	id, err := parseID(in.Id)
+	if err != nil {
+		return nil, err
+	}
+	u, err := user.OneContext(ctx, id)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if paths := req.Msg.UpdateMask.GetPaths(); len(paths) > 0 {
+		if err := merge(u, fromMessage(in), paths); err != nil {
+			return nil, err
+		}
+	} else {
+		u = fromMessage(in)
+		u.ID = id
+	}
+	if err := u.SaveContext(ctx); err != nil {
+		return nil, storeError(err)
+	}
+	cache.DropUsers(ctx, u.ID)
+	m, err := toMessage(u)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&usersv1.UpdateUserResponse{User: m}), nil
+}
+
+// DeleteUser moves a user to the trash
+func (UserService) DeleteUser(ctx context.Context, req *connect.Request[usersv1.DeleteUserRequest]) (*connect.Response[usersv1.DeleteUserResponse], error) {
+	id, err := parseID(req.Msg.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.DeleteContext(ctx, id); err != nil {
+		return nil, storeError(err)
+	}
+	cache.DropUsers(ctx, id)
+	return connect.NewResponse(&usersv1.DeleteUserResponse{}), nil
+}
+
+// ListUsers streams the users matching the request, one per message. The
+// users are read first so that the database is not held while slow clients
+// receive them.
+func (UserService) ListUsers(ctx context.Context, req *connect.Request[usersv1.ListUsersRequest], stream *connect.ServerStream[usersv1.ListUsersResponse]) error {
+	var users []user.User
+	var err error
+	switch {
+	case req.Msg.Field != "":
+		idx, ok := user.IndexNamed(req.Msg.Field)
+		if !ok {
+			return fieldError(connect.CodeInvalidArgument, &usersv1.FieldError{Field: "field", Message: "is not an indexed field", Value: req.Msg.Field})
+		}
+		users, err = user.FindByContext(ctx, idx, req.Msg.Value)
+	case req.Msg.IncludeDeleted:
+		users, err = user.AllWithDeletedContext(ctx)
+	default:
+		users, err = user.AllContext(ctx)
+	}
+	if err != nil {
+		return storeError(err)
+	}
+	for i := range users {
+		m, err := toMessage(&users[i])
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&usersv1.ListUsersResponse{User: m}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchUsers streams the changes logged after the requested sequence number
+// and then the changes as they are committed, until the client goes away or
+// falls too far behind
+func (UserService) WatchUsers(ctx context.Context, req *connect.Request[usersv1.WatchUsersRequest], stream *connect.ServerStream[usersv1.WatchUsersResponse]) error {
+	err := feed.Watch(ctx, req.Msg.Since, func(ev user.Event) error {
+		m, err := toEvent(ev)
+		if err != nil {
+			return err
+		}
+		return stream.Send(m)
+	})
+	if err != nil && ctx.Err() == nil {
+		return storeError(err)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package rpc_test
+
+import (
+	"connectrpc.com/connect"
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/christianotieno/go-rest-api/client/clienttest"
+	"github.com/christianotieno/go-rest-api/rpc"
+	usersv1 "github.com/christianotieno/go-rest-api/rpc/users/v1"
+	"github.com/christianotieno/go-rest-api/rpc/users/v1/usersv1connect"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// tenantTransport names the tenant of every request, along with a token
+// claiming it unless the request has credentials
+type tenantTransport struct {
+	id    string
+	token string
+	next  http.RoundTripper
+}
+
+func (t tenantTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(tenant.Header, t.id)
+	if r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// transport returns the transport of a client of a new tenant
+func transport(t *testing.T, next http.RoundTripper) http.RoundTripper {
+	id := clienttest.Tenant(t)
+	return tenantTransport{id, clienttest.Token(t, id), next}
+}
+
+// protocols are the ways clients reach the service: Connect over HTTP/1.1
+// and gRPC over HTTP/2 without TLS, as main.go serves it
+var protocols = []struct {
+	txt  string
+	open func(t *testing.T) usersv1connect.UserServiceClient
+}{
+	{"Connect", func(t *testing.T) usersv1connect.UserServiceClient {
+		srv := newServer(t)
+		hc := &http.Client{Transport: transport(t, http.DefaultTransport)}
+		return usersv1connect.NewUserServiceClient(hc, srv.URL)
+	}},
+	{"gRPC", func(t *testing.T) usersv1connect.UserServiceClient {
+		srv := newServer(t)
+		h2 := &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+		hc := &http.Client{Transport: transport(t, h2)}
+		return usersv1connect.NewUserServiceClient(hc, srv.URL, connect.WithGRPC())
+	}},
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	path, h := rpc.Handler()
+	mux := http.NewServeMux()
+	mux.Handle(path, tenant.Middleware(h))
+	srv := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var ctx = context.Background()
+
+func create(t *testing.T, c usersv1connect.UserServiceClient, u *usersv1.User) *usersv1.User {
+	t.Helper()
+	res, err := c.CreateUser(ctx, connect.NewRequest(&usersv1.CreateUserRequest{User: u}))
+	if err != nil {
+		t.Fatalf("Error creating a user: %s", err)
+	}
+	return res.Msg.User
+}
+
+// fieldOf returns the code of err and the field its detail blames
+func fieldOf(err error) (connect.Code, string) {
+	var ce *connect.Error
+	if !errors.As(err, &ce) {
+		return 0, ""
+	}
+	for _, d := range ce.Details() {
+		if v, err := d.Value(); err == nil {
+			if fe, ok := v.(*usersv1.FieldError); ok {
+				return ce.Code(), fe.Field
+			}
+		}
+	}
+	return ce.Code(), ""
+}
+
+func TestUserService(t *testing.T) {
+	for _, p := range protocols {
+		t.Run(p.txt, func(t *testing.T) {
+			t.Run("CRUD", func(t *testing.T) { testCRUD(t, p.open(t)) })
+			t.Run("Errors", func(t *testing.T) { testErrors(t, p.open(t)) })
+			t.Run("ListUsers", func(t *testing.T) { testList(t, p.open(t)) })
+			t.Run("WatchUsers", func(t *testing.T) { testWatch(t, p.open(t)) })
+		})
+	}
+}
+
+func testCRUD(t *testing.T, c usersv1connect.UserServiceClient) {
+	attrs, _ := structpb.NewStruct(map[string]interface{}{"team": "core"})
+	u := create(t, c, &usersv1.User{Id: "ignored", Name: "Ann", Email: " Ann@Example.com", Attributes: attrs})
+	if u.Id == "ignored" || u.Email != "ann@example.com" || u.Status != "active" || u.Version != 1 || u.CreatedAt == nil {
+		t.Errorf("Expected the stored user, got %v", u)
+	}
+	if u.Attributes.AsMap()["team"] != "core" {
+		t.Errorf("Expected the attributes, got %v", u.Attributes)
+	}
+
+	got, err := c.GetUser(ctx, connect.NewRequest(&usersv1.GetUserRequest{Id: u.Id}))
+	if err != nil {
+		t.Fatalf("Error reading a user: %s", err)
+	}
+	if got.Msg.User.Name != "Ann" || !got.Msg.User.CreatedAt.AsTime().Equal(u.CreatedAt.AsTime()) {
+		t.Errorf("Expected %v, got %v", u, got.Msg.User)
+	}
+
+	testCases := []struct {
+		txt  string
+		user *usersv1.User
+		mask []string
+		role string
+		name string
+	}{
+		{"Masked fields are updated", &usersv1.User{Id: u.Id, Name: "Other", Role: "admin"}, []string{"role"}, "admin", "Ann"},
+		{"Users are replaced without a mask", &usersv1.User{Id: u.Id, Name: "Anna"}, nil, "", "Anna"},
+	}
+	for i, tc := range testCases {
+		t.Log(tc.txt)
+		req := &usersv1.UpdateUserRequest{User: tc.user}
+		if tc.mask != nil {
+			req.UpdateMask = &fieldmaskpb.FieldMask{Paths: tc.mask}
+		}
+		res, err := c.UpdateUser(ctx, connect.NewRequest(req))
+		if err != nil {
+			t.Fatalf("Error updating a user: %s", err)
+		}
+		if got := res.Msg.User; got.Role != tc.role || got.Name != tc.name || got.Version != int64(i+2) {
+			t.Errorf("Expected role %q and name %q, got %v", tc.role, tc.name, got)
+		}
+	}
+
+	if _, err := c.DeleteUser(ctx, connect.NewRequest(&usersv1.DeleteUserRequest{Id: u.Id})); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	_, err = c.GetUser(ctx, connect.NewRequest(&usersv1.GetUserRequest{Id: u.Id}))
+	if code := connect.CodeOf(err); code != connect.CodeNotFound {
+		t.Errorf("Expected %v, got %v", connect.CodeNotFound, err)
+	}
+}
+
+func testErrors(t *testing.T, c usersv1connect.UserServiceClient) {
+	ann := create(t, c, &usersv1.User{Name: "Ann", Email: "ann@example.com"})
+	testCases := []struct {
+		txt   string
+		call  func() error
+		code  connect.Code
+		field string
+	}{
+		{"Names are required", func() error {
+			_, err := c.CreateUser(ctx, connect.NewRequest(&usersv1.CreateUserRequest{User: &usersv1.User{Email: "bob@example.com"}}))
+			return err
+		}, connect.CodeInvalidArgument, "name"},
+		{"Emails are unique", func() error {
+			_, err := c.CreateUser(ctx, connect.NewRequest(&usersv1.CreateUserRequest{User: &usersv1.User{Name: "Bob", Email: "ann@example.com"}}))
+			return err
+		}, connect.CodeAlreadyExists, "email"},
+		{"Ids are checked", func() error {
+			_, err := c.GetUser(ctx, connect.NewRequest(&usersv1.GetUserRequest{Id: "ann"}))
+			return err
+		}, connect.CodeInvalidArgument, "id"},
+		{"Output only fields cannot be masked", func() error {
+			_, err := c.UpdateUser(ctx, connect.NewRequest(&usersv1.UpdateUserRequest{
+				User:       &usersv1.User{Id: ann.Id, Version: 7},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"version"}},
+			}))
+			return err
+		}, connect.CodeInvalidArgument, "update_mask"},
+		{"Missing users are not created by updates", func() error {
+			_, err := c.UpdateUser(ctx, connect.NewRequest(&usersv1.UpdateUserRequest{User: &usersv1.User{Id: "5f1a5b3e8f1b2c3d4e5f6a7b", Name: "Bob"}}))
+			return err
+		}, connect.CodeNotFound, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if code, field := fieldOf(tc.call()); code != tc.code || field != tc.field {
+			t.Errorf("Expected %v on %q, got %v on %q", tc.code, tc.field, code, field)
+		}
+	}
+}
+
+func list(t *testing.T, c usersv1connect.UserServiceClient, req *usersv1.ListUsersRequest) []string {
+	t.Helper()
+	stream, err := c.ListUsers(ctx, connect.NewRequest(req))
+	if err != nil {
+		t.Fatalf("Error listing users: %s", err)
+	}
+	defer stream.Close()
+	names := []string{}
+	for stream.Receive() {
+		names = append(names, stream.Msg().User.Name)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Error listing users: %s", err)
+	}
+	return names
+}
+
+func testList(t *testing.T, c usersv1connect.UserServiceClient) {
+	create(t, c, &usersv1.User{Name: "Ann", Role: "admin"})
+	bob := create(t, c, &usersv1.User{Name: "Bob"})
+	if _, err := c.DeleteUser(ctx, connect.NewRequest(&usersv1.DeleteUserRequest{Id: bob.Id})); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	create(t, c, &usersv1.User{Name: "Cid"})
+
+	testCases := []struct {
+		txt string
+		req *usersv1.ListUsersRequest
+		exp int
+	}{
+		{"All users", &usersv1.ListUsersRequest{}, 2},
+		{"Deleted users too", &usersv1.ListUsersRequest{IncludeDeleted: true}, 3},
+		{"Filtered users", &usersv1.ListUsersRequest{Field: "role", Value: "admin"}, 1},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if names := list(t, c, tc.req); len(names) != tc.exp {
+			t.Errorf("Expected %d users, got %v", tc.exp, names)
+		}
+	}
+
+	stream, err := c.ListUsers(ctx, connect.NewRequest(&usersv1.ListUsersRequest{Field: "nickname"}))
+	if err != nil {
+		t.Fatalf("Error listing users: %s", err)
+	}
+	for stream.Receive() {
+	}
+	if code, field := fieldOf(stream.Err()); code != connect.CodeInvalidArgument || field != "field" {
+		t.Errorf("Expected unindexed fields to be rejected, got %v", stream.Err())
+	}
+}
+
+func testWatch(t *testing.T, c usersv1connect.UserServiceClient) {
+	ann := create(t, c, &usersv1.User{Name: "Ann"})
+	wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stream, err := c.WatchUsers(wctx, connect.NewRequest(&usersv1.WatchUsersRequest{}))
+	if err != nil {
+		t.Fatalf("Error watching users: %s", err)
+	}
+	// closing waits for the server to end the stream, which cancelling does
+	defer func() {
+		cancel()
+		stream.Close()
+	}()
+
+	// the first event is logged before the stream starts, the second is live
+	next := func() *usersv1.WatchUsersResponse {
+		t.Helper()
+		if !stream.Receive() {
+			t.Fatalf("Expected an event, got %v", stream.Err())
+		}
+		return stream.Msg()
+	}
+	ev := next()
+	if ev.Type != usersv1.EventType_EVENT_TYPE_CREATED || ev.UserId != ann.Id || ev.After.GetName() != "Ann" || ev.Before != nil {
+		t.Errorf("Expected the creation of %s, got %v", ann.Id, ev)
+	}
+	_, err = c.UpdateUser(ctx, connect.NewRequest(&usersv1.UpdateUserRequest{
+		User:       &usersv1.User{Id: ann.Id, Name: "Anna"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	}))
+	if err != nil {
+		t.Fatalf("Error updating a user: %s", err)
+	}
+	live := next()
+	if live.Type != usersv1.EventType_EVENT_TYPE_UPDATED || live.Seq <= ev.Seq || live.Before.GetName() != "Ann" || live.After.GetName() != "Anna" {
+		t.Errorf("Expected the update of %s, got %v", ann.Id, live)
+	}
+}
+
+func TestRoleEscalation(t *testing.T) {
+	c := protocols[0].open(t)
+	eve := create(t, c, &usersv1.User{Name: "Eve", Role: "editor"})
+	claims := jwt.MapClaims{"sub": eve.Id, tenant.Claim: "acme"}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tenant.TokenSecret)
+	if err != nil {
+		t.Fatalf("Error signing a token: %s", err)
+	}
+
+	t.Log("A user cannot make themselves an admin")
+	req := connect.NewRequest(&usersv1.UpdateUserRequest{
+		User:       &usersv1.User{Id: eve.Id, Role: "admin"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"role"}},
+	})
+	req.Header().Set("Authorization", "Bearer "+s)
+	if _, err := c.UpdateUser(ctx, req); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Errorf("Expected %v, got %v", connect.CodePermissionDenied, err)
+	}
+	got, err := c.GetUser(ctx, connect.NewRequest(&usersv1.GetUserRequest{Id: eve.Id}))
+	if err != nil {
+		t.Fatalf("Error reading a user: %s", err)
+	}
+	if got.Msg.User.Role != "editor" {
+		t.Errorf("Expected role editor, got %q", got.Msg.User.Role)
+	}
+}
+
+func TestUnauthenticated(t *testing.T) {
+	srv := newServer(t)
+	c := usersv1connect.NewUserServiceClient(http.DefaultClient, srv.URL)
+	id := "5f1a5b3e8f1b2c3d4e5f6a7b"
+
+	testCases := []struct {
+		txt  string
+		call func() error
+	}{
+		{"Creating a user", func() error {
+			_, err := c.CreateUser(ctx, connect.NewRequest(&usersv1.CreateUserRequest{User: &usersv1.User{Name: "Eve"}}))
+			return err
+		}},
+		{"Updating a user", func() error {
+			_, err := c.UpdateUser(ctx, connect.NewRequest(&usersv1.UpdateUserRequest{User: &usersv1.User{Id: id, Name: "Eve"}}))
+			return err
+		}},
+		{"Deleting a user", func() error {
+			_, err := c.DeleteUser(ctx, connect.NewRequest(&usersv1.DeleteUserRequest{Id: id}))
+			return err
+		}},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if err := tc.call(); connect.CodeOf(err) != connect.CodeUnauthenticated {
+			t.Errorf("Expected %v, got %v", connect.CodeUnauthenticated, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is a schema change, applied once in a transaction
+type migration struct {
+	version int
+	name    string
+	up      func(d dialect) []string
+}
+
+// migrations are the schema changes in the order they are applied. Only
+// append to them.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create users",
+		up: func(d dialect) []string {
+			return []string{`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				role TEXT NOT NULL DEFAULT '',
+				email TEXT,
+				status TEXT NOT NULL,
+				attributes TEXT,
+				version INTEGER NOT NULL,
+				created_at ` + d.timestamp + ` NOT NULL,
+				updated_at ` + d.timestamp + ` NOT NULL,
+				deleted_at ` + d.timestamp + `
+			)`}
+		},
+	},
+	{
+		version: 2,
+		name:    "index users",
+		up: func(d dialect) []string {
+			return []string{
+				"CREATE UNIQUE INDEX users_email ON users (email)",
+				"CREATE INDEX users_name ON users (name)",
+				"CREATE INDEX users_role ON users (role)",
+				"CREATE INDEX users_status ON users (status)",
+			}
+		},
+	},
+}
+
+// Version returns the version of the schema
+func (s *Store) Version(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Migrate applies the migrations the database lacks
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at `+s.dialect.timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	current, err := s.Version(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.apply(ctx, m); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) apply(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.up(s.dialect) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), m.version, m.name, now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,297 @@
+// Package sqlstore keeps users in a SQL database: SQLite, through a pure Go
+// driver, for a single node and PostgreSQL for production. Unlike users.db
+// it can be shared by several processes.
+//
+// It implements user.Store, the CRUD subset of the user package, and is not
+// wired into the servers: they keep serving users.db, whose event log,
+// revisions, trash and groups have no SQL counterpart yet.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/user"
+	_ "github.com/lib/pq"
+	"gopkg.in/mgo.v2/bson"
+	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Drivers of the supported databases
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// dialect holds what differs between the supported databases
+type dialect struct {
+	// timestamp is the column type of times
+	timestamp string
+	// numbered reports whether placeholders are $1, $2… instead of ?
+	numbered bool
+	// lock is appended to a select to lock the rows it reads
+	lock string
+}
+
+var dialects = map[string]dialect{
+	SQLite:   {timestamp: "TIMESTAMP"},
+	Postgres: {timestamp: "TIMESTAMPTZ", numbered: true, lock: " FOR UPDATE"},
+}
+
+// ErrDriver is returned when opening a database of an unsupported driver
+var ErrDriver = errors.New("unsupported SQL driver")
+
+// Store keeps users in a SQL database with the semantics of user.Store
+type Store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+var _ user.Store = (*Store)(nil)
+
+// Open connects to the database of driver, SQLite or Postgres, at dsn and
+// migrates it to the current schema
+func Open(driver, dsn string) (*Store, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDriver, driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == SQLite {
+		// SQLite has a single writer; sharing one connection avoids busy errors
+		db.SetMaxOpenConns(1)
+	}
+	s := &Store{db: db, dialect: d}
+	if err := s.Migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// rebind numbers the ? placeholders of query for dialects that need it
+func (s *Store) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+const columns = "id, name, role, email, status, attributes, version, created_at, updated_at, deleted_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scan reads a row of columns into a user
+func scan(row scanner) (*user.User, error) {
+	var (
+		u          user.User
+		id         string
+		email      sql.NullString
+		attributes sql.NullString
+		deletedAt  sql.NullTime
+	)
+	err := row.Scan(&id, &u.Name, &u.Role, &email, &u.Status, &attributes, &u.Version, &u.CreatedAt, &u.UpdatedAt, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !bson.IsObjectIdHex(id) {
+		return nil, fmt.Errorf("invalid user id %q", id)
+	}
+	u.ID = bson.ObjectIdHex(id)
+	u.Email = email.String
+	if attributes.Valid {
+		if err := json.Unmarshal([]byte(attributes.String), &u.Attributes); err != nil {
+			return nil, err
+		}
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return &u, nil
+}
+
+// All retrieves all users except deleted ones, ordered by ID
+func (s *Store) All(ctx context.Context) ([]user.User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+columns+" FROM users WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []user.User{}
+	for rows.Next() {
+		u, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// One returns a single user. Deleted users are reported as not found.
+func (s *Store) One(ctx context.Context, id bson.ObjectId) (*user.User, error) {
+	u, err := one(ctx, s.db, s.rebind("SELECT "+columns+" FROM users WHERE id = ?"), id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	return u, nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// one returns the user with the given id, deleted or not
+func one(ctx context.Context, q queryer, query string, id bson.ObjectId) (*user.User, error) {
+	u, err := scan(q.QueryRowContext(ctx, query, id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, storm.ErrNotFound
+	}
+	return u, err
+}
+
+// Save updates or creates a given user
+func (s *Store) Save(ctx context.Context, u *user.User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := now()
+	before, err := one(ctx, tx, s.rebind("SELECT "+columns+" FROM users WHERE id = ?"+s.dialect.lock), u.ID)
+	switch err {
+	case nil:
+		if before.Deleted() {
+			return user.ErrDeleted
+		}
+		u.Version = before.Version + 1
+		u.CreatedAt = before.CreatedAt
+	case storm.ErrNotFound:
+		u.Version = 1
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = now
+		}
+		u.CreatedAt = u.CreatedAt.UTC().Truncate(time.Microsecond)
+	default:
+		return err
+	}
+	u.UpdatedAt = now
+	u.DeletedAt = nil
+
+	if u.Email != "" {
+		var other string
+		err := tx.QueryRowContext(ctx, s.rebind("SELECT id FROM users WHERE email = ? AND id <> ?"), u.Email, u.ID.Hex()).Scan(&other)
+		if err == nil {
+			return &user.ConflictError{Field: "email", Value: u.Email}
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	attributes, err := attributesColumn(u.Attributes)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{u.Name, u.Role, nullable(u.Email), u.Status, attributes, u.Version, u.CreatedAt, u.UpdatedAt, u.ID.Hex()}
+	query := "UPDATE users SET name = ?, role = ?, email = ?, status = ?, attributes = ?, version = ?, created_at = ?, updated_at = ? WHERE id = ?"
+	if before == nil {
+		query = "INSERT INTO users (name, role, email, status, attributes, version, created_at, updated_at, id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(query), args...); err != nil {
+		if taken(err) {
+			return &user.ConflictError{Field: "email", Value: u.Email}
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// taken reports whether err violates the unique email index, which a
+// concurrent save can do after the check
+func taken(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, `"users_email"`) || strings.Contains(msg, "users.email")
+}
+
+// Delete moves a given user to the trash
+func (s *Store) Delete(ctx context.Context, id bson.ObjectId) error {
+	now := now()
+	res, err := s.db.ExecContext(ctx,
+		s.rebind("UPDATE users SET version = version + 1, updated_at = ?, deleted_at = ? WHERE id = ? AND deleted_at IS NULL"),
+		now, now, id.Hex(),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storm.ErrNotFound
+	}
+	return nil
+}
+
+// now is the current time at the microsecond precision of PostgreSQL, so
+// that saved users equal the ones read back
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// nullable stores empty strings as NULL, which unique indexes allow more
+// than once
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// attributesColumn encodes attributes as JSON text
+func attributesColumn(attrs map[string]any) (interface{}, error) {
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	bd, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return string(bd), nil
+}
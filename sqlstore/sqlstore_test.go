@@ -0,0 +1,80 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/user/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) user.Store {
+		s, err := Open(SQLite, filepath.Join(t.TempDir(), "users.sqlite"))
+		if err != nil {
+			t.Fatalf("Error opening the database: %s", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+// TestPostgres runs against the empty database of POSTGRES_TEST_DSN, such
+// as postgres://localhost/users_test?sslmode=disable
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	storetest.Run(t, func(t *testing.T) user.Store {
+		s, err := Open(Postgres, dsn)
+		if err != nil {
+			t.Fatalf("Error opening the database: %s", err)
+		}
+		t.Cleanup(func() {
+			s.db.Exec("DROP TABLE users, schema_migrations")
+			s.Close()
+		})
+		return s
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.sqlite")
+	for i := 0; i < 2; i++ {
+		t.Logf("Open %d", i+1)
+		s, err := Open(SQLite, path)
+		if err != nil {
+			t.Fatalf("Error opening the database: %s", err)
+		}
+		version, err := s.Version(context.Background())
+		if err != nil || version != len(migrations) {
+			t.Errorf("Expected version %d, got %d, %v", len(migrations), version, err)
+		}
+		s.Close()
+	}
+
+	if _, err := Open("mysql", ""); !errors.Is(err, ErrDriver) {
+		t.Errorf("Expected error %v, got %v", ErrDriver, err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	testCases := []struct {
+		txt      string
+		dialect  dialect
+		expected string
+	}{
+		{"SQLite keeps ?", dialects[SQLite], "UPDATE users SET name = ? WHERE id = ?"},
+		{"PostgreSQL numbers placeholders", dialects[Postgres], "UPDATE users SET name = $1 WHERE id = $2"},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		s := &Store{dialect: tc.dialect}
+		if got := s.rebind("UPDATE users SET name = ? WHERE id = ?"); got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
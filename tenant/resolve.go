@@ -0,0 +1,141 @@
+package tenant
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Header is the request header naming the tenant
+const Header = "X-Tenant-ID"
+
+// Claim is the claim of bearer tokens naming the tenant
+const Claim = "tenant"
+
+// Domain is the parent domain of tenant subdomains, such as
+// api.example.com for acme.api.example.com. Subdomains are ignored when it
+// is empty.
+var Domain = ""
+
+// TokenSecret is the HMAC key of bearer tokens carrying a tenant claim.
+// Tokens are ignored when it is empty, so that only the default tenant can
+// be reached.
+var TokenSecret []byte
+
+// Errors of tenant resolution
+var (
+	// Returns ErrInvalidToken when a bearer token is not signed with TokenSecret
+	ErrInvalidToken = errors.New("invalid tenant token")
+	// Returns ErrMismatch when the request names another tenant than its token
+	ErrMismatch = errors.New("request names different tenants")
+	// Returns ErrUnclaimed when the request names a tenant without a token
+	// claiming it
+	ErrUnclaimed = errors.New("a token claiming the tenant is required")
+)
+
+// Resolve returns the id of the tenant of the request, the claim of its
+// bearer token. The X-Tenant-ID header and the subdomain are not
+// authenticated: they are only accepted when they name the claimed tenant,
+// and naming a tenant without a claim is an error. The id is empty for the
+// default tenant.
+func Resolve(r *http.Request) (string, error) {
+	claim, err := claimed(r)
+	if err != nil {
+		return "", err
+	}
+	claim = strings.ToLower(claim)
+	for _, id := range []string{r.Header.Get(Header), subdomain(r.Host)} {
+		switch {
+		case id == "":
+		case claim == "":
+			return "", ErrUnclaimed
+		case strings.ToLower(id) != claim:
+			return "", ErrMismatch
+		}
+	}
+	return claim, nil
+}
+
+// Claims returns the claims of the bearer token of the request, or nil if
+// it has none or TokenSecret is empty
+func Claims(r *http.Request) (jwt.MapClaims, error) {
+	if len(TokenSecret) == 0 {
+		return nil, nil
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, nil
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		return TokenSecret, nil
+	})
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// claimed returns the tenant claim of the bearer token of the request
+func claimed(r *http.Request) (string, error) {
+	claims, err := Claims(r)
+	if err != nil {
+		return "", err
+	}
+	id, _ := claims[Claim].(string)
+	return id, nil
+}
+
+// subdomain returns the label of host below Domain
+func subdomain(host string) string {
+	if Domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	label := strings.TrimSuffix(host, "."+strings.ToLower(Domain))
+	if label == host || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// Middleware resolves the tenant of requests and carries it in their
+// context. Requests naming an unknown tenant get 404 Not Found, those
+// naming a tenant without a token claiming it 401 Unauthorized, and those
+// with an invalid token or naming different tenants 403 Forbidden.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := Resolve(r)
+		if err == ErrUnclaimed {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		t := Default
+		if id != "" {
+			found, err := One(id)
+			switch {
+			case err == ErrUnknownTenant:
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			case err != nil:
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			t = *found
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	})
+}
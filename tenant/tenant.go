@@ -0,0 +1,218 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// Tenant is a customer with its own namespace of users. Each tenant keeps
+// its data in its own directory of databases, so that nothing read from
+// one can come from another.
+type Tenant struct {
+	ID   string `json:"id" storm:"id"`
+	Name string `json:"name"`
+	// MaxUsers is the quota of users that are not deleted, 0 for no limit
+	MaxUsers  int       `json:"max_users,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Default is the tenant of requests that name none. Its databases are the
+// ones of a deployment without tenants.
+var Default = Tenant{}
+
+// DBPath is the database of the tenant registry
+var DBPath = "tenants.db"
+
+// Dir is the directory holding a directory of databases per tenant
+var Dir = "tenants"
+
+// Errors used by tenants
+var (
+	// Returns ErrInvalidTenant when a tenant has an invalid id or quota
+	ErrInvalidTenant = errors.New("tenant is invalid")
+	// Returns ErrUnknownTenant when no tenant has the requested id
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// Returns ErrExists when creating a tenant whose id is taken
+	ErrExists = errors.New("tenant already exists")
+)
+
+// validID matches ids that are valid DNS labels, so that every tenant can
+// have a subdomain
+var validID = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Validate checks the id and quota of the tenant
+func (t *Tenant) Validate() error {
+	if !validID.MatchString(t.ID) || t.MaxUsers < 0 {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// Path returns where the tenant keeps the database file named like file.
+// The default tenant keeps file itself.
+func (t Tenant) Path(file string) string {
+	if t.ID == "" {
+		return file
+	}
+	return filepath.Join(Dir, t.ID, filepath.Base(file))
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the tenant
+func NewContext(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant carried by ctx, or the default tenant
+func FromContext(ctx context.Context) Tenant {
+	if t, ok := ctx.Value(contextKey{}).(Tenant); ok {
+		return t
+	}
+	return Default
+}
+
+// Create registers a new tenant and makes its directory
+func (t *Tenant) Create() error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.One("ID", t.ID, new(Tenant)); err == nil {
+		return ErrExists
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+	t.CreatedAt = time.Now().UTC()
+	if err := os.MkdirAll(filepath.Join(Dir, t.ID), 0o755); err != nil {
+		return err
+	}
+	if err := tx.Save(t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update changes the name and quota of a tenant
+func (t *Tenant) Update() error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing := new(Tenant)
+	if err := tx.One("ID", t.ID, existing); err != nil {
+		return notFound(err)
+	}
+	t.CreatedAt = existing.CreatedAt
+	if err := tx.Save(t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// All returns the registered tenants
+func All() ([]Tenant, error) {
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	tenants := []Tenant{}
+	if err := db.All(&tenants); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// One returns the tenant with the given id
+func One(id string) (*Tenant, error) {
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	t := new(Tenant)
+	if err := db.One("ID", id, t); err != nil {
+		return nil, notFound(err)
+	}
+	return t, nil
+}
+
+// Delete unregisters a tenant and removes all its data
+func Delete(id string) error {
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	t := new(Tenant)
+	if err := db.One("ID", id, t); err != nil {
+		return notFound(err)
+	}
+	if err := db.DeleteStruct(t); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(Dir, t.ID))
+}
+
+// Each calls fn with the context of every tenant, the default one first,
+// stopping at the first error
+func Each(ctx context.Context, fn func(ctx context.Context) error) error {
+	tenants, err := All()
+	if err != nil {
+		return err
+	}
+	if err := fn(NewContext(ctx, Default)); err != nil {
+		return err
+	}
+	for _, t := range tenants {
+		if err := fn(NewContext(ctx, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func notFound(err error) error {
+	if err == storm.ErrNotFound {
+		return ErrUnknownTenant
+	}
+	return err
+}
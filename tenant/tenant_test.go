@@ -0,0 +1,184 @@
+package tenant
+
+import (
+	"context"
+	"github.com/golang-jwt/jwt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Remove(DBPath)
+	code := m.Run()
+	os.Remove(DBPath)
+	os.RemoveAll(Dir)
+	os.Exit(code)
+}
+
+func TestPath(t *testing.T) {
+	testCases := []struct {
+		txt      string
+		tenant   Tenant
+		expected string
+	}{
+		{"The default tenant keeps the file", Default, "users.db"},
+		{"A tenant has a directory", Tenant{ID: "acme"}, filepath.Join(Dir, "acme", "users.db")},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if got := tc.tenant.Path("users.db"); got != tc.expected {
+			t.Errorf("Expected %s, got %s", tc.expected, got)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	os.Remove(DBPath)
+
+	testCases := []struct {
+		txt    string
+		tenant Tenant
+		err    error
+	}{
+		{"A valid tenant is created", Tenant{ID: "acme", Name: "Acme", MaxUsers: 10}, nil},
+		{"An id is unique", Tenant{ID: "acme"}, ErrExists},
+		{"An id is a DNS label", Tenant{ID: "Acme Inc"}, ErrInvalidTenant},
+		{"A path is not an id", Tenant{ID: "../acme"}, ErrInvalidTenant},
+		{"A quota is not negative", Tenant{ID: "globex", MaxUsers: -1}, ErrInvalidTenant},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if err := tc.tenant.Create(); err != tc.err {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(Dir, "acme")); err != nil {
+		t.Errorf("Expected the tenant directory to be made, got %s", err)
+	}
+	update := Tenant{ID: "acme", Name: "Acme Corp", MaxUsers: 20}
+	if err := update.Update(); err != nil {
+		t.Fatalf("Error updating a tenant: %s", err)
+	}
+	got, err := One("acme")
+	if err != nil || got.Name != "Acme Corp" || got.MaxUsers != 20 || got.CreatedAt.IsZero() {
+		t.Errorf("Expected the updated tenant, got %+v, %v", got, err)
+	}
+	if err := Delete("acme"); err != nil {
+		t.Fatalf("Error deleting a tenant: %s", err)
+	}
+	if _, err := One("acme"); err != ErrUnknownTenant {
+		t.Errorf("Expected error %v, got %v", ErrUnknownTenant, err)
+	}
+	if _, err := os.Stat(filepath.Join(Dir, "acme")); !os.IsNotExist(err) {
+		t.Errorf("Expected the tenant directory to be removed, got %v", err)
+	}
+}
+
+func token(t *testing.T, secret string, tenant string) string {
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{Claim: tenant}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + s
+}
+
+func TestResolve(t *testing.T) {
+	Domain = "api.example.com"
+	TokenSecret = []byte("secret")
+	defer func() {
+		Domain = ""
+		TokenSecret = nil
+	}()
+
+	testCases := []struct {
+		txt      string
+		host     string
+		header   string
+		auth     string
+		expected string
+		err      error
+	}{
+		{"Nothing names the default tenant", "api.example.com", "", "", "", nil},
+		{"The header needs a claim", "api.example.com", "acme", "", "", ErrUnclaimed},
+		{"The subdomain needs a claim", "acme.api.example.com:8080", "", "", "", ErrUnclaimed},
+		{"A token without a tenant claims none", "api.example.com", "acme", token(t, "secret", ""), "", ErrUnclaimed},
+		{"Other domains are ignored", "acme.example.org", "", "", "", nil},
+		{"The token claim names a tenant", "api.example.com", "", token(t, "secret", "acme"), "acme", nil},
+		{"Agreeing names are accepted", "acme.api.example.com", "ACME", token(t, "secret", "acme"), "acme", nil},
+		{"The header cannot override the token", "api.example.com", "globex", token(t, "secret", "acme"), "", ErrMismatch},
+		{"The subdomain cannot override the token", "globex.api.example.com", "acme", token(t, "secret", "acme"), "", ErrMismatch},
+		{"Tokens are verified", "api.example.com", "", token(t, "guess", "acme"), "", ErrInvalidToken},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Host = tc.host
+		if tc.header != "" {
+			r.Header.Set(Header, tc.header)
+		}
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		got, err := Resolve(r)
+		if got != tc.expected || err != tc.err {
+			t.Errorf("Expected %q, %v, got %q, %v", tc.expected, tc.err, got, err)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	os.Remove(DBPath)
+	acme := Tenant{ID: "acme"}
+	if err := acme.Create(); err != nil {
+		t.Fatalf("Error creating a tenant: %s", err)
+	}
+
+	TokenSecret = []byte("secret")
+	defer func() { TokenSecret = nil }()
+
+	var got Tenant
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+	testCases := []struct {
+		txt    string
+		header string
+		auth   string
+		status int
+		tenant string
+	}{
+		{"No tenant is the default one", "", "", http.StatusOK, ""},
+		{"A known tenant is carried", "acme", token(t, "secret", "acme"), http.StatusOK, "acme"},
+		{"An unknown tenant is not found", "", token(t, "secret", "globex"), http.StatusNotFound, ""},
+		{"A header without a token is unauthorized", "acme", "", http.StatusUnauthorized, ""},
+		{"Basic auth cannot reach a tenant", "acme", "Basic UGV0ZXI6cGFzc3dvcmQ=", http.StatusUnauthorized, ""},
+		{"A header naming another tenant is forbidden", "globex", token(t, "secret", "acme"), http.StatusForbidden, ""},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		got = Tenant{ID: "unset"}
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		if tc.header != "" {
+			r.Header.Set(Header, tc.header)
+		}
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("Expected status %d, got %d", tc.status, w.Code)
+		}
+		if tc.status == http.StatusOK && got.ID != tc.tenant {
+			t.Errorf("Expected tenant %q, got %q", tc.tenant, got.ID)
+		}
+	}
+
+	if FromContext(context.Background()).ID != "" {
+		t.Error("Expected the default tenant without one in the context")
+	}
+}
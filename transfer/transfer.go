@@ -0,0 +1,342 @@
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/christianotieno/go-rest-api/cache"
+	"github.com/christianotieno/go-rest-api/decode"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Media types of the export and import formats
+const (
+	NDJSON = "application/x-ndjson"
+	CSV    = "text/csv"
+)
+
+// ErrorTrailer reports why an export stopped before its end. The status is
+// already sent by then, so clients must check it to know the export is whole.
+const ErrorTrailer = "X-Export-Error"
+
+// Columns are the CSV columns, named after the JSON fields of a user.
+// Attributes are written as a JSON object.
+var Columns = []string{"id", "name", "role", "email", "status", "attributes"}
+
+const (
+	// chunkSize is the number of imported users saved per transaction
+	chunkSize = 500
+	// progressEvery is the number of lines between two progress reports
+	progressEvery = 1000
+	// maxLine is the maximum size of a single NDJSON line
+	maxLine = 1 << 20
+)
+
+// importDecoding are the rules for imported records. Unlike request bodies
+// they may carry an id so exports can be restored as they are.
+var importDecoding = decode.Options{}
+
+// exportFormat picks the export format from the format query parameter or
+// the Accept header
+func exportFormat(r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("format") {
+	case "ndjson":
+		return NDJSON, true
+	case "csv":
+		return CSV, true
+	case "":
+	default:
+		return "", false
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return NDJSON, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case NDJSON, "*/*", "application/*":
+			return NDJSON, true
+		case CSV, "text/*":
+			return CSV, true
+		}
+	}
+	return "", false
+}
+
+// record returns the CSV fields of a user in column order
+func record(u *user.User) ([]string, error) {
+	bd, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bd, &m); err != nil {
+		return nil, err
+	}
+	rec := make([]string, len(Columns))
+	for i, c := range Columns {
+		switch v := m[c].(type) {
+		case nil:
+		case string:
+			rec[i] = v
+		default:
+			js, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			rec[i] = string(js)
+		}
+	}
+	return rec, nil
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Export streams every user as NDJSON or CSV
+func Export(w http.ResponseWriter, r *http.Request) {
+	mt, ok := exportFormat(r)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+		return
+	}
+	ext := "ndjson"
+	if mt == CSV {
+		ext = "csv"
+	}
+	w.Header().Set("Content-Type", mt)
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+ext+`"`)
+	w.Header().Set("Trailer", ErrorTrailer)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	n := 0
+	var write func(u *user.User) error
+	if mt == NDJSON {
+		enc := json.NewEncoder(w)
+		write = func(u *user.User) error {
+			return enc.Encode(u)
+		}
+	} else {
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		if err := cw.Write(Columns); err != nil {
+			return
+		}
+		write = func(u *user.User) error {
+			rec, err := record(u)
+			if err != nil {
+				return err
+			}
+			if err := cw.Write(rec); err != nil {
+				return err
+			}
+			if n%progressEvery == 0 {
+				cw.Flush()
+			}
+			return cw.Error()
+		}
+	}
+	err := user.EachContext(r.Context(), func(u *user.User) error {
+		n++
+		if err := write(u); err != nil {
+			return err
+		}
+		if n%progressEvery == 0 {
+			flush(w)
+		}
+		return nil
+	})
+	if err != nil {
+		w.Header().Set(ErrorTrailer, err.Error())
+	}
+}
+
+// LineError reports an imported line that was rejected
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Progress counts the lines handled so far
+type Progress struct {
+	Lines    int  `json:"lines"`
+	Valid    int  `json:"valid"`
+	Failed   int  `json:"failed"`
+	Imported int  `json:"imported"`
+	DryRun   bool `json:"dry_run"`
+}
+
+// reader yields the records of an upload as JSON documents with their line number
+type reader func() (line int, doc []byte, err error)
+
+func ndjsonReader(body io.Reader) reader {
+	s := bufio.NewScanner(body)
+	s.Buffer(make([]byte, 64*1024), maxLine)
+	line := 0
+	return func() (int, []byte, error) {
+		for s.Scan() {
+			line++
+			doc := strings.TrimSpace(s.Text())
+			if doc != "" {
+				return line, []byte(doc), nil
+			}
+		}
+		if err := s.Err(); err != nil {
+			return line + 1, nil, err
+		}
+		return line, nil, io.EOF
+	}
+}
+
+func csvReader(body io.Reader) (reader, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	return func() (int, []byte, error) {
+		rec, err := cr.Read()
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			return line, nil, err
+		}
+		m := map[string]interface{}{}
+		for i, c := range header {
+			if i >= len(rec) || rec[i] == "" {
+				continue
+			}
+			c = strings.TrimSpace(c)
+			if c == "attributes" {
+				m[c] = json.RawMessage(rec[i])
+			} else {
+				m[c] = rec[i]
+			}
+		}
+		doc, err := json.Marshal(m)
+		return line, doc, err
+	}, nil
+}
+
+// malformed reports whether err rejects a line the reader cannot go past,
+// unlike the errors of the upload itself
+func malformed(err error) bool {
+	_, ok := err.(*csv.ParseError)
+	return ok || err == bufio.ErrTooLong
+}
+
+// Import reads users from an NDJSON or CSV upload, one record per line.
+// Every line is validated on its own; rejected lines, periodic progress and
+// a final summary are streamed back as NDJSON. With dry_run=true nothing is
+// saved.
+func Import(w http.ResponseWriter, r *http.Request) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mt != NDJSON && mt != CSV) {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.Body == nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	next := ndjsonReader(r.Body)
+	if mt == CSV {
+		next, err = csvReader(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", NDJSON)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	report := func(v interface{}) {
+		enc.Encode(v)
+		flush(w)
+	}
+
+	p := Progress{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	pending := []*user.User{}
+	save := func() error {
+		if p.DryRun || len(pending) == 0 {
+			return nil
+		}
+		if err := user.SaveAllContext(r.Context(), pending); err != nil {
+			return err
+		}
+		p.Imported += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+	defer func() {
+		if p.Imported > 0 {
+			cache.Clean()
+		}
+	}()
+
+	for {
+		line, doc, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !malformed(err) {
+			report(jsonResponse{"error": fmt.Sprintf("reading line %d: %s", line, err)})
+			return
+		}
+		p.Lines++
+		u := new(user.User)
+		if err == nil {
+			err = decode.Unmarshal(doc, u, importDecoding)
+		}
+		if err == nil {
+			err = u.Validate()
+		}
+		if err != nil {
+			p.Failed++
+			report(jsonResponse{"line_error": LineError{Line: line, Error: err.Error()}})
+			if malformed(err) {
+				break
+			}
+			continue
+		}
+		p.Valid++
+		if !u.ID.Valid() {
+			u.ID = bson.NewObjectId()
+		}
+		if !p.DryRun {
+			pending = append(pending, u)
+		}
+		if len(pending) == chunkSize {
+			if err := save(); err != nil {
+				report(jsonResponse{"error": fmt.Sprintf("saving users: %s", err)})
+				return
+			}
+		}
+		if p.Lines%progressEvery == 0 {
+			report(jsonResponse{"progress": p})
+		}
+	}
+	if err := save(); err != nil {
+		report(jsonResponse{"error": fmt.Sprintf("saving users: %s", err)})
+		return
+	}
+	report(jsonResponse{"summary": p})
+}
+
+type jsonResponse map[string]interface{}
@@ -0,0 +1,179 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const dbPath = "users.db"
+
+func TestMain(m *testing.M) {
+	os.Remove(dbPath)
+	code := m.Run()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+func lines(t *testing.T, body *bytes.Buffer) []map[string]json.RawMessage {
+	t.Helper()
+	out := []map[string]json.RawMessage{}
+	s := bufio.NewScanner(body)
+	for s.Scan() {
+		m := map[string]json.RawMessage{}
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatalf("Invalid NDJSON line %s: %s", s.Text(), err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestImportAndExport(t *testing.T) {
+	id := bson.NewObjectId()
+	body := strings.Join([]string{
+		`{"id":"` + id.Hex() + `","name":"John","role":"Tester","email":"John@Example.com","attributes":{"team":"core"}}`,
+		`{"name":"Jane","role":"Developer"}`,
+		``,
+		`{"name":""}`,
+		`{"name":"Bob","age":37}`,
+	}, "\n")
+
+	t.Log("Dry run")
+	r := httptest.NewRequest(http.MethodPost, "/users/import?dry_run=true", strings.NewReader(body))
+	r.Header.Set("Content-Type", NDJSON)
+	w := httptest.NewRecorder()
+	Import(w, r)
+	out := lines(t, w.Body)
+	if len(out) != 3 {
+		t.Fatalf("Expected 2 line errors and a summary, got %d lines", len(out))
+	}
+	p := Progress{}
+	json.Unmarshal(out[2]["summary"], &p)
+	if p.Lines != 4 || p.Valid != 2 || p.Failed != 2 || p.Imported != 0 || !p.DryRun {
+		t.Errorf("Unexpected dry run summary %+v", p)
+	}
+	le := LineError{}
+	json.Unmarshal(out[1]["line_error"], &le)
+	if le.Line != 5 {
+		t.Errorf("Expected line 5 to be rejected, got %+v", le)
+	}
+	users, _ := user.All()
+	if len(users) != 0 {
+		t.Errorf("Expected dry run not to save users, got %d", len(users))
+	}
+
+	t.Log("Import")
+	r = httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", NDJSON)
+	w = httptest.NewRecorder()
+	Import(w, r)
+	out = lines(t, w.Body)
+	json.Unmarshal(out[len(out)-1]["summary"], &p)
+	if p.Imported != 2 {
+		t.Errorf("Expected 2 users to be imported, got %+v", p)
+	}
+	u, err := user.One(id)
+	if err != nil || u.Name != "John" {
+		t.Errorf("Expected imported user to keep its id, got %+v (%v)", u, err)
+	}
+
+	t.Log("Export NDJSON")
+	r = httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	w = httptest.NewRecorder()
+	Export(w, r)
+	if w.Header().Get("Content-Type") != NDJSON {
+		t.Errorf("Expected content type %s, got %s", NDJSON, w.Header().Get("Content-Type"))
+	}
+	if n := len(lines(t, w.Body)); n != 2 {
+		t.Errorf("Expected 2 exported users, got %d", n)
+	}
+
+	t.Log("Export CSV")
+	r = httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	r.Header.Set("Accept", "text/csv")
+	w = httptest.NewRecorder()
+	Export(w, r)
+	exported := w.Body.String()
+	if !strings.HasPrefix(exported, "id,name,role,email,status,attributes\n") ||
+		!strings.Contains(exported, id.Hex()+`,John,Tester,john@example.com,active,"{""team"":""core""}"`+"\n") {
+		t.Errorf("Unexpected CSV export %q", exported)
+	}
+
+	t.Log("Import CSV")
+	os.Remove(dbPath)
+	r = httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(exported))
+	r.Header.Set("Content-Type", CSV)
+	w = httptest.NewRecorder()
+	Import(w, r)
+	out = lines(t, w.Body)
+	json.Unmarshal(out[len(out)-1]["summary"], &p)
+	if p.Imported != 2 || p.Failed != 0 {
+		t.Errorf("Expected the CSV export to be imported back, got %+v", p)
+	}
+
+	t.Log("Unsupported formats")
+	r = httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	r.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	Export(w, r)
+	if w.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected status %d, got %d", http.StatusNotAcceptable, w.Code)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	Import(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
+
+func TestImportReadError(t *testing.T) {
+	testCases := []struct {
+		txt         string
+		contentType string
+		body        string
+	}{
+		{"NDJSON", NDJSON, `{"name":"Ann"}` + "\n"},
+		{"CSV", CSV, "name\nAnn\n"},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		body := io.MultiReader(strings.NewReader(tc.body), iotest.ErrReader(errors.New("connection reset")))
+		r := httptest.NewRequest(http.MethodPost, "/users/import", body)
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		Import(w, r)
+		out := lines(t, w.Body)
+		if len(out) != 1 || !strings.Contains(string(out[0]["error"]), "connection reset") {
+			t.Errorf("Expected the import to stop with the read error, got %s", out)
+		}
+	}
+}
+
+func TestExportError(t *testing.T) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	defer db.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	w := httptest.NewRecorder()
+	Export(w, r)
+	if got := w.Result().Trailer.Get(ErrorTrailer); got == "" {
+		t.Errorf("Expected the %s trailer to report the locked database", ErrorTrailer)
+	}
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+)
+
+// Types of custom attributes
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Attribute declares a custom attribute
+type Attribute struct {
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+}
+
+// Schema declares the custom attributes users may carry, by name
+type Schema map[string]Attribute
+
+// AttributeSchema is the schema user attributes are validated against. When
+// nil any attribute is accepted as long as its name is valid and its value
+// is a string, number or boolean.
+var AttributeSchema Schema
+
+// Limits of custom attributes
+const (
+	maxAttributes      = 64
+	maxAttributeLength = 1024
+)
+
+var attributeName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// LoadSchema reads a JSON attribute schema from a file
+func LoadSchema(path string) (Schema, error) {
+	bd, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := Schema{}
+	if err := json.Unmarshal(bd, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// typeOf returns the attribute type of a decoded JSON value
+func typeOf(v any) string {
+	switch v.(type) {
+	case string:
+		return TypeString
+	case float64, int, int64, json.Number:
+		return TypeNumber
+	case bool:
+		return TypeBoolean
+	}
+	return ""
+}
+
+// Validate checks attributes against the schema
+func (s Schema) Validate(attrs map[string]any) error {
+	if len(attrs) > maxAttributes {
+		return &FieldError{Field: "attributes", Message: "has too many entries"}
+	}
+	for name, v := range attrs {
+		field := "attributes." + name
+		if !attributeName.MatchString(name) {
+			return &FieldError{Field: field, Message: "is not a valid attribute name"}
+		}
+		t := typeOf(v)
+		if t == "" {
+			return &FieldError{Field: field, Message: "must be a string, number or boolean"}
+		}
+		if str, ok := v.(string); ok && len(str) > maxAttributeLength {
+			return &FieldError{Field: field, Message: "is too long"}
+		}
+		if s == nil {
+			continue
+		}
+		a, ok := s[name]
+		if !ok {
+			return &FieldError{Field: field, Message: "is not declared"}
+		}
+		if t != a.Type {
+			return &FieldError{Field: field, Message: "must be a " + a.Type}
+		}
+		if str, ok := v.(string); ok && len(a.Enum) > 0 && !contains(a.Enum, str) {
+			return &FieldError{Field: field, Message: "is not an allowed value"}
+		}
+	}
+	for name, a := range s {
+		if _, ok := attrs[name]; a.Required && !ok {
+			return &FieldError{Field: "attributes." + name, Message: "is required"}
+		}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
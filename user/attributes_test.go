@@ -0,0 +1,83 @@
+package user
+
+import (
+	"errors"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	defer func(s Schema) { AttributeSchema = s }(AttributeSchema)
+	schema := Schema{
+		"plan":  {Type: TypeString, Required: true, Enum: []string{"free", "pro"}},
+		"seats": {Type: TypeNumber},
+	}
+	cases := []struct {
+		txt    string
+		schema Schema
+		u      User
+		field  string
+	}{
+		{"valid user", nil, User{Name: "Ann", Email: " Ann@Example.com "}, ""},
+		{"missing name", nil, User{}, "name"},
+		{"invalid email", nil, User{Name: "Ann", Email: "Ann <ann@example.com>"}, "email"},
+		{"unknown status", nil, User{Name: "Ann", Status: "banned"}, "status"},
+		{"free-form attributes", nil, User{Name: "Ann", Attributes: map[string]any{"team": "core", "admin": true}}, ""},
+		{"nested attribute", nil, User{Name: "Ann", Attributes: map[string]any{"team": map[string]any{}}}, "attributes.team"},
+		{"invalid attribute name", nil, User{Name: "Ann", Attributes: map[string]any{"Team": "core"}}, "attributes.Team"},
+		{"declared attributes", schema, User{Name: "Ann", Attributes: map[string]any{"plan": "pro", "seats": 3.0}}, ""},
+		{"undeclared attribute", schema, User{Name: "Ann", Attributes: map[string]any{"plan": "pro", "team": "core"}}, "attributes.team"},
+		{"wrong attribute type", schema, User{Name: "Ann", Attributes: map[string]any{"plan": "pro", "seats": "3"}}, "attributes.seats"},
+		{"value not in enum", schema, User{Name: "Ann", Attributes: map[string]any{"plan": "gold"}}, "attributes.plan"},
+		{"required attribute", schema, User{Name: "Ann"}, "attributes.plan"},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		AttributeSchema = tc.schema
+		err := tc.u.Validate()
+		var fe *FieldError
+		switch {
+		case tc.field == "" && err != nil:
+			t.Errorf("Expected no error, got %v", err)
+		case tc.field != "" && (!errors.As(err, &fe) || fe.Field != tc.field):
+			t.Errorf("Expected an error on %s, got %v", tc.field, err)
+		case tc.field != "" && !errors.Is(err, ErrRecordInvalid):
+			t.Errorf("Expected error %v to be %v", err, ErrRecordInvalid)
+		}
+	}
+
+	u := User{Name: "Ann", Email: " Ann@Example.com "}
+	u.Validate()
+	if u.Email != "ann@example.com" || u.Status != StatusActive {
+		t.Errorf("Expected a normalized email and the active status, got %q and %q", u.Email, u.Status)
+	}
+}
+
+func TestUniqueEmail(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+
+	ann := &User{ID: bson.NewObjectId(), Name: "Ann", Email: "ann@example.com"}
+	if err := ann.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	if ann.CreatedAt.IsZero() || !ann.UpdatedAt.Equal(ann.CreatedAt) {
+		t.Errorf("Expected the creation time to be set, got %v and %v", ann.CreatedAt, ann.UpdatedAt)
+	}
+	created := ann.CreatedAt
+	ann.Role = "Admin"
+	if err := ann.Save(); err != nil {
+		t.Fatalf("Error updating a user: %s", err)
+	}
+	if !ann.CreatedAt.Equal(created) || !ann.UpdatedAt.After(created) {
+		t.Errorf("Expected only the update time to change, got %v and %v", ann.CreatedAt, ann.UpdatedAt)
+	}
+
+	other := &User{ID: bson.NewObjectId(), Name: "Other Ann", Email: "ANN@example.com"}
+	err := other.Save()
+	var ce *ConflictError
+	if !errors.As(err, &ce) || ce.Field != "email" || !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a conflict on email, got %v", err)
+	}
+}
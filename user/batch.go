@@ -0,0 +1,154 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Operations supported in a batch
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Errors used by batches
+var (
+	// Returns ErrUnknownOp when a batch operation is not create, update or delete
+	ErrUnknownOp = errors.New("unknown batch operation")
+	// Returns ErrBatchAborted for the operations rolled back with a failed atomic batch
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
+// Op is a single operation of a batch
+type Op struct {
+	Op   string        `json:"op"`
+	ID   bson.ObjectId `json:"id,omitempty"`
+	User *User         `json:"user,omitempty"`
+}
+
+// Result is the outcome of a single batch operation
+type Result struct {
+	Op   string
+	ID   bson.ObjectId
+	User *User
+	Err  error
+}
+
+// Batch applies the operations in order. An atomic batch runs in a single
+// transaction and is rolled back as a whole if any operation fails; otherwise
+// every operation is applied in a transaction of its own. The returned error
+// is non-nil when the batch could not be applied, in which case the other
+// operations of an atomic batch fail with ErrBatchAborted.
+func Batch(ops []Op, atomic bool) ([]Result, error) {
+	return BatchContext(context.Background(), ops, atomic)
+}
+
+// BatchContext is Batch with the changes attributed to the audit source
+// carried by ctx, in the database of its tenant
+func BatchContext(ctx context.Context, ops []Op, atomic bool) ([]Result, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	results := make([]Result, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			results[i] = applyOne(ctx, db, op)
+		}
+		return results, nil
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	evs := []*Event{}
+	for i, op := range ops {
+		var ev *Event
+		results[i], ev = apply(ctx, tx, op)
+		if results[i].Err != nil {
+			return abort(results, ops, i), results[i].Err
+		}
+		evs = append(evs, ev)
+	}
+	if err := tx.Commit(); err != nil {
+		return abort(results, ops, -1), err
+	}
+	publish(ctx, evs...)
+	return results, nil
+}
+
+// abort reports the operations of a rolled back atomic batch as aborted,
+// except the one at failed which caused it
+func abort(results []Result, ops []Op, failed int) []Result {
+	for i, op := range ops {
+		if i != failed {
+			results[i] = Result{Op: op.Op, ID: op.ID, Err: ErrBatchAborted}
+		}
+	}
+	return results
+}
+
+// applyOne runs a single operation in a transaction of its own
+func applyOne(ctx context.Context, db *storm.DB, op Op) Result {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return Result{Op: op.Op, ID: op.ID, Err: err}
+	}
+	defer tx.Rollback()
+
+	res, ev := apply(ctx, tx, op)
+	if res.Err != nil {
+		return res
+	}
+	if res.Err = tx.Commit(); res.Err != nil {
+		return res
+	}
+	publish(ctx, ev)
+	return res
+}
+
+// apply runs a single operation within the transaction
+func apply(ctx context.Context, tx storm.Node, op Op) (Result, *Event) {
+	res := Result{Op: op.Op, ID: op.ID}
+	var ev *Event
+	switch op.Op {
+	case OpCreate, OpUpdate:
+		if op.User == nil {
+			res.Err = ErrRecordInvalid
+			return res, nil
+		}
+		u := *op.User
+		if op.Op == OpCreate {
+			u.ID = bson.NewObjectId()
+		} else {
+			if !op.ID.Valid() {
+				res.Err = storm.ErrNotFound
+				return res, nil
+			}
+			u.ID = op.ID
+			if res.Err = tx.One("ID", u.ID, new(User)); res.Err != nil {
+				return res, nil
+			}
+		}
+		if res.Err = u.Validate(); res.Err != nil {
+			return res, nil
+		}
+		res.ID = u.ID
+		res.User = &u
+		ev, res.Err = record(ctx, tx, &u)
+	case OpDelete:
+		ev, res.Err = erase(ctx, tx, op.ID)
+	default:
+		res.Err = ErrUnknownOp
+	}
+	return res, ev
+}
@@ -0,0 +1,78 @@
+package user
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	os.Remove(DBPath)
+	existing := &User{
+		ID:   bson.NewObjectId(),
+		Name: "John",
+		Role: "Tester",
+	}
+	err := existing.Save()
+	if err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+
+	t.Log("Atomic batch with a failing operation")
+	ops := []Op{
+		{Op: OpCreate, User: &User{Name: "Jane", Role: "Developer"}},
+		{Op: OpUpdate, ID: bson.NewObjectId(), User: &User{Name: "Nobody"}},
+		{Op: OpDelete, ID: existing.ID},
+	}
+	results, err := Batch(ops, true)
+	if err != storm.ErrNotFound {
+		t.Fatalf("Expected error %s, got %v", storm.ErrNotFound, err)
+	}
+	if results[0].Err != ErrBatchAborted || results[0].User != nil || results[0].ID.Valid() {
+		t.Errorf("Expected the rolled back create to be aborted, got %+v", results[0])
+	}
+	if results[2].Err != ErrBatchAborted {
+		t.Errorf("Expected remaining operation to be aborted, got %v", results[2].Err)
+	}
+	users, err := All()
+	if err != nil {
+		t.Fatalf("Error retrieving all users: %s", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected the batch to be rolled back leaving 1 user, got %d", len(users))
+	}
+
+	t.Log("Partial batch")
+	results, err = Batch(ops, false)
+	if err != nil {
+		t.Fatalf("Error applying a partial batch: %s", err)
+	}
+	if results[0].Err != nil || !results[0].ID.Valid() {
+		t.Errorf("Expected create to succeed, got %+v", results[0])
+	}
+	if results[1].Err != storm.ErrNotFound {
+		t.Errorf("Expected update of a missing user to fail, got %v", results[1].Err)
+	}
+	if results[2].Err != nil {
+		t.Errorf("Expected delete to succeed, got %v", results[2].Err)
+	}
+	users, err = All()
+	if err != nil {
+		t.Fatalf("Error retrieving all users: %s", err)
+	}
+	if len(users) != 1 || users[0].Name != "Jane" {
+		t.Errorf("Expected only Jane to remain, got %+v", users)
+	}
+
+	t.Log("Invalid operations")
+	results, _ = Batch([]Op{{Op: "upsert"}, {Op: OpCreate, User: &User{}}}, false)
+	if results[0].Err != ErrUnknownOp {
+		t.Errorf("Expected error %s, got %v", ErrUnknownOp, results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrRecordInvalid) {
+		t.Errorf("Expected error %s, got %v", ErrRecordInvalid, results[1].Err)
+	}
+	os.Remove(DBPath)
+}
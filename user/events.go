@@ -0,0 +1,308 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/audit"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+	"time"
+)
+
+// Types of change events
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRestored = "restored"
+)
+
+// Event is a change to a user, persisted in the event log
+type Event struct {
+	Seq     uint64        `json:"seq" storm:"id,increment"`
+	Type    string        `json:"type"`
+	UserID  bson.ObjectId `json:"user_id" storm:"index"`
+	Version int           `json:"version"`
+	Before  *User         `json:"before,omitempty"`
+	After   *User         `json:"after,omitempty"`
+	Time    time.Time     `json:"time"`
+}
+
+// allTenants is the tenant of subscribers to the events of every tenant. It
+// is not a valid tenant id.
+const allTenants = "*"
+
+// subscriberBuffer is the number of events a subscriber may lag behind
+// before it is dropped
+const subscriberBuffer = 64
+
+// broker sends events to the subscribers of their tenant, keyed by tenant id
+type broker struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]string
+}
+
+var events = broker{subscribers: map[chan Event]string{}}
+
+// Subscribe returns a channel receiving the events committed from now on and
+// a function to stop the subscription. The channel is closed if the
+// subscriber falls too far behind; it should then resume from the log.
+func Subscribe() (<-chan Event, func()) {
+	return SubscribeContext(context.Background())
+}
+
+// SubscribeContext is Subscribe to the events of the tenant carried by ctx
+func SubscribeContext(ctx context.Context) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+	events.lock.Lock()
+	events.subscribers[ch] = tenant.FromContext(ctx).ID
+	events.lock.Unlock()
+	return ch, func() {
+		events.lock.Lock()
+		if _, ok := events.subscribers[ch]; ok {
+			delete(events.subscribers, ch)
+			close(ch)
+		}
+		events.lock.Unlock()
+	}
+}
+
+// SubscribeAll is Subscribe to the events of every tenant, for background
+// work following them all
+func SubscribeAll() (<-chan Event, func()) {
+	return SubscribeContext(tenant.NewContext(context.Background(), tenant.Tenant{ID: allTenants}))
+}
+
+// publish sends events committed in the tenant carried by ctx to its
+// subscribers
+func publish(ctx context.Context, evs ...*Event) {
+	id := tenant.FromContext(ctx).ID
+	events.lock.Lock()
+	defer events.lock.Unlock()
+	for _, ev := range evs {
+		if ev == nil {
+			continue
+		}
+		for ch, t := range events.subscribers {
+			if t != id && t != allTenants {
+				continue
+			}
+			select {
+			case ch <- *ev:
+			default:
+				delete(events.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// EventsSince returns up to limit events that follow seq in the log
+func EventsSince(seq uint64, limit int) ([]Event, error) {
+	return EventsSinceContext(context.Background(), seq, limit)
+}
+
+// EventsSinceContext is EventsSince for the tenant carried by ctx
+func EventsSinceContext(ctx context.Context, seq uint64, limit int) ([]Event, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	evs := []Event{}
+	err = db.Select(q.Gt("Seq", seq)).OrderBy("Seq").Limit(limit).Find(&evs)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return evs, nil
+}
+
+// LastSeq returns the sequence number of the last event of the log, 0 if
+// it is empty
+func LastSeq() (uint64, error) {
+	return LastSeqContext(context.Background())
+}
+
+// LastSeqContext is LastSeq for the tenant carried by ctx
+func LastSeqContext(ctx context.Context) (uint64, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	ev := new(Event)
+	err = db.Select().Reverse().First(ev)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	return ev.Seq, err
+}
+
+// trail appends the audit entry of a change within its transaction
+func trail(ctx context.Context, tx storm.Node, action string, before, after *User) error {
+	id := before
+	if id == nil {
+		id = after
+	}
+	return audit.Write(ctx, tx, &audit.Entry{
+		Resource: "users/" + id.ID.Hex(),
+		Action:   action,
+		Changes:  audit.Diff(before, after),
+	})
+}
+
+// quota checks that the tenant carried by ctx may have one more user
+func quota(ctx context.Context, tx storm.Node) error {
+	max := tenant.FromContext(ctx).MaxUsers
+	if max == 0 {
+		return nil
+	}
+	n := 0
+	err := tx.Select().Each(new(User), func(record interface{}) error {
+		if !record.(*User).Deleted() {
+			n++
+		}
+		return nil
+	})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	if n >= max {
+		return ErrQuota
+	}
+	return nil
+}
+
+// record saves u within the transaction and appends the matching event and
+// audit entry
+func record(ctx context.Context, tx storm.Node, u *User) (*Event, error) {
+	ev := &Event{
+		Type:   EventCreated,
+		UserID: u.ID,
+		Time:   time.Now().UTC(),
+	}
+	before := new(User)
+	err := tx.One("ID", u.ID, before)
+	switch err {
+	case nil:
+		if before.Deleted() {
+			return nil, ErrDeleted
+		}
+		ev.Type = EventUpdated
+		ev.Before = before
+		u.Version = before.Version + 1
+		u.CreatedAt = before.CreatedAt
+	case storm.ErrNotFound:
+		if err := quota(ctx, tx); err != nil {
+			return nil, err
+		}
+		u.Version = 1
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = ev.Time
+		}
+	default:
+		return nil, err
+	}
+	u.UpdatedAt = ev.Time
+	u.DeletedAt = nil
+	if err := tx.Save(u); err != nil {
+		if err == storm.ErrAlreadyExists {
+			return nil, conflict(tx, u)
+		}
+		return nil, err
+	}
+	after := *u
+	ev.After = &after
+	ev.Version = u.Version
+	if err := trail(ctx, tx, ev.Type, ev.Before, ev.After); err != nil {
+		return nil, err
+	}
+	if err := revise(tx, u, ev.Time); err != nil {
+		return nil, err
+	}
+	return ev, tx.Save(ev)
+}
+
+// erase moves the user with the given id to the trash within the
+// transaction, removes it from its groups and appends the matching event and
+// audit entries
+func erase(ctx context.Context, tx storm.Node, id bson.ObjectId) (*Event, error) {
+	before := new(User)
+	if err := tx.One("ID", id, before); err != nil {
+		return nil, err
+	}
+	if before.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	now := time.Now().UTC()
+	u := *before
+	u.Version++
+	u.UpdatedAt = now
+	u.DeletedAt = &now
+	if err := tx.Save(&u); err != nil {
+		return nil, err
+	}
+	if err := trail(ctx, tx, EventDeleted, before, &u); err != nil {
+		return nil, err
+	}
+	if err := revise(tx, &u, now); err != nil {
+		return nil, err
+	}
+	// deleted users leave their groups for good, even if restored
+	if err := dropMemberships(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	ev := &Event{
+		Type:    EventDeleted,
+		UserID:  id,
+		Version: u.Version,
+		Before:  before,
+		Time:    now,
+	}
+	return ev, tx.Save(ev)
+}
+
+// restore takes the user with the given id out of the trash within the
+// transaction and appends the matching event and audit entry
+func restore(ctx context.Context, tx storm.Node, id bson.ObjectId) (*User, *Event, error) {
+	before := new(User)
+	if err := tx.One("ID", id, before); err != nil {
+		return nil, nil, err
+	}
+	if !before.Deleted() {
+		return nil, nil, ErrNotDeleted
+	}
+	if err := quota(ctx, tx); err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	u := *before
+	u.Version++
+	u.UpdatedAt = now
+	u.DeletedAt = nil
+	if err := tx.Save(&u); err != nil {
+		return nil, nil, err
+	}
+	if err := trail(ctx, tx, EventRestored, before, &u); err != nil {
+		return nil, nil, err
+	}
+	if err := revise(tx, &u, now); err != nil {
+		return nil, nil, err
+	}
+	after := u
+	ev := &Event{
+		Type:    EventRestored,
+		UserID:  id,
+		Version: u.Version,
+		Before:  before,
+		After:   &after,
+		Time:    now,
+	}
+	return &u, ev, tx.Save(ev)
+}
@@ -0,0 +1,515 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/audit"
+	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Group is a named set of users. Its members hold its role in addition to
+// their own.
+type Group struct {
+	ID        bson.ObjectId `json:"id" storm:"id"`
+	Name      string        `json:"name" storm:"unique"`
+	Role      string        `json:"role,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Membership links a user to a group
+type Membership struct {
+	ID        string        `json:"id" storm:"id"`
+	GroupID   bson.ObjectId `json:"group_id" storm:"index"`
+	UserID    bson.ObjectId `json:"user_id" storm:"index"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// GroupReadOnly lists the JSON fields of a group that cannot be set by
+// clients
+var GroupReadOnly = []string{"id", "created_at", "updated_at"}
+
+// membershipID is the key of the membership of a user in a group
+func membershipID(group, user bson.ObjectId) string {
+	return group.Hex() + ":" + user.Hex()
+}
+
+// Validate checks if the group record contains valid data after trimming
+// its name
+func (g *Group) Validate() error {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return &FieldError{Field: "name", Message: "is required"}
+	}
+	return nil
+}
+
+// Groups retrieves all groups from the database
+func Groups() ([]Group, error) {
+	return GroupsContext(context.Background())
+}
+
+// GroupsContext is Groups for the tenant carried by ctx
+func GroupsContext(ctx context.Context) ([]Group, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	groups := []Group{}
+	if err := db.All(&groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// OneGroup returns a single group record from the database
+func OneGroup(id bson.ObjectId) (*Group, error) {
+	return OneGroupContext(context.Background(), id)
+}
+
+// OneGroupContext is OneGroup for the tenant carried by ctx
+func OneGroupContext(ctx context.Context, id bson.ObjectId) (*Group, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	g := new(Group)
+	if err := db.One("ID", id, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Save updates or creates a given group in the database
+func (g *Group) Save() error {
+	return g.SaveContext(context.Background())
+}
+
+// SaveContext is Save with the change attributed to the audit source carried
+// by ctx, in the database of its tenant
+func (g *Group) SaveContext(ctx context.Context) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	before := new(Group)
+	switch err := tx.One("ID", g.ID, before); err {
+	case nil:
+		g.CreatedAt = before.CreatedAt
+	case storm.ErrNotFound:
+		before = nil
+		g.CreatedAt = now
+	default:
+		return err
+	}
+	g.UpdatedAt = now
+	if err := tx.Save(g); err != nil {
+		if err == storm.ErrAlreadyExists {
+			return &ConflictError{Field: "name", Value: g.Name}
+		}
+		return err
+	}
+	action := EventUpdated
+	if before == nil {
+		action = EventCreated
+	}
+	err = audit.Write(ctx, tx, &audit.Entry{
+		Resource: "groups/" + g.ID.Hex(),
+		Action:   action,
+		Changes:  audit.Diff(before, g),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteGroup permanently removes a group along with its memberships
+func DeleteGroup(id bson.ObjectId) error {
+	return DeleteGroupContext(context.Background(), id)
+}
+
+// DeleteGroupContext is DeleteGroup with the change attributed to the audit
+// source carried by ctx, in the database of its tenant
+func DeleteGroupContext(ctx context.Context, id bson.ObjectId) error {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	g := new(Group)
+	if err := tx.One("ID", id, g); err != nil {
+		return err
+	}
+	if err := tx.DeleteStruct(g); err != nil {
+		return err
+	}
+	err = tx.Select(q.Eq("GroupID", id)).Delete(new(Membership))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	err = audit.Write(ctx, tx, &audit.Entry{
+		Resource: "groups/" + id.Hex(),
+		Action:   EventDeleted,
+		Changes:  audit.Diff(g, nil),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddMember adds the user to the group. Adding a member twice is not an
+// error. Deleted users cannot join groups.
+func AddMember(group, user bson.ObjectId) error {
+	return AddMemberContext(context.Background(), group, user)
+}
+
+// AddMemberContext is AddMember with the change attributed to the audit
+// source carried by ctx, in the database of its tenant
+func AddMemberContext(ctx context.Context, group, user bson.ObjectId) error {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.One("ID", group, new(Group)); err != nil {
+		return err
+	}
+	u := new(User)
+	if err := tx.One("ID", user, u); err != nil {
+		return err
+	}
+	if u.Deleted() {
+		return storm.ErrNotFound
+	}
+	m := &Membership{ID: membershipID(group, user), GroupID: group, UserID: user}
+	switch err := tx.One("ID", m.ID, new(Membership)); err {
+	case nil:
+		return nil
+	case storm.ErrNotFound:
+	default:
+		return err
+	}
+	m.CreatedAt = time.Now().UTC()
+	if err := tx.Save(m); err != nil {
+		return err
+	}
+	err = audit.Write(ctx, tx, &audit.Entry{
+		Resource: "groups/" + group.Hex() + "/members",
+		Action:   EventCreated,
+		Changes:  []audit.Change{{Field: "user_id", To: user.Hex()}},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember removes the user from the group
+func RemoveMember(group, user bson.ObjectId) error {
+	return RemoveMemberContext(context.Background(), group, user)
+}
+
+// RemoveMemberContext is RemoveMember with the change attributed to the
+// audit source carried by ctx, in the database of its tenant
+func RemoveMemberContext(ctx context.Context, group, user bson.ObjectId) error {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	m := new(Membership)
+	if err := tx.One("ID", membershipID(group, user), m); err != nil {
+		return err
+	}
+	if err := tx.DeleteStruct(m); err != nil {
+		return err
+	}
+	if err := leave(ctx, tx, m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// leave appends the audit entry of a user leaving a group
+func leave(ctx context.Context, tx storm.Node, m *Membership) error {
+	return audit.Write(ctx, tx, &audit.Entry{
+		Resource: "groups/" + m.GroupID.Hex() + "/members",
+		Action:   EventDeleted,
+		Changes:  []audit.Change{{Field: "user_id", From: m.UserID.Hex()}},
+	})
+}
+
+// dropMemberships removes the user from all groups within the transaction
+// of its deletion
+func dropMemberships(ctx context.Context, tx storm.Node, user bson.ObjectId) error {
+	ms := []Membership{}
+	err := tx.Find("UserID", user, &ms)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range ms {
+		if err := tx.DeleteStruct(&ms[i]); err != nil {
+			return err
+		}
+		if err := leave(ctx, tx, &ms[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Members returns the users of a group, ordered by ID
+func Members(group bson.ObjectId) ([]User, error) {
+	return MembersContext(context.Background(), group)
+}
+
+// MembersContext is Members for the tenant carried by ctx
+func MembersContext(ctx context.Context, group bson.ObjectId) ([]User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	if err := db.One("ID", group, new(Group)); err != nil {
+		return nil, err
+	}
+	return members(db, group)
+}
+
+// MembersOfEach returns the members of each of groups like Members, keyed by
+// group and read in a single transaction. Missing groups have no entry.
+func MembersOfEach(groups []bson.ObjectId) (map[bson.ObjectId][]User, error) {
+	return MembersOfEachContext(context.Background(), groups)
+}
+
+// MembersOfEachContext is MembersOfEach for the tenant carried by ctx
+func MembersOfEachContext(ctx context.Context, groups []bson.ObjectId) (map[bson.ObjectId][]User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	each := map[bson.ObjectId][]User{}
+	for _, id := range groups {
+		err := tx.One("ID", id, new(Group))
+		if err == storm.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if each[id], err = members(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return each, nil
+}
+
+func members(tx storm.Node, group bson.ObjectId) ([]User, error) {
+	ms := []Membership{}
+	if err := tx.Find("GroupID", group, &ms); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	users := []User{}
+	for _, m := range ms {
+		u := User{}
+		if err := tx.One("ID", m.UserID, &u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// GroupsOf returns the groups of a user that is not deleted, ordered by ID
+func GroupsOf(user bson.ObjectId) ([]Group, error) {
+	return GroupsOfContext(context.Background(), user)
+}
+
+// GroupsOfContext is GroupsOf for the tenant carried by ctx
+func GroupsOfContext(ctx context.Context, user bson.ObjectId) ([]Group, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	u := new(User)
+	if err := db.One("ID", user, u); err != nil {
+		return nil, err
+	}
+	if u.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	return groupsOf(db, user)
+}
+
+// GroupsOfEach returns the groups of each of users like GroupsOf, keyed by
+// user and read in a single transaction. Missing and deleted users have no
+// entry.
+func GroupsOfEach(users []bson.ObjectId) (map[bson.ObjectId][]Group, error) {
+	return GroupsOfEachContext(context.Background(), users)
+}
+
+// GroupsOfEachContext is GroupsOfEach for the tenant carried by ctx
+func GroupsOfEachContext(ctx context.Context, users []bson.ObjectId) (map[bson.ObjectId][]Group, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	each := map[bson.ObjectId][]Group{}
+	for _, id := range users {
+		u := new(User)
+		err := tx.One("ID", id, u)
+		if err == storm.ErrNotFound || err == nil && u.Deleted() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if each[id], err = groupsOf(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return each, nil
+}
+
+func groupsOf(tx storm.Node, user bson.ObjectId) ([]Group, error) {
+	ms := []Membership{}
+	if err := tx.Find("UserID", user, &ms); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	groups := []Group{}
+	for _, m := range ms {
+		g := Group{}
+		if err := tx.One("ID", m.GroupID, &g); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups, nil
+}
+
+// Roles returns the roles a user holds, its own and those of its groups,
+// sorted and without duplicates. Suspended users hold none.
+func Roles(user bson.ObjectId) ([]string, error) {
+	return RolesContext(context.Background(), user)
+}
+
+// RolesContext is Roles for the tenant carried by ctx
+func RolesContext(ctx context.Context, user bson.ObjectId) ([]string, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	u := new(User)
+	if err := db.One("ID", user, u); err != nil {
+		return nil, err
+	}
+	if u.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	if u.Status != StatusActive {
+		return []string{}, nil
+	}
+	groups, err := groupsOf(db, user)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	roles := []string{}
+	for _, role := range append([]string{u.Role}, roleNames(groups)...) {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func roleNames(groups []Group) []string {
+	roles := make([]string, len(groups))
+	for i, g := range groups {
+		roles[i] = g.Role
+	}
+	return roles
+}
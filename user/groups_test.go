@@ -0,0 +1,127 @@
+package user
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	ann := &User{ID: bson.NewObjectId(), Name: "Ann", Role: "editor"}
+	bob := &User{ID: bson.NewObjectId(), Name: "Bob"}
+	for _, u := range []*User{ann, bob} {
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+	admins := &Group{ID: bson.NewObjectId(), Name: " Admins ", Role: "admin"}
+	editors := &Group{ID: bson.NewObjectId(), Name: "Editors", Role: "editor"}
+	for _, g := range []*Group{admins, editors} {
+		if err := g.Save(); err != nil {
+			t.Fatalf("Error saving a group: %s", err)
+		}
+	}
+	if admins.Name != "Admins" || admins.CreatedAt.IsZero() {
+		t.Errorf("Expected a trimmed and timestamped group, got %#v", admins)
+	}
+
+	t.Log("Group names are required and unique")
+	if err := (&Group{ID: bson.NewObjectId()}).Save(); !errors.Is(err, ErrRecordInvalid) {
+		t.Errorf("Expected error %v, got %v", ErrRecordInvalid, err)
+	}
+	var ce *ConflictError
+	if err := (&Group{ID: bson.NewObjectId(), Name: "Admins"}).Save(); !errors.As(err, &ce) || ce.Field != "name" {
+		t.Errorf("Expected a name conflict, got %v", err)
+	}
+
+	t.Log("Users join many groups and groups have many members")
+	for _, m := range []struct{ group, user bson.ObjectId }{
+		{admins.ID, ann.ID}, {editors.ID, ann.ID}, {editors.ID, bob.ID}, {editors.ID, bob.ID},
+	} {
+		if err := AddMember(m.group, m.user); err != nil {
+			t.Fatalf("Error adding a member: %s", err)
+		}
+	}
+	members, err := Members(editors.ID)
+	if err != nil || len(members) != 2 {
+		t.Errorf("Expected 2 members, got %d (%v)", len(members), err)
+	}
+	groups, err := GroupsOf(ann.ID)
+	if err != nil || len(groups) != 2 {
+		t.Errorf("Expected 2 groups, got %d (%v)", len(groups), err)
+	}
+
+	t.Log("The groups and members of many are read at once")
+	each, err := GroupsOfEach([]bson.ObjectId{ann.ID, bob.ID, bson.NewObjectId()})
+	if err != nil || len(each) != 2 || len(each[ann.ID]) != 2 || len(each[bob.ID]) != 1 {
+		t.Errorf("Expected 2 groups of Ann and 1 of Bob, got %v (%v)", each, err)
+	}
+	eachMembers, err := MembersOfEach([]bson.ObjectId{admins.ID, editors.ID, bson.NewObjectId()})
+	if err != nil || len(eachMembers) != 2 || len(eachMembers[admins.ID]) != 1 || len(eachMembers[editors.ID]) != 2 {
+		t.Errorf("Expected 1 admin and 2 editors, got %v (%v)", eachMembers, err)
+	}
+
+	if err := AddMember(bson.NewObjectId(), ann.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v joining an unknown group, got %v", storm.ErrNotFound, err)
+	}
+	if err := AddMember(admins.ID, bson.NewObjectId()); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v adding an unknown user, got %v", storm.ErrNotFound, err)
+	}
+
+	t.Log("Users hold the roles of their groups")
+	roles, err := Roles(ann.ID)
+	if err != nil || !reflect.DeepEqual(roles, []string{"admin", "editor"}) {
+		t.Errorf("Expected roles [admin editor], got %v (%v)", roles, err)
+	}
+	roles, _ = Roles(bob.ID)
+	if !reflect.DeepEqual(roles, []string{"editor"}) {
+		t.Errorf("Expected roles [editor], got %v", roles)
+	}
+
+	t.Log("Removing a member")
+	if err := RemoveMember(editors.ID, bob.ID); err != nil {
+		t.Fatalf("Error removing a member: %s", err)
+	}
+	if err := RemoveMember(editors.ID, bob.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v removing twice, got %v", storm.ErrNotFound, err)
+	}
+	if roles, _ := Roles(bob.ID); len(roles) != 0 {
+		t.Errorf("Expected no roles, got %v", roles)
+	}
+
+	t.Log("Deleting a user removes it from its groups")
+	if err := Delete(ann.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if members, _ := Members(admins.ID); len(members) != 0 {
+		t.Errorf("Expected no members, got %d", len(members))
+	}
+	if each, _ := GroupsOfEach([]bson.ObjectId{ann.ID}); len(each) != 0 {
+		t.Errorf("Expected no groups of a deleted user, got %v", each)
+	}
+	if _, err := Restore(ann.ID); err != nil {
+		t.Fatalf("Error restoring a user: %s", err)
+	}
+	if groups, _ := GroupsOf(ann.ID); len(groups) != 0 {
+		t.Errorf("Expected a restored user in no group, got %d", len(groups))
+	}
+
+	t.Log("Deleting a group removes its memberships")
+	if err := AddMember(editors.ID, bob.ID); err != nil {
+		t.Fatalf("Error adding a member: %s", err)
+	}
+	if err := DeleteGroup(editors.ID); err != nil {
+		t.Fatalf("Error deleting a group: %s", err)
+	}
+	if groups, _ := GroupsOf(bob.ID); len(groups) != 0 {
+		t.Errorf("Expected no groups, got %d", len(groups))
+	}
+	if _, err := OneGroup(editors.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+)
+
+// ConflictError tells which unique field of a record has the value of
+// another user. Users in the trash keep their unique values until they are
+// purged, so Trashed tells the other user is one of them, with its ID, to be
+// restored or purged. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	Field   string        `json:"field"`
+	Value   interface{}   `json:"value"`
+	Trashed bool          `json:"trashed,omitempty"`
+	ID      bson.ObjectId `json:"id,omitempty"`
+}
+
+func (e *ConflictError) Error() string {
+	if e.Trashed {
+		return fmt.Sprintf("%s %v is already taken by user %s in the trash", e.Field, e.Value, e.ID.Hex())
+	}
+	return fmt.Sprintf("%s %v is already taken", e.Field, e.Value)
+}
+
+// Is reports whether target is ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Index is a field declared with a storm index or unique tag
+type Index struct {
+	// Field is the name of the struct field
+	Field string
+	// Name is the name of the JSON field
+	Name   string
+	Unique bool
+}
+
+// Indexes lists the indexed fields of a user
+var Indexes = indexes(reflect.TypeOf(User{}))
+
+func indexes(t reflect.Type) []Index {
+	idx := []Index{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("storm")
+		if tag != "index" && tag != "unique" {
+			continue
+		}
+		idx = append(idx, Index{
+			Field:  f.Name,
+			Name:   strings.Split(f.Tag.Get("json"), ",")[0],
+			Unique: tag == "unique",
+		})
+	}
+	return idx
+}
+
+// IndexNamed returns the index of the JSON field name
+func IndexNamed(name string) (Index, bool) {
+	for _, idx := range Indexes {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return Index{}, false
+}
+
+// conflict finds which unique field of u is taken by another user
+func conflict(tx storm.Node, u *User) error {
+	v := reflect.ValueOf(u).Elem()
+	for _, idx := range Indexes {
+		if !idx.Unique {
+			continue
+		}
+		value := v.FieldByName(idx.Field).Interface()
+		other := new(User)
+		if err := tx.One(idx.Field, value, other); err == nil && other.ID != u.ID {
+			if other.Deleted() {
+				return &ConflictError{Field: idx.Name, Value: value, Trashed: true, ID: other.ID}
+			}
+			return &ConflictError{Field: idx.Name, Value: value}
+		}
+	}
+	return ErrConflict
+}
+
+// normalize brings a value looked up in an index to its stored form
+func normalize(idx Index, value string) string {
+	if idx.Field == "Email" {
+		return NormalizeEmail(value)
+	}
+	return value
+}
+
+// FindBy returns the users that are not deleted whose indexed field has the
+// given value
+func FindBy(idx Index, value string) ([]User, error) {
+	return FindByContext(context.Background(), idx, value)
+}
+
+// FindByContext is FindBy for the tenant carried by ctx
+func FindByContext(ctx context.Context, idx Index, value string) ([]User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	users := []User{}
+	err = db.Find(idx.Field, normalize(idx, value), &users)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	active := users[:0]
+	for _, u := range users {
+		if !u.Deleted() {
+			active = append(active, u)
+		}
+	}
+	return active, nil
+}
+
+// duplicates reports the first value of a unique field shared by two users
+func duplicates(tx storm.Node) error {
+	seen := map[string]map[interface{}]bool{}
+	var dup error
+	err := tx.Select().Each(new(User), func(record interface{}) error {
+		v := reflect.ValueOf(record).Elem()
+		for _, idx := range Indexes {
+			value := v.FieldByName(idx.Field)
+			if !idx.Unique || value.IsZero() {
+				continue
+			}
+			if seen[idx.Field] == nil {
+				seen[idx.Field] = map[interface{}]bool{}
+			}
+			if seen[idx.Field][value.Interface()] {
+				dup = &ConflictError{Field: idx.Name, Value: value.Interface()}
+				return dup
+			}
+			seen[idx.Field][value.Interface()] = true
+		}
+		return nil
+	})
+	if dup != nil {
+		return dup
+	}
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
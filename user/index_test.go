@@ -0,0 +1,111 @@
+package user
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/migrate"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+)
+
+func TestFindBy(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+
+	users := []*User{
+		{ID: bson.NewObjectId(), Name: "Ann", Role: "Admin", Email: "ann@example.com"},
+		{ID: bson.NewObjectId(), Name: "Bob", Role: "Admin"},
+		{ID: bson.NewObjectId(), Name: "Cid", Role: "Tester", Email: "cid@example.com"},
+	}
+	for _, u := range users {
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+	if err := Delete(users[2].ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+
+	cases := []struct {
+		txt    string
+		field  string
+		value  string
+		expect int
+	}{
+		{"email is normalized", "email", " ANN@example.com", 1},
+		{"shared role", "role", "Admin", 2},
+		{"deleted users are hidden", "email", "cid@example.com", 0},
+		{"no match", "name", "Dan", 0},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		idx, ok := IndexNamed(tc.field)
+		if !ok {
+			t.Fatalf("Expected %s to be indexed", tc.field)
+		}
+		got, err := FindBy(idx, tc.value)
+		if err != nil {
+			t.Fatalf("Error finding users: %s", err)
+		}
+		if len(got) != tc.expect {
+			t.Errorf("Expected %d users, got %d", tc.expect, len(got))
+		}
+	}
+	if _, ok := IndexNamed("attributes"); ok {
+		t.Errorf("Expected attributes not to be indexed")
+	}
+}
+
+// saveLegacy stores users as they were before their fields were indexed
+func saveLegacy(t *testing.T, emails ...string) {
+	t.Helper()
+	type User struct {
+		ID    bson.ObjectId `storm:"id"`
+		Name  string
+		Email string
+	}
+	db, err := storm.Open(DBPath)
+	if err != nil {
+		t.Fatalf("Error opening the database: %s", err)
+	}
+	defer db.Close()
+	for _, email := range emails {
+		if err := db.Save(&User{ID: bson.NewObjectId(), Name: email, Email: email}); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	migrate.BackupDir = t.TempDir()
+
+	saveLegacy(t, "ann@example.com", "bob@example.com")
+	if got, _ := FindBy(Index{Field: "Email"}, "bob@example.com"); len(got) != 0 {
+		t.Fatalf("Expected the legacy database to have no email index, got %v", got)
+	}
+	if _, _, err := migrate.Up(DBPath); err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	got, err := FindBy(Index{Field: "Email"}, "bob@example.com")
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Expected Bob to be found by email, got %v, %v", got, err)
+	}
+	if got[0].Status != StatusActive {
+		t.Errorf("Expected the status to be backfilled, got %q", got[0].Status)
+	}
+	dup := &User{ID: bson.NewObjectId(), Name: "Other Bob", Email: "bob@example.com"}
+	if err := dup.Save(); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected error %v, got %v", ErrConflict, err)
+	}
+
+	t.Log("Duplicates are reported")
+	os.Remove(DBPath)
+	saveLegacy(t, "ann@example.com", "ann@example.com")
+	var ce *ConflictError
+	if _, _, err := migrate.Up(DBPath); !errors.As(err, &ce) || ce.Field != "email" {
+		t.Errorf("Expected a conflict on email, got %v", err)
+	}
+}
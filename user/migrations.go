@@ -0,0 +1,38 @@
+package user
+
+import (
+	"github.com/christianotieno/go-rest-api/migrate"
+)
+
+// Bucket is the storm bucket holding users, named after the type
+const Bucket = "User"
+
+func init() {
+	migrate.Register(
+		migrate.Migration{
+			Version: 1,
+			Name:    "backfill user status",
+			Up: func(tx *migrate.Tx) error {
+				return tx.Backfill(Bucket, "status", StatusActive)
+			},
+			// the backfilled values are valid in the previous schema too
+			Down: func(tx *migrate.Tx) error {
+				return nil
+			},
+		},
+		migrate.Migration{
+			Version: 2,
+			Name:    "build user indexes",
+			Up: func(tx *migrate.Tx) error {
+				if err := duplicates(tx.Node); err != nil {
+					return err
+				}
+				return tx.Reindex(new(User))
+			},
+			// storm ignores the indexes of fields that are not declared
+			Down: func(tx *migrate.Tx) error {
+				return nil
+			},
+		},
+	)
+}
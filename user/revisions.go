@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Revision is the state of a user right after one of its changes
+type Revision struct {
+	ID      uint64        `json:"id" storm:"id,increment"`
+	UserID  bson.ObjectId `json:"user_id" storm:"index"`
+	Version int           `json:"version"`
+	User    User          `json:"user"`
+	Time    time.Time     `json:"time"`
+}
+
+// MaxRevisions is the number of revisions kept per user, the oldest ones
+// being dropped first. Zero keeps every revision.
+var MaxRevisions = 50
+
+// revise stores the state of u within the transaction of its change and
+// drops the revisions over MaxRevisions
+func revise(tx storm.Node, u *User, t time.Time) error {
+	err := tx.Save(&Revision{UserID: u.ID, Version: u.Version, User: *u, Time: t})
+	if err != nil || MaxRevisions <= 0 {
+		return err
+	}
+	old := []Revision{}
+	err = tx.Select(q.Eq("UserID", u.ID)).OrderBy("Version").Reverse().Skip(MaxRevisions).Find(&old)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := range old {
+		if err := tx.DeleteStruct(&old[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revisions lists the kept revisions of a user, most recent first
+func Revisions(id bson.ObjectId) ([]Revision, error) {
+	return RevisionsContext(context.Background(), id)
+}
+
+// RevisionsContext is Revisions for the tenant carried by ctx
+func RevisionsContext(ctx context.Context, id bson.ObjectId) ([]Revision, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	if err := db.One("ID", id, new(User)); err != nil {
+		return nil, err
+	}
+	revs := []Revision{}
+	err = db.Select(q.Eq("UserID", id)).OrderBy("Version").Reverse().Find(&revs)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// AsOf returns the user as it was at the given time. Users that did not
+// exist yet, were in the trash then or whose revisions were dropped are
+// reported as not found.
+func AsOf(id bson.ObjectId, t time.Time) (*User, error) {
+	return AsOfContext(context.Background(), id, t)
+}
+
+// AsOfContext is AsOf for the tenant carried by ctx
+func AsOfContext(ctx context.Context, id bson.ObjectId, t time.Time) (*User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	rev := new(Revision)
+	err = db.Select(q.Eq("UserID", id), q.Lte("Time", t)).OrderBy("Version").Reverse().First(rev)
+	if err != nil {
+		return nil, err
+	}
+	if rev.User.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	return &rev.User, nil
+}
+
+// Revert saves the state of a user at the given version as a new version
+func Revert(ctx context.Context, id bson.ObjectId, version int) (*User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rev := new(Revision)
+	if err := tx.Select(q.Eq("UserID", id), q.Eq("Version", version)).First(rev); err != nil {
+		return nil, err
+	}
+	u := rev.User
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	ev, err := record(ctx, tx, &u)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	publish(ctx, ev)
+	return &u, nil
+}
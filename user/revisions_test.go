@@ -0,0 +1,82 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRevisions(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	defer func(n int) { MaxRevisions = n }(MaxRevisions)
+	MaxRevisions = 3
+
+	u := &User{ID: bson.NewObjectId(), Name: "John", Role: "Tester"}
+	times := []time.Time{}
+	for _, role := range []string{"Tester", "Developer", "Lead", "Manager"} {
+		u.Role = role
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+		times = append(times, time.Now())
+		time.Sleep(time.Millisecond)
+	}
+
+	revs, err := Revisions(u.ID)
+	if err != nil {
+		t.Fatalf("Error listing revisions: %s", err)
+	}
+	if len(revs) != 3 || revs[0].Version != 4 || revs[2].Version != 2 {
+		t.Fatalf("Expected versions 4 to 2, got %+v", revs)
+	}
+
+	cases := []struct {
+		txt    string
+		at     time.Time
+		expect string
+		err    error
+	}{
+		{"before the user existed", times[0].Add(-time.Hour), "", storm.ErrNotFound},
+		{"dropped revision", times[0], "", storm.ErrNotFound},
+		{"second version", times[1], "Developer", nil},
+		{"latest version", time.Now(), "Manager", nil},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		got, err := AsOf(u.ID, tc.at)
+		if err != tc.err {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+			continue
+		}
+		if err == nil && got.Role != tc.expect {
+			t.Errorf("Expected role %s, got %s", tc.expect, got.Role)
+		}
+	}
+
+	t.Log("Revert")
+	r, err := Revert(context.Background(), u.ID, 2)
+	if err != nil {
+		t.Fatalf("Error reverting: %s", err)
+	}
+	if r.Role != "Developer" || r.Version != 5 {
+		t.Errorf("Expected role Developer at version 5, got %#v", r)
+	}
+	if _, err := Revert(context.Background(), u.ID, 1); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v reverting a dropped revision, got %v", storm.ErrNotFound, err)
+	}
+
+	t.Log("Deleted users read as not found")
+	if err := Delete(u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if _, err := AsOf(u.ID, time.Now()); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+	if _, err := Revert(context.Background(), u.ID, 5); err != ErrDeleted {
+		t.Errorf("Expected error %v, got %v", ErrDeleted, err)
+	}
+}
@@ -0,0 +1,48 @@
+package user
+
+import (
+	"context"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Store keeps users. Every backend has the CRUD semantics of the storm
+// backed package functions: saves are validated and versioned, deleted
+// users are hidden, unknown or deleted users are reported as
+// storm.ErrNotFound, saving a deleted user fails with ErrDeleted and a
+// taken unique field fails with a ConflictError.
+//
+// Store only covers that CRUD subset. The servers keep using users.db
+// through the package functions, since the event log, revisions, trash,
+// groups, batches and quotas they rely on have no other backend yet; the
+// other backends are for tools and services that only need the CRUD
+// subset, such as sharing users with processes users.db cannot serve.
+type Store interface {
+	All(ctx context.Context) ([]User, error)
+	One(ctx context.Context, id bson.ObjectId) (*User, error)
+	Save(ctx context.Context, u *User) error
+	Delete(ctx context.Context, id bson.ObjectId) error
+}
+
+// BoltStore is the Store of users.db, the storm database used by default,
+// of the tenant carried by the context of each call
+type BoltStore struct{}
+
+// All retrieves all users except deleted ones
+func (BoltStore) All(ctx context.Context) ([]User, error) {
+	return AllContext(ctx)
+}
+
+// One returns a single user that is not deleted
+func (BoltStore) One(ctx context.Context, id bson.ObjectId) (*User, error) {
+	return OneContext(ctx, id)
+}
+
+// Save updates or creates a given user
+func (BoltStore) Save(ctx context.Context, u *User) error {
+	return u.SaveContext(ctx)
+}
+
+// Delete moves a given user to the trash
+func (BoltStore) Delete(ctx context.Context, id bson.ObjectId) error {
+	return DeleteContext(ctx, id)
+}
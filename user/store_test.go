@@ -0,0 +1,16 @@
+package user_test
+
+import (
+	"github.com/christianotieno/go-rest-api/user"
+	"github.com/christianotieno/go-rest-api/user/storetest"
+	"os"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) user.Store {
+		os.Remove(user.DBPath)
+		t.Cleanup(func() { os.Remove(user.DBPath) })
+		return user.BoltStore{}
+	})
+}
@@ -0,0 +1,188 @@
+// Package storetest is the conformance suite every user.Store backend must
+// pass
+package storetest
+
+import (
+	"context"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Run runs the conformance suite against the stores returned by open, which
+// must be empty
+func Run(t *testing.T, open func(t *testing.T) user.Store) {
+	tests := []struct {
+		txt string
+		fn  func(*testing.T, user.Store)
+	}{
+		{"Save creates a user", testCreate},
+		{"Save updates a user", testUpdate},
+		{"Save rejects an invalid user", testInvalid},
+		{"Save rejects a taken email", testConflict},
+		{"Delete hides a user", testDelete},
+		{"Unknown users are not found", testNotFound},
+		{"All lists users by ID", testAll},
+	}
+	for _, tc := range tests {
+		t.Run(tc.txt, func(t *testing.T) {
+			tc.fn(t, open(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+func newUser(name, email string) *user.User {
+	return &user.User{
+		ID:         bson.NewObjectId(),
+		Name:       name,
+		Role:       "Tester",
+		Email:      email,
+		Attributes: map[string]any{"team": "core", "level": float64(3)},
+	}
+}
+
+func save(t *testing.T, s user.Store, u *user.User) {
+	t.Helper()
+	if err := s.Save(ctx, u); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+}
+
+func one(t *testing.T, s user.Store, id bson.ObjectId) *user.User {
+	t.Helper()
+	u, err := s.One(ctx, id)
+	if err != nil {
+		t.Fatalf("Error retrieving a user: %s", err)
+	}
+	return u
+}
+
+// equal compares users field by field, times by instant since backends do
+// not keep locations
+func equal(t *testing.T, expected, got *user.User) {
+	t.Helper()
+	if got.ID != expected.ID || got.Name != expected.Name || got.Role != expected.Role ||
+		got.Email != expected.Email || got.Status != expected.Status || got.Version != expected.Version {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+	if !reflect.DeepEqual(got.Attributes, expected.Attributes) {
+		t.Errorf("Expected attributes %v, got %v", expected.Attributes, got.Attributes)
+	}
+	if !got.CreatedAt.Equal(expected.CreatedAt) || !got.UpdatedAt.Equal(expected.UpdatedAt) {
+		t.Errorf("Expected times %s, %s, got %s, %s", expected.CreatedAt, expected.UpdatedAt, got.CreatedAt, got.UpdatedAt)
+	}
+	if got.Deleted() != expected.Deleted() {
+		t.Errorf("Expected deleted %v, got %v", expected.Deleted(), got.Deleted())
+	}
+}
+
+func testCreate(t *testing.T, s user.Store) {
+	u := newUser("Ann", " Ann@Example.com ")
+	save(t, s, u)
+	if u.Version != 1 || u.Status != user.StatusActive || u.Email != "ann@example.com" {
+		t.Errorf("Expected version 1, status active and a normalized email, got %+v", u)
+	}
+	if u.CreatedAt.IsZero() || !u.UpdatedAt.Equal(u.CreatedAt) {
+		t.Errorf("Expected creation times to be set, got %s, %s", u.CreatedAt, u.UpdatedAt)
+	}
+	equal(t, u, one(t, s, u.ID))
+}
+
+func testUpdate(t *testing.T, s user.Store) {
+	u := newUser("Ann", "ann@example.com")
+	save(t, s, u)
+	created := u.CreatedAt
+
+	time.Sleep(time.Millisecond)
+	updated := *u
+	updated.Name = "Anne"
+	updated.CreatedAt = time.Time{}
+	updated.Attributes = nil
+	save(t, s, &updated)
+	if updated.Version != 2 || !updated.CreatedAt.Equal(created) || !updated.UpdatedAt.After(created) {
+		t.Errorf("Expected version 2 created at %s and updated later, got %+v", created, updated)
+	}
+	equal(t, &updated, one(t, s, u.ID))
+}
+
+func testInvalid(t *testing.T, s user.Store) {
+	u := newUser("", "")
+	if err := s.Save(ctx, u); !errors.Is(err, user.ErrRecordInvalid) {
+		t.Errorf("Expected error %v, got %v", user.ErrRecordInvalid, err)
+	}
+	if _, err := s.One(ctx, u.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected an invalid user not to be saved, got %v", err)
+	}
+}
+
+func testConflict(t *testing.T, s user.Store) {
+	save(t, s, newUser("Ann", "ann@example.com"))
+	save(t, s, newUser("Bob", ""))
+	save(t, s, newUser("Cid", ""))
+
+	err := s.Save(ctx, newUser("Ann", "ANN@example.com"))
+	var conflict *user.ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, user.ErrConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if conflict.Field != "email" || conflict.Value != "ann@example.com" {
+		t.Errorf("Expected email ann@example.com to be taken, got %s %v", conflict.Field, conflict.Value)
+	}
+}
+
+func testDelete(t *testing.T, s user.Store) {
+	u := newUser("Ann", "ann@example.com")
+	save(t, s, u)
+	if err := s.Delete(ctx, u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if _, err := s.One(ctx, u.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+	if users, _ := s.All(ctx); len(users) != 0 {
+		t.Errorf("Expected no users, got %v", users)
+	}
+	if err := s.Delete(ctx, u.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected deleting twice to fail with %v, got %v", storm.ErrNotFound, err)
+	}
+	if err := s.Save(ctx, u); err != user.ErrDeleted {
+		t.Errorf("Expected error %v, got %v", user.ErrDeleted, err)
+	}
+}
+
+func testNotFound(t *testing.T, s user.Store) {
+	id := bson.NewObjectId()
+	if _, err := s.One(ctx, id); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+	if err := s.Delete(ctx, id); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+}
+
+func testAll(t *testing.T, s user.Store) {
+	users, err := s.All(ctx)
+	if err != nil || len(users) != 0 {
+		t.Fatalf("Expected no users, got %v, %v", users, err)
+	}
+	saved := []*user.User{newUser("Ann", ""), newUser("Bob", ""), newUser("Cid", "")}
+	for _, u := range saved {
+		save(t, s, u)
+	}
+	users, err = s.All(ctx)
+	if err != nil {
+		t.Fatalf("Error retrieving users: %s", err)
+	}
+	if len(users) != len(saved) {
+		t.Fatalf("Expected %d users, got %d", len(saved), len(users))
+	}
+	for i := range saved {
+		equal(t, saved[i], &users[i])
+	}
+}
@@ -0,0 +1,167 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tenants returns the contexts of tenants whose databases are in a
+// temporary directory
+func tenants(t *testing.T, ts ...tenant.Tenant) []context.Context {
+	dir := tenant.Dir
+	tenant.Dir = t.TempDir()
+	t.Cleanup(func() { tenant.Dir = dir })
+	ctxs := []context.Context{}
+	for _, tn := range ts {
+		if err := os.MkdirAll(filepath.Join(tenant.Dir, tn.ID), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		ctxs = append(ctxs, tenant.NewContext(context.Background(), tn))
+	}
+	return ctxs
+}
+
+func TestTenantIsolation(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	ctxs := tenants(t, tenant.Tenant{ID: "acme"}, tenant.Tenant{ID: "globex"})
+	acme, globex := ctxs[0], ctxs[1]
+
+	u := &User{ID: bson.NewObjectId(), Name: "Ann", Email: "ann@example.com"}
+	if err := u.SaveContext(acme); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	events, cancel := SubscribeContext(globex)
+	defer cancel()
+
+	testCases := []struct {
+		txt string
+		ctx context.Context
+		err error
+	}{
+		{"The tenant reads its user", acme, nil},
+		{"Another tenant cannot", globex, storm.ErrNotFound},
+		{"Neither can the default tenant", context.Background(), storm.ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if _, err := OneContext(tc.ctx, u.ID); err != tc.err {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+		}
+	}
+
+	t.Log("Unique fields are unique per tenant")
+	other := &User{ID: bson.NewObjectId(), Name: "Ann", Email: "ann@example.com"}
+	if err := other.SaveContext(globex); err != nil {
+		t.Errorf("Expected the email to be free in another tenant, got %s", err)
+	}
+	for _, ev := range drain(events) {
+		if ev.UserID == u.ID {
+			t.Errorf("Expected no events of another tenant, got %+v", ev)
+		}
+	}
+}
+
+func drain(ch <-chan Event) []Event {
+	evs := []Event{}
+	for {
+		select {
+		case ev := <-ch:
+			evs = append(evs, ev)
+		default:
+			return evs
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	ctx := tenants(t, tenant.Tenant{ID: "acme", MaxUsers: 2})[0]
+
+	ids := []bson.ObjectId{}
+	for i := 0; i < 2; i++ {
+		u := &User{ID: bson.NewObjectId(), Name: "John"}
+		if err := u.SaveContext(ctx); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+		ids = append(ids, u.ID)
+	}
+	testCases := []struct {
+		txt string
+		fn  func() error
+		err error
+	}{
+		{"Creating past the quota fails", func() error {
+			return (&User{ID: bson.NewObjectId(), Name: "John"}).SaveContext(ctx)
+		}, ErrQuota},
+		{"Updating within the quota works", func() error {
+			return (&User{ID: ids[0], Name: "Jane"}).SaveContext(ctx)
+		}, nil},
+		{"Deleting frees a slot", func() error {
+			if err := DeleteContext(ctx, ids[0]); err != nil {
+				return err
+			}
+			return (&User{ID: bson.NewObjectId(), Name: "John"}).SaveContext(ctx)
+		}, nil},
+		{"Restoring past the quota fails", func() error {
+			_, err := RestoreContext(ctx, ids[0])
+			return err
+		}, ErrQuota},
+	}
+	for _, tc := range testCases {
+		t.Log(tc.txt)
+		if err := tc.fn(); err != tc.err {
+			t.Errorf("Expected error %v, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestRestoreQuota(t *testing.T) {
+	ctx := tenants(t, tenant.Tenant{ID: "acme", MaxUsers: 1})[0]
+
+	ids := []bson.ObjectId{}
+	for i := 0; i < 2; i++ {
+		u := &User{ID: bson.NewObjectId(), Name: "John"}
+		if err := u.SaveContext(ctx); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+		if err := DeleteContext(ctx, u.ID); err != nil {
+			t.Fatalf("Error deleting a user: %s", err)
+		}
+		ids = append(ids, u.ID)
+	}
+
+	t.Log("Concurrent restores cannot both take the last slot")
+	errs := make(chan error, len(ids))
+	for _, id := range ids {
+		go func(id bson.ObjectId) {
+			_, err := RestoreContext(ctx, id)
+			errs <- err
+		}(id)
+	}
+	restored, refused := 0, 0
+	for range ids {
+		switch err := <-errs; err {
+		case nil:
+			restored++
+		case ErrQuota:
+			refused++
+		default:
+			t.Errorf("Error restoring a user: %s", err)
+		}
+	}
+	if restored != 1 || refused != 1 {
+		t.Errorf("Expected 1 restored and 1 refused user, got %d and %d", restored, refused)
+	}
+	users, err := AllContext(ctx)
+	if err != nil {
+		t.Fatalf("Error retrieving users: %s", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected 1 user, got %d", len(users))
+	}
+}
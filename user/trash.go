@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Restore takes a deleted user out of the trash
+func Restore(id bson.ObjectId) (*User, error) {
+	return RestoreContext(context.Background(), id)
+}
+
+// RestoreContext is Restore with the change attributed to the audit source
+// carried by ctx, in the database of its tenant
+func RestoreContext(ctx context.Context, id bson.ObjectId) (*User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	u, ev, err := restore(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	publish(ctx, ev)
+	return u, nil
+}
+
+// Purge permanently removes the users deleted before the given time, along
+// with their revisions, and returns how many were removed
+func Purge(before time.Time) (int, error) {
+	return PurgeContext(context.Background(), before)
+}
+
+// PurgeContext is Purge for the tenant carried by ctx
+func PurgeContext(ctx context.Context, before time.Time) (int, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	expired := []User{}
+	err = tx.Select().Each(new(User), func(record interface{}) error {
+		u := record.(*User)
+		if u.Deleted() && u.DeletedAt.Before(before) {
+			expired = append(expired, *u)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i := range expired {
+		if err := tx.DeleteStruct(&expired[i]); err != nil {
+			return 0, err
+		}
+		err := tx.Select(q.Eq("UserID", expired[i].ID)).Delete(new(Revision))
+		if err != nil && err != storm.ErrNotFound {
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
+// Purger purges the users of every tenant that have been in the trash for
+// longer than retention, checking every interval until ctx is done
+func Purger(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		tenant.Each(ctx, func(ctx context.Context) error {
+			PurgeContext(ctx, time.Now().Add(-retention))
+			return nil
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
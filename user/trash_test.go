@@ -0,0 +1,145 @@
+package user
+
+import (
+	"errors"
+	"github.com/asdine/storm/v3"
+	"gopkg.in/mgo.v2/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	u := &User{ID: bson.NewObjectId(), Name: "John", Role: "Tester"}
+	if err := u.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+
+	t.Log("Delete keeps a tombstone")
+	if err := Delete(u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if _, err := One(u.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v, got %v", storm.ErrNotFound, err)
+	}
+	d, err := OneWithDeleted(u.ID)
+	if err != nil {
+		t.Fatalf("Error retrieving a deleted user: %s", err)
+	}
+	if !d.Deleted() || d.Version != 2 {
+		t.Errorf("Expected a tombstone at version 2, got %#v", d)
+	}
+	if err := Delete(u.ID); err != storm.ErrNotFound {
+		t.Errorf("Expected error %v deleting twice, got %v", storm.ErrNotFound, err)
+	}
+	if err := u.Save(); err != ErrDeleted {
+		t.Errorf("Expected error %v saving a deleted user, got %v", ErrDeleted, err)
+	}
+	for _, l := range []struct {
+		txt    string
+		all    func() ([]User, error)
+		expect bool
+	}{
+		{"All hides deleted users", All, false},
+		{"AllWithDeleted shows deleted users", AllWithDeleted, true},
+	} {
+		t.Log(l.txt)
+		users, err := l.all()
+		if err != nil {
+			t.Fatalf("Error listing users: %s", err)
+		}
+		found := false
+		for _, x := range users {
+			found = found || x.ID == u.ID
+		}
+		if found != l.expect {
+			t.Errorf("Expected listed %v, got %v", l.expect, found)
+		}
+	}
+
+	t.Log("Restore")
+	r, err := Restore(u.ID)
+	if err != nil {
+		t.Fatalf("Error restoring a user: %s", err)
+	}
+	if r.Deleted() || r.Version != 3 {
+		t.Errorf("Expected a live user at version 3, got %#v", r)
+	}
+	if _, err := One(u.ID); err != nil {
+		t.Errorf("Error retrieving a restored user: %s", err)
+	}
+	if _, err := Restore(u.ID); err != ErrNotDeleted {
+		t.Errorf("Expected error %v, got %v", ErrNotDeleted, err)
+	}
+}
+
+func TestTrashedConflict(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	u := &User{ID: bson.NewObjectId(), Name: "John", Email: "john@example.com"}
+	if err := u.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	if err := Delete(u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+
+	t.Log("Emails of users in the trash stay taken")
+	other := &User{ID: bson.NewObjectId(), Name: "Johnny", Email: "john@example.com"}
+	var ce *ConflictError
+	if err := other.Save(); !errors.As(err, &ce) || !ce.Trashed || ce.ID != u.ID {
+		t.Errorf("Expected a conflict with the user in the trash, got %v", err)
+	}
+	if _, err := Restore(u.ID); err != nil {
+		t.Fatalf("Error restoring a user: %s", err)
+	}
+	ce = nil
+	if err := other.Save(); !errors.As(err, &ce) || ce.Trashed {
+		t.Errorf("Expected a conflict with a live user, got %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	old := &User{ID: bson.NewObjectId(), Name: "Old"}
+	recent := &User{ID: bson.NewObjectId(), Name: "Recent"}
+	live := &User{ID: bson.NewObjectId(), Name: "Live"}
+	for _, u := range []*User{old, recent, live} {
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+	}
+	if err := Delete(old.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	cutoff := time.Now()
+	if err := Delete(recent.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+
+	n, err := Purge(cutoff)
+	if err != nil {
+		t.Fatalf("Error purging: %s", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 purged user, got %d", n)
+	}
+	cases := []struct {
+		txt    string
+		id     bson.ObjectId
+		expect error
+	}{
+		{"expired tombstone is gone", old.ID, storm.ErrNotFound},
+		{"recent tombstone is kept", recent.ID, nil},
+		{"live user is kept", live.ID, nil},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		if _, err := OneWithDeleted(tc.id); err != tc.expect {
+			t.Errorf("Expected error %v, got %v", tc.expect, err)
+		}
+	}
+}
@@ -1,31 +1,113 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/tenant"
 	"gopkg.in/mgo.v2/bson"
+	"net/mail"
+	"strings"
+	"time"
 )
 
-// User represents a user in the system
+// User represents a user in the system. Emails are unique among the users,
+// those in the trash included until they are purged.
 type User struct {
-	ID   bson.ObjectId `json:"id" storm:"id"`
-	Name string        `json:"name"`
-	Role string        `json:"role"`
+	ID         bson.ObjectId  `json:"id" storm:"id"`
+	Name       string         `json:"name" storm:"index"`
+	Role       string         `json:"role" storm:"index"`
+	Email      string         `json:"email,omitempty" storm:"unique"`
+	Status     string         `json:"status" storm:"index"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Version    int            `json:"version"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  *time.Time     `json:"deleted_at,omitempty"`
 }
 
+// Statuses of a user
 const (
-	dbPath = "users.db"
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
 )
 
+// DBPath is the database file holding users. Each tenant has its own.
+const DBPath = "users.db"
+
+// dbPath is the database of the tenant carried by ctx
+func dbPath(ctx context.Context) string {
+	return tenant.FromContext(ctx).Path(DBPath)
+}
+
+// ReadOnly lists the JSON fields that are maintained by the server and
+// cannot be set by clients
+var ReadOnly = []string{"id", "version", "created_at", "updated_at", "deleted_at"}
+
 // Errors used in the applications
 var (
 	// Returns ErrRecordInvalid when it encounters ivalid record
 	ErrRecordInvalid = errors.New("record is invalid")
+	// Returns ErrDeleted when saving a user that is in the trash
+	ErrDeleted = errors.New("user is deleted")
+	// Returns ErrNotDeleted when restoring a user that is not in the trash
+	ErrNotDeleted = errors.New("user is not deleted")
+	// Returns ErrConflict when a unique field has the value of another user
+	ErrConflict = errors.New("conflicting record")
+	// Returns ErrQuota when a tenant already has as many users as its quota
+	ErrQuota = errors.New("user quota exceeded")
 )
 
-// All retrieves all users from the database
+// FieldError tells which field made a record invalid. It matches
+// ErrRecordInvalid with errors.Is.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Is reports whether target is ErrRecordInvalid
+func (e *FieldError) Is(target error) bool {
+	return target == ErrRecordInvalid
+}
+
+// Deleted reports whether the user is in the trash
+func (u *User) Deleted() bool {
+	return u.DeletedAt != nil
+}
+
+// All retrieves all users from the database, except deleted ones
 func All() ([]User, error) {
-	db, err := storm.Open(dbPath)
+	return AllContext(context.Background())
+}
+
+// AllContext is All for the tenant carried by ctx
+func AllContext(ctx context.Context) ([]User, error) {
+	users, err := AllWithDeletedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := users[:0]
+	for _, u := range users {
+		if !u.Deleted() {
+			active = append(active, u)
+		}
+	}
+	return active, nil
+}
+
+// AllWithDeleted retrieves all users from the database, deleted ones included
+func AllWithDeleted() ([]User, error) {
+	return AllWithDeletedContext(context.Background())
+}
+
+// AllWithDeletedContext is AllWithDeleted for the tenant carried by ctx
+func AllWithDeletedContext(ctx context.Context) ([]User, error) {
+	db, err := storm.Open(dbPath(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -41,9 +123,33 @@ func All() ([]User, error) {
 	return users, nil
 }
 
-// One returns a single user record from the database
+// One returns a single user record from the database. Deleted users are
+// reported as not found.
 func One(id bson.ObjectId) (*User, error) {
-	db, err := storm.Open(dbPath)
+	return OneContext(context.Background(), id)
+}
+
+// OneContext is One for the tenant carried by ctx
+func OneContext(ctx context.Context, id bson.ObjectId) (*User, error) {
+	u, err := OneWithDeletedContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Deleted() {
+		return nil, storm.ErrNotFound
+	}
+	return u, nil
+}
+
+// OneWithDeleted returns a single user record from the database, even if it
+// is deleted
+func OneWithDeleted(id bson.ObjectId) (*User, error) {
+	return OneWithDeletedContext(context.Background(), id)
+}
+
+// OneWithDeletedContext is OneWithDeleted for the tenant carried by ctx
+func OneWithDeletedContext(ctx context.Context, id bson.ObjectId) (*User, error) {
+	db, err := storm.Open(dbPath(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -60,44 +166,194 @@ func One(id bson.ObjectId) (*User, error) {
 	return user, nil
 }
 
-// Delete removes a given user record from the database
+// Each calls fn for every user that is not deleted without loading them all
+// into memory. Iteration stops at the first error returned by fn.
+func Each(fn func(*User) error) error {
+	return EachContext(context.Background(), fn)
+}
+
+// eachPage is the number of users Each reads at once
+const eachPage = 500
+
+// EachContext is Each for the tenant carried by ctx. The users are read a
+// page at a time and fn is called with the database closed, so that a slow
+// fn does not keep other requests from opening it.
+func EachContext(ctx context.Context, fn func(*User) error) error {
+	var after bson.ObjectId
+	for {
+		users, err := PageContext(ctx, after, eachPage)
+		if err != nil {
+			return err
+		}
+		for i := range users {
+			if err := fn(&users[i]); err != nil {
+				return err
+			}
+		}
+		if len(users) < eachPage {
+			return nil
+		}
+		after = users[len(users)-1].ID
+	}
+}
+
+// Page returns, in id order, up to limit users that are not deleted and
+// whose id comes after the given one. An empty id starts from the first.
+func Page(after bson.ObjectId, limit int) ([]User, error) {
+	return PageContext(context.Background(), after, limit)
+}
+
+// PageContext is Page for the tenant carried by ctx
+func PageContext(ctx context.Context, after bson.ObjectId, limit int) ([]User, error) {
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	users := []User{}
+	err = db.Select(q.Gt("ID", after), q.StrictEq("DeletedAt", (*time.Time)(nil))).Limit(limit).Find(&users)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Delete moves a given user record to the trash. It stays in the database
+// with a DeletedAt tombstone until it is restored or purged, but leaves its
+// groups at once.
 func Delete(id bson.ObjectId) error {
-	db, err := storm.Open(dbPath)
+	return DeleteContext(context.Background(), id)
+}
+
+// DeleteContext is Delete with the change attributed to the audit source
+// carried by ctx, in the database of its tenant
+func DeleteContext(ctx context.Context, id bson.ObjectId) error {
+	db, err := storm.Open(dbPath(ctx))
 	if err != nil {
 		return err
 	}
 
 	defer db.Close()
 
-	user := new(User)
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	err = db.One("ID", id, user)
+	ev, err := erase(ctx, tx, id)
 	if err != nil {
 		return err
 	}
-	return db.DeleteStruct(user)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publish(ctx, ev)
+	return nil
 }
 
 // Save updates or creates a given user in the database
 func (u *User) Save() error {
-	if err := u.validate(); err != nil {
+	return u.SaveContext(context.Background())
+}
+
+// SaveContext is Save with the change attributed to the audit source carried
+// by ctx, in the database of its tenant
+func (u *User) SaveContext(ctx context.Context) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	db, err := storm.Open(dbPath(ctx))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ev, err := record(ctx, tx, u)
+	if err != nil {
 		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publish(ctx, ev)
+	return nil
+}
 
-	db, err := storm.Open(dbPath)
+// SaveAll updates or creates the given users in a single transaction
+func SaveAll(users []*User) error {
+	return SaveAllContext(context.Background(), users)
+}
+
+// SaveAllContext is SaveAll with the changes attributed to the audit source
+// carried by ctx, in the database of its tenant
+func SaveAllContext(ctx context.Context, users []*User) error {
+	for _, u := range users {
+		if err := u.Validate(); err != nil {
+			return err
+		}
+	}
+
+	db, err := storm.Open(dbPath(ctx))
 	if err != nil {
 		return err
 	}
 
 	defer db.Close()
 
-	return db.Save(u)
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	evs := make([]*Event, len(users))
+	for i, u := range users {
+		evs[i], err = record(ctx, tx, u)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	publish(ctx, evs...)
+	return nil
 }
 
 // Validate checks if the user record contains valid data
-func (u *User) validate() error {
+// after normalizing its email and defaulting its status
+func (u *User) Validate() error {
+	u.Email = NormalizeEmail(u.Email)
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
 	if u.Name == "" {
-		return ErrRecordInvalid
+		return &FieldError{Field: "name", Message: "is required"}
 	}
-	return nil
+	if u.Email != "" {
+		if a, err := mail.ParseAddress(u.Email); err != nil || a.Address != u.Email {
+			return &FieldError{Field: "email", Message: "is not a valid address"}
+		}
+	}
+	if u.Status != StatusActive && u.Status != StatusSuspended {
+		return &FieldError{Field: "status", Message: "must be " + StatusActive + " or " + StatusSuspended}
+	}
+	return AttributeSchema.Validate(u.Attributes)
+}
+
+// NormalizeEmail trims and lower-cases an email address so that equal
+// addresses compare equal
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
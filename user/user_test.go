@@ -1,7 +1,9 @@
 package user
 
 import (
+	"context"
 	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/audit"
 	"gopkg.in/mgo.v2/bson"
 	"os"
 	"reflect"
@@ -11,11 +13,11 @@ import (
 
 func TestMain(m *testing.M) {
 	m.Run()
-	os.Remove(dbPath)
+	os.Remove(DBPath)
 }
 
 func cleanDb(b *testing.B) {
-	os.Remove(dbPath)
+	os.Remove(DBPath)
 	u := &User{
 		ID:   bson.NewObjectId(),
 		Name: "John",
@@ -110,7 +112,7 @@ func BenchmarkDelete(b *testing.B) {
 }
 
 func BenchmarkCRUD(b *testing.B) {
-	os.Remove(dbPath)
+	os.Remove(DBPath)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		u := &User{
@@ -209,3 +211,90 @@ func TestCRUD(t *testing.T) {
 	}
 
 }
+
+func TestAuditTrail(t *testing.T) {
+	os.Remove(DBPath)
+	defer os.Remove(DBPath)
+	ctx := audit.WithSource(context.Background(), audit.Source{Actor: "Peter", RequestID: "req-1", IP: "10.0.0.1"})
+
+	u := &User{ID: bson.NewObjectId(), Name: "John", Role: "Tester"}
+	if err := u.SaveContext(ctx); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	u.Role = "Admin"
+	if err := u.SaveContext(ctx); err != nil {
+		t.Fatalf("Error updating a user: %s", err)
+	}
+	if err := DeleteContext(ctx, u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+
+	entries, err := audit.Find("users/"+u.ID.Hex(), 0, 10)
+	if err != nil {
+		t.Fatalf("Error finding audit entries: %s", err)
+	}
+	actions := []string{}
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+		if e.Actor != "Peter" || e.RequestID != "req-1" {
+			t.Errorf("Expected the change attributed to Peter in req-1, got %+v", e)
+		}
+	}
+	if expect := []string{EventCreated, EventUpdated, EventDeleted}; !reflect.DeepEqual(expect, actions) {
+		t.Fatalf("Expected actions %v, got %v", expect, actions)
+	}
+	fields := []string{}
+	for _, c := range entries[1].Changes {
+		fields = append(fields, c.Field)
+	}
+	if expect := []string{"role", "updated_at", "version"}; !reflect.DeepEqual(expect, fields) {
+		t.Errorf("Expected changed fields %v, got %v", expect, fields)
+	}
+	if c := entries[1].Changes[0]; c.From != "Tester" || c.To != "Admin" {
+		t.Errorf("Expected role to change from Tester to Admin, got %v", c)
+	}
+}
+
+func TestPage(t *testing.T) {
+	os.Remove(DBPath)
+	ids := []bson.ObjectId{}
+	for i := 0; i < 5; i++ {
+		u := &User{ID: bson.NewObjectId(), Name: "John_" + strconv.Itoa(i), Role: "Tester"}
+		if err := u.Save(); err != nil {
+			t.Fatalf("Error saving a user: %s", err)
+		}
+		ids = append(ids, u.ID)
+	}
+	if err := Delete(ids[1]); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+
+	t.Log("First page")
+	users, err := Page("", 2)
+	if err != nil {
+		t.Fatalf("Error retrieving a page: %s", err)
+	}
+	if len(users) != 2 || users[0].ID != ids[0] || users[1].ID != ids[2] {
+		t.Errorf("Expected users %s and %s, got %+v", ids[0].Hex(), ids[2].Hex(), users)
+	}
+
+	t.Log("Next page")
+	users, err = Page(users[1].ID, 2)
+	if err != nil {
+		t.Fatalf("Error retrieving a page: %s", err)
+	}
+	if len(users) != 2 || users[0].ID != ids[3] || users[1].ID != ids[4] {
+		t.Errorf("Expected users %s and %s, got %+v", ids[3].Hex(), ids[4].Hex(), users)
+	}
+
+	t.Log("Each skips deleted users")
+	n := 0
+	err = Each(func(u *User) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 4 {
+		t.Errorf("Expected 4 users, got %d (%v)", n, err)
+	}
+	os.Remove(DBPath)
+}
@@ -0,0 +1,223 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var (
+	// MaxAttempts is the number of failed attempts after which a delivery
+	// is moved to the dead-letter list
+	MaxAttempts = 8
+	// Backoff is the delay before the first retry; it doubles with every
+	// failed attempt up to MaxBackoff
+	Backoff    = 10 * time.Second
+	MaxBackoff = time.Hour
+	// Client sends the deliveries
+	Client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// pollInterval is how often the outbox is checked when nothing is due
+const pollInterval = time.Minute
+
+var wakeup = make(chan struct{}, 1)
+
+// wake tells the dispatcher new deliveries are due
+func wake() {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Sign returns the signature of a body sent at the given Unix timestamp:
+// the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before retrying after the given number of attempts
+func backoff(attempts int) time.Duration {
+	d := Backoff
+	for i := 1; i < attempts && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	return d
+}
+
+// Dispatch feeds the outbox from the event log of every tenant and sends
+// its deliveries as they become due until ctx is done
+func Dispatch(ctx context.Context) {
+	if err := skip(ctx); err != nil {
+		log.Println("webhook:", err)
+	}
+	changes, stop := user.SubscribeAll()
+	defer func() { stop() }()
+	for {
+		feedAll(ctx)
+		wait := pollInterval
+		next, err := dispatchDue(ctx)
+		if err == nil && !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wakeup:
+			timer.Stop()
+		case _, ok := <-changes:
+			timer.Stop()
+			if !ok {
+				changes, stop = user.SubscribeAll()
+			}
+			// a single pass feeds every change committed so far
+			for len(changes) > 0 {
+				<-changes
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// pending loads the pending deliveries and their subscriptions
+func pending() ([]Delivery, map[bson.ObjectId]Subscription, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer db.Close()
+
+	ds := []Delivery{}
+	err = db.Select(q.Eq("Status", StatusPending)).OrderBy("ID").Find(&ds)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, nil, err
+	}
+	subs := []Subscription{}
+	if err := db.All(&subs); err != nil {
+		return nil, nil, err
+	}
+	byID := map[bson.ObjectId]Subscription{}
+	for _, s := range subs {
+		byID[s.ID] = s
+	}
+	return ds, byID, nil
+}
+
+func update(d *Delivery) error {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.Save(d)
+}
+
+// dispatchDue sends every due delivery and returns when the next one is due
+func dispatchDue(ctx context.Context) (time.Time, error) {
+	ds, subs, err := pending()
+	if err != nil {
+		return time.Time{}, err
+	}
+	var next time.Time
+	for i := range ds {
+		d := &ds[i]
+		if time.Now().Before(d.NextAttempt) {
+			if next.IsZero() || d.NextAttempt.Before(next) {
+				next = d.NextAttempt
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return next, ctx.Err()
+		}
+		s, ok := subs[d.SubscriptionID]
+		if !ok {
+			d.Status = StatusDead
+			d.LastError = "subscription no longer exists"
+		} else {
+			deliver(ctx, &s, d)
+		}
+		if err := update(d); err != nil {
+			return next, err
+		}
+		if d.Status == StatusPending && (next.IsZero() || d.NextAttempt.Before(next)) {
+			next = d.NextAttempt
+		}
+	}
+	return next, nil
+}
+
+// deliver posts the payload once and records the outcome on the delivery
+func deliver(ctx context.Context, s *Subscription, d *Delivery) {
+	d.Attempts++
+	err := send(ctx, s, d)
+	now := time.Now().UTC()
+	if err == nil {
+		d.Status = StatusDelivered
+		d.DeliveredAt = &now
+		d.LastError = ""
+		return
+	}
+	d.LastError = err.Error()
+	if d.Attempts >= MaxAttempts {
+		d.Status = StatusDead
+		return
+	}
+	d.NextAttempt = now.Add(backoff(d.Attempts))
+}
+
+func send(ctx context.Context, s *Subscription, d *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(s.Secret, ts, d.Payload))
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
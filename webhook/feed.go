@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"github.com/asdine/storm/v3"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"log"
+	"time"
+)
+
+// cursor is how far the outbox was fed from the event log of a tenant.
+// Created tells the tenant from an earlier one with the same id.
+type cursor struct {
+	Key     string `storm:"id"`
+	Created time.Time
+	Seq     uint64
+}
+
+// feedPage is the number of events queued per transaction
+const feedPage = 100
+
+// eventTypes are the webhook events of the change events of users
+var eventTypes = map[string]string{
+	user.EventCreated:  UserCreated,
+	user.EventUpdated:  UserUpdated,
+	user.EventDeleted:  UserDeleted,
+	user.EventRestored: UserRestored,
+}
+
+// cursorKey is the key of the cursor of a tenant. The default tenant has an
+// empty id, which storm does not accept.
+func cursorKey(t tenant.Tenant) string {
+	return "tenant:" + t.ID
+}
+
+// position returns the cursor of the tenant carried by ctx, at the start of
+// the log if it was never fed or was created again since
+func position(ctx context.Context) (*cursor, error) {
+	t := tenant.FromContext(ctx)
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	c := new(cursor)
+	err = db.One("Key", cursorKey(t), c)
+	if err == storm.ErrNotFound || (err == nil && !c.Created.Equal(t.CreatedAt)) {
+		return &cursor{Key: cursorKey(t), Created: t.CreatedAt}, nil
+	}
+	return c, err
+}
+
+// skip moves the cursor of every tenant never fed to the end of its log when
+// the dispatcher starts, so that the changes logged before are not sent to
+// the subscriptions and only the changes made from then on are.
+func skip(ctx context.Context) error {
+	return tenant.Each(ctx, func(ctx context.Context) error {
+		c, err := position(ctx)
+		if err != nil || c.Seq > 0 {
+			return err
+		}
+		if c.Seq, err = user.LastSeqContext(ctx); err != nil {
+			return err
+		}
+		db, err := storm.Open(dbPath)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		if err := db.One("Key", c.Key, new(cursor)); err != storm.ErrNotFound {
+			return err
+		}
+		return db.Save(c)
+	})
+}
+
+// feed queues the deliveries of the changes logged in the tenant carried by
+// ctx since it was last fed. The cursor is saved with the deliveries, so
+// each change is queued once even if the process stops in between.
+func feed(ctx context.Context) (bool, error) {
+	c, err := position(ctx)
+	if err != nil {
+		return false, err
+	}
+	queued := false
+	for {
+		evs, err := user.EventsSinceContext(ctx, c.Seq, feedPage)
+		if err != nil || len(evs) == 0 {
+			return queued, err
+		}
+		q, err := feedEvents(ctx, c, evs)
+		if err != nil {
+			return queued, err
+		}
+		queued = queued || q
+	}
+}
+
+// feedEvents queues the deliveries of the events and moves the cursor past
+// them in a single transaction
+func feedEvents(ctx context.Context, c *cursor, evs []user.Event) (bool, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.Close()
+
+	subs := []Subscription{}
+	if err := db.All(&subs); err != nil {
+		return false, err
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	queued := false
+	next := *c
+	for _, ev := range evs {
+		next.Seq = ev.Seq
+		event, ok := eventTypes[ev.Type]
+		if !ok {
+			continue
+		}
+		p := Payload{Event: event, Tenant: tenant.FromContext(ctx).ID, UserID: ev.UserID, Time: ev.Time}
+		if ev.After != nil {
+			p.User = ev.After
+		}
+		q, err := queue(tx, subs, p)
+		if err != nil {
+			return false, err
+		}
+		queued = queued || q
+	}
+	if err := tx.Save(&next); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	*c = next
+	return queued, nil
+}
+
+// feedAll feeds the outbox from the log of every tenant. A tenant that
+// cannot be fed does not keep the others from being fed.
+func feedAll(ctx context.Context) {
+	tenant.Each(ctx, func(ctx context.Context) error {
+		if _, err := feed(ctx); err != nil {
+			log.Println("webhook:", err)
+		}
+		return nil
+	})
+}
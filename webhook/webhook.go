@@ -0,0 +1,315 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"gopkg.in/mgo.v2/bson"
+	"net/url"
+	"time"
+)
+
+// Subscription is an endpoint notified of user lifecycle events
+type Subscription struct {
+	ID        bson.ObjectId `json:"id" storm:"id"`
+	URL       string        `json:"url"`
+	Secret    string        `json:"secret,omitempty"`
+	Events    []string      `json:"events"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// Events subscriptions can listen to
+const (
+	UserCreated  = "user.created"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	UserRestored = "user.restored"
+)
+
+// Events lists every event, in the order they are documented
+var Events = []string{UserCreated, UserUpdated, UserDeleted, UserRestored}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Delivery is a notification waiting in the outbox or already sent
+type Delivery struct {
+	ID             uint64          `json:"id" storm:"id,increment"`
+	SubscriptionID bson.ObjectId   `json:"subscription_id" storm:"index"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status" storm:"index"`
+	Attempts       int             `json:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Payload is the body posted to subscribers. Subscriptions belong to the
+// deployment, so payloads name the tenant of the user.
+type Payload struct {
+	Event  string        `json:"event"`
+	Tenant string        `json:"tenant,omitempty"`
+	UserID bson.ObjectId `json:"user_id"`
+	User   interface{}   `json:"user,omitempty"`
+	Time   time.Time     `json:"time"`
+}
+
+// dbPath is the outbox and subscriptions database of the deployment
+var dbPath = "webhooks.db"
+
+// Errors used by webhooks
+var (
+	// Returns ErrInvalidSubscription when a subscription has no valid URL
+	ErrInvalidSubscription = errors.New("subscription is invalid")
+)
+
+// Validate checks if the subscription targets an absolute http(s) URL and
+// only lists known events
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidSubscription
+	}
+	for _, e := range s.Events {
+		if e != "*" && !known(e) {
+			return ErrInvalidSubscription
+		}
+	}
+	return nil
+}
+
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Create stores a new subscription, generating its secret if none is given
+func (s *Subscription) Create() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		s.Secret = hex.EncodeToString(b)
+	}
+	s.ID = bson.NewObjectId()
+	s.CreatedAt = time.Now().UTC()
+
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return db.Save(s)
+}
+
+// Subscriptions retrieves all subscriptions
+func Subscriptions() ([]Subscription, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	subs := []Subscription{}
+	err = db.All(&subs)
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// One returns a single subscription
+func One(id bson.ObjectId) (*Subscription, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	s := new(Subscription)
+	err = db.One("ID", id, s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Delete removes a subscription and its pending deliveries
+func Delete(id bson.ObjectId) error {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	s := new(Subscription)
+	if err := tx.One("ID", id, s); err != nil {
+		return err
+	}
+	if err := tx.DeleteStruct(s); err != nil {
+		return err
+	}
+	err = tx.Select(q.Eq("SubscriptionID", id), q.Eq("Status", StatusPending)).Delete(new(Delivery))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Deliveries lists the deliveries of a subscription, optionally filtered by
+// status, most recent first
+func Deliveries(id bson.ObjectId, status string) ([]Delivery, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	matchers := []q.Matcher{q.Eq("SubscriptionID", id)}
+	if status != "" {
+		matchers = append(matchers, q.Eq("Status", status))
+	}
+	ds := []Delivery{}
+	err = db.Select(matchers...).OrderBy("ID").Reverse().Find(&ds)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Redeliver queues a delivery again, whatever its status
+func Redeliver(id uint64) (*Delivery, error) {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	d := new(Delivery)
+	if err := db.One("ID", id, d); err != nil {
+		return nil, err
+	}
+	d.Status = StatusPending
+	d.Attempts = 0
+	d.NextAttempt = time.Now().UTC()
+	d.LastError = ""
+	if err := db.Save(d); err != nil {
+		return nil, err
+	}
+	wake()
+	return d, nil
+}
+
+func subscribed(s *Subscription, event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues a delivery of the event to every subscription interested in
+// it. The deliveries are stored in the outbox before Notify returns and are
+// sent by the dispatcher. Changes to users need not be notified: the
+// dispatcher queues them from the event log.
+func Notify(event string, id bson.ObjectId, u interface{}) error {
+	return NotifyContext(context.Background(), event, id, u)
+}
+
+// NotifyContext is Notify for a user of the tenant carried by ctx
+func NotifyContext(ctx context.Context, event string, id bson.ObjectId, u interface{}) error {
+	db, err := storm.Open(dbPath)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	subs := []Subscription{}
+	if err := db.All(&subs); err != nil {
+		return err
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p := Payload{Event: event, Tenant: tenant.FromContext(ctx).ID, UserID: id, User: u, Time: time.Now().UTC()}
+	queued, err := queue(tx, subs, p)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if queued {
+		wake()
+	}
+	return nil
+}
+
+// queue stores within the transaction a delivery of the payload to every
+// subscription interested in its event, and reports whether there was any
+func queue(tx storm.Node, subs []Subscription, p Payload) (bool, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	queued := false
+	for i := range subs {
+		if !subscribed(&subs[i], p.Event) {
+			continue
+		}
+		d := &Delivery{
+			SubscriptionID: subs[i].ID,
+			Event:          p.Event,
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttempt:    now,
+			CreatedAt:      now,
+		}
+		if err := tx.Save(d); err != nil {
+			return false, err
+		}
+		queued = true
+	}
+	return queued, nil
+}
@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/christianotieno/go-rest-api/tenant"
+	"github.com/christianotieno/go-rest-api/user"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	os.Remove(dbPath)
+	Backoff = 0
+	code := m.Run()
+	os.Remove(dbPath)
+	os.Remove(user.DBPath)
+	os.Remove(tenant.DBPath)
+	os.Exit(code)
+}
+
+// receiver records the requests it receives and answers with status
+type receiver struct {
+	sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bd, _ := io.ReadAll(r.Body)
+	rc.Lock()
+	defer rc.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, bd)
+	w.WriteHeader(rc.status)
+}
+
+func subscribe(t *testing.T, url string, events ...string) *Subscription {
+	t.Helper()
+	s := &Subscription{URL: url, Events: events}
+	if err := s.Create(); err != nil {
+		t.Fatalf("Error creating a subscription: %s", err)
+	}
+	t.Cleanup(func() { Delete(s.ID) })
+	return s
+}
+
+func deliveries(t *testing.T, id bson.ObjectId, status string) []Delivery {
+	t.Helper()
+	ds, err := Deliveries(id, status)
+	if err != nil {
+		t.Fatalf("Error listing deliveries: %s", err)
+	}
+	return ds
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		txt    string
+		s      Subscription
+		expect error
+	}{
+		{"valid https URL", Subscription{URL: "https://crm.example.com/hook"}, nil},
+		{"valid events", Subscription{URL: "http://crm", Events: []string{UserCreated, "*"}}, nil},
+		{"relative URL", Subscription{URL: "/hook"}, ErrInvalidSubscription},
+		{"unsupported scheme", Subscription{URL: "ftp://crm/hook"}, ErrInvalidSubscription},
+		{"unknown event", Subscription{URL: "http://crm", Events: []string{"user.renamed"}}, ErrInvalidSubscription},
+	}
+	for _, tc := range cases {
+		t.Log(tc.txt)
+		if err := tc.s.Validate(); err != tc.expect {
+			t.Errorf("Expected %v, got %v", tc.expect, err)
+		}
+	}
+}
+
+func TestDeliverySigned(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s := subscribe(t, srv.URL, UserCreated)
+
+	id := bson.NewObjectId()
+	if err := Notify(UserCreated, id, map[string]string{"name": "Ann"}); err != nil {
+		t.Fatalf("Error notifying: %s", err)
+	}
+	if err := Notify(UserDeleted, id, nil); err != nil {
+		t.Fatalf("Error notifying: %s", err)
+	}
+	if _, err := dispatchDue(context.Background()); err != nil {
+		t.Fatalf("Error dispatching: %s", err)
+	}
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(rc.requests))
+	}
+	r, bd := rc.requests[0], rc.bodies[0]
+	ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("Invalid timestamp header: %s", err)
+	}
+	if sig := r.Header.Get(SignatureHeader); sig != Sign(s.Secret, ts, bd) {
+		t.Errorf("Expected signature %s, got %s", Sign(s.Secret, ts, bd), sig)
+	}
+	if ev := r.Header.Get(EventHeader); ev != UserCreated {
+		t.Errorf("Expected event %s, got %s", UserCreated, ev)
+	}
+	p := Payload{}
+	if err := json.Unmarshal(bd, &p); err != nil {
+		t.Fatalf("Invalid payload: %s", err)
+	}
+	if p.Event != UserCreated || p.UserID != id {
+		t.Errorf("Expected %s of %s, got %s of %s", UserCreated, id.Hex(), p.Event, p.UserID.Hex())
+	}
+	ds := deliveries(t, s.ID, StatusDelivered)
+	if len(ds) != 1 || ds[0].Attempts != 1 || ds[0].DeliveredAt == nil {
+		t.Errorf("Expected 1 delivered delivery after 1 attempt, got %+v", ds)
+	}
+}
+
+func TestDeadLetterAndRedeliver(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s := subscribe(t, srv.URL)
+
+	if err := Notify(UserUpdated, bson.NewObjectId(), nil); err != nil {
+		t.Fatalf("Error notifying: %s", err)
+	}
+	for i := 0; i < MaxAttempts+2; i++ {
+		if _, err := dispatchDue(context.Background()); err != nil {
+			t.Fatalf("Error dispatching: %s", err)
+		}
+	}
+	if len(rc.requests) != MaxAttempts {
+		t.Errorf("Expected %d attempts, got %d", MaxAttempts, len(rc.requests))
+	}
+	dead := deliveries(t, s.ID, StatusDead)
+	if len(dead) != 1 || dead[0].LastError == "" {
+		t.Fatalf("Expected 1 dead delivery with an error, got %+v", dead)
+	}
+
+	rc.status = http.StatusOK
+	if _, err := Redeliver(dead[0].ID); err != nil {
+		t.Fatalf("Error redelivering: %s", err)
+	}
+	if _, err := dispatchDue(context.Background()); err != nil {
+		t.Fatalf("Error dispatching: %s", err)
+	}
+	if ds := deliveries(t, s.ID, StatusDelivered); len(ds) != 1 || ds[0].ID != dead[0].ID {
+		t.Errorf("Expected delivery %d delivered, got %+v", dead[0].ID, ds)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	os.Remove(user.DBPath)
+	old := &user.User{ID: bson.NewObjectId(), Name: "Old", Role: "Tester"}
+	if err := old.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	if err := skip(context.Background()); err != nil {
+		t.Fatalf("Error skipping the log: %s", err)
+	}
+	rc := &receiver{status: http.StatusNoContent}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s := subscribe(t, srv.URL)
+
+	t.Log("Changes logged before the outbox was fed are skipped")
+	u := &user.User{ID: bson.NewObjectId(), Name: "Ann", Role: "Tester"}
+	if err := u.Save(); err != nil {
+		t.Fatalf("Error saving a user: %s", err)
+	}
+	if err := user.Delete(u.ID); err != nil {
+		t.Fatalf("Error deleting a user: %s", err)
+	}
+	if _, err := feed(context.Background()); err != nil {
+		t.Fatalf("Error feeding the outbox: %s", err)
+	}
+	ds := deliveries(t, s.ID, StatusPending)
+	if len(ds) != 2 || ds[0].Event != UserDeleted || ds[1].Event != UserCreated {
+		t.Fatalf("Expected the deletion and creation of Ann to be queued, got %+v", ds)
+	}
+	p := Payload{}
+	if err := json.Unmarshal(ds[1].Payload, &p); err != nil || p.UserID != u.ID || p.User == nil {
+		t.Errorf("Expected the payload to carry Ann, got %s (%v)", ds[1].Payload, err)
+	}
+
+	t.Log("Changes are queued once")
+	if _, err := feed(context.Background()); err != nil {
+		t.Fatalf("Error feeding the outbox: %s", err)
+	}
+	if ds := deliveries(t, s.ID, StatusPending); len(ds) != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", len(ds))
+	}
+	os.Remove(user.DBPath)
+}
+
+func TestBackoff(t *testing.T) {
+	defer func(b time.Duration) { Backoff = b }(Backoff)
+	Backoff = time.Second
+	cases := []struct {
+		attempts int
+		expect   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{30, MaxBackoff},
+	}
+	for _, tc := range cases {
+		t.Logf("after %d attempts", tc.attempts)
+		if d := backoff(tc.attempts); d != tc.expect {
+			t.Errorf("Expected %s, got %s", tc.expect, d)
+		}
+	}
+}